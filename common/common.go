@@ -4,12 +4,18 @@ import (
 	"log"
 	"net/url"
 	"strconv"
+	"time"
 )
 
 type ProxyCheckResult struct {
 	ProxyAddr  string
 	Success    bool
 	SuccessURL string
+	Latency    time.Duration
+	Probed     bool
+	Throughput *float64
+	UDPChecked bool
+	UDP        bool
 	Error      error
 }
 
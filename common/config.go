@@ -3,22 +3,32 @@ package common
 import (
 	"fmt"
 	"gopkg.in/yaml.v2"
-	"io/ioutil"
 	"log"
 	"os"
+	"path"
 	"proxychain/utils"
+	"strings"
+	"sync/atomic"
 )
 
 // Config 是用于存储配置的结构体
 type Config struct {
 	Server struct {
-		Host string `yaml:"host"`
-		Port string `yaml:"port"`
+		Host            string `yaml:"host"`
+		Port            string `yaml:"port"`
+		ShutdownTimeout int    `yaml:"shutdownTimeout"` // 退出时等待活动连接结束的最长时间，单位秒
 	} `yaml:"server"`
 
 	Database struct {
 		Type string `yaml:"type"`
 		Path string `yaml:"path"`
+		DSN  string `yaml:"dsn"` // 网络数据库的连接串，type 为 postgres 时使用
+
+		WriteBehind struct {
+			Enabled       bool `yaml:"enabled"`       // 是否批量写入优先级变化与检测历史
+			FlushInterval int  `yaml:"flushInterval"` // 批量写入的间隔，单位毫秒
+			MaxPending    int  `yaml:"maxPending"`    // 累积的变化达到该数量时立即写入
+		} `yaml:"writeBehind"`
 	} `yaml:"database"`
 
 	Hunter struct {
@@ -38,34 +48,193 @@ type Config struct {
 		PriorityDownNum    int    `yaml:"priorityDownNum"`    // 可信度每次减少的值
 		PriorityUpNum      int    `yaml:"priorityUpNum"`      // 可信度每次增加的值
 	}
+
+	Checker struct {
+		Concurrency   int `yaml:"concurrency"`   // 健康检测的最大并发数
+		Timeout       int `yaml:"timeout"`       // 单次检测请求的超时时间，单位秒
+		FreshInterval int `yaml:"freshInterval"` // 新代理的复检间隔，单位秒
+		Interval      int `yaml:"interval"`      // 健康代理的复检间隔，单位秒
+		MaxBackoff    int `yaml:"maxBackoff"`    // 失败代理退避的最大间隔，单位秒
+	} `yaml:"checker"`
+
+	Throughput struct {
+		Enabled       bool   `yaml:"enabled"`       // 是否启用吞吐量探测
+		URL           string `yaml:"url"`           // 测速下载地址
+		Size          int    `yaml:"size"`          // 每次下载的字节数
+		Timeout       int    `yaml:"timeout"`       // 单次探测的超时时间，单位秒
+		Interval      int    `yaml:"interval"`      // 同一代理两次探测的间隔，单位秒
+		MinThroughput int    `yaml:"minThroughput"` // 选择代理时要求的最低带宽，单位 KB/s，0 表示不限制
+	} `yaml:"throughput"`
+
+	UDP struct {
+		Enabled  bool   `yaml:"enabled"`  // 是否检测 SOCKS5 代理的 UDP 能力
+		EchoAddr string `yaml:"echoAddr"` // UDP 回显服务器地址，为空时只检测 UDP ASSOCIATE 协商
+		Timeout  int    `yaml:"timeout"`  // 单次检测的超时时间，单位秒
+		Interval int    `yaml:"interval"` // 同一代理两次检测的间隔，单位秒
+	} `yaml:"udp"`
+
+	Score struct {
+		Min           int     `yaml:"min"`           // 可信度下限，降到下限的代理移入隔离区
+		Max           int     `yaml:"max"`           // 可信度上限
+		Neutral       int     `yaml:"neutral"`       // 新代理的初始可信度，也是衰减的目标
+		HalfLife      int     `yaml:"halfLife"`      // 偏离中性值的部分衰减一半所需的时间，单位小时
+		CheckWeight   float64 `yaml:"checkWeight"`   // 健康检测结果的权重
+		TrafficWeight float64 `yaml:"trafficWeight"` // 实际流量结果的权重
+	} `yaml:"score"`
+
+	// Penalties 按错误分类设置每次扣减的可信度，未配置的分类使用 priorityDownNum，客户端错误不扣减
+	Penalties map[string]int `yaml:"penalties"`
+
+	Premium struct {
+		Enabled         bool    `yaml:"enabled"`         // 是否启用高级代理池
+		Port            string  `yaml:"port"`            // 高级代理池专用的监听端口，为空时不单独监听
+		UserTag         string  `yaml:"userTag"`         // 用户名中请求高级代理池的标记
+		Fallback        bool    `yaml:"fallback"`        // 高级代理池为空时是否回退到普通代理池
+		PromotePriority int     `yaml:"promotePriority"` // 晋升所需的最低可信度
+		PromoteUptime   float64 `yaml:"promoteUptime"`   // 晋升所需的最低可用率，百分比
+		DemotePriority  int     `yaml:"demotePriority"`  // 可信度低于该值时降级
+		DemoteUptime    float64 `yaml:"demoteUptime"`    // 可用率低于该值时降级，百分比
+		MinRecords      int     `yaml:"minRecords"`      // 统计窗口内至少需要的记录数，不足时不晋升
+	} `yaml:"premium"`
+
+	Quarantine struct {
+		Retention       int `yaml:"retention"`       // 代理在隔离区中保留的时长，超过后永久删除，单位小时
+		RecheckInterval int `yaml:"recheckInterval"` // 隔离区代理的复检间隔，单位秒
+		RestorePriority int `yaml:"restorePriority"` // 复检通过后恢复的可信度
+	} `yaml:"quarantine"`
+
+	Upstream struct {
+		MaxConnections int     `yaml:"maxConnections"` // 每个上游代理同时转发的最大连接数，0 表示不限制
+		MaxRPS         float64 `yaml:"maxRPS"`         // 每个上游代理每秒最多新建的连接数，0 表示不限制
+	} `yaml:"upstream"`
+
+	// Targets 按目标域名限制经代理池发出的连接，按顺序使用第一条匹配的规则
+	Targets []TargetLimit `yaml:"targets"`
+
+	Admin struct {
+		Enabled bool   `yaml:"enabled"` // 是否启用管理接口
+		Listen  string `yaml:"listen"`  // 管理接口的监听地址，与代理端口分开
+		Token   string `yaml:"token"`   // 访问管理接口的令牌
+	} `yaml:"admin"`
+
+	Metrics struct {
+		Enabled  bool `yaml:"enabled"`  // 是否在管理接口上提供 Prometheus 格式的 /metrics
+		Public   bool `yaml:"public"`   // 访问 /metrics 是否无需令牌
+		PerProxy bool `yaml:"perProxy"` // 是否输出按代理地址区分的指标
+	} `yaml:"metrics"`
+
+	Log struct {
+		Format string            `yaml:"format"` // 日志格式，text 或 json
+		Level  string            `yaml:"level"`  // 默认日志级别
+		Levels map[string]string `yaml:"levels"` // 各子系统的日志级别，未设置的子系统使用 Level
+	} `yaml:"log"`
+
+	Auth struct {
+		Users map[string]User `yaml:"users"` // 允许使用代理的用户，以用户名为键，为空时不要求认证
+	} `yaml:"auth"`
+
+	AccessLog struct {
+		Enabled        bool   `yaml:"enabled"`        // 是否记录访问日志
+		Path           string `yaml:"path"`           // 访问日志的文件路径
+		MaxSize        int    `yaml:"maxSize"`        // 单个文件超过该大小后轮转，单位 MB
+		RotateInterval int    `yaml:"rotateInterval"` // 文件打开超过该时长后轮转，单位小时
+		MaxBackups     int    `yaml:"maxBackups"`     // 保留的轮转文件数量
+	} `yaml:"accessLog"`
+
+	History struct {
+		Retention    int `yaml:"retention"`    // 检测历史的保留时长，单位小时
+		UptimeWindow int `yaml:"uptimeWindow"` // 统计可用率的时间窗口，单位小时
+	} `yaml:"history"`
 }
 
-// GlobalConfig 用于存储全局配置
-var GlobalConfig Config
+// User 允许使用代理的客户端用户，各项限额为 0 时不限制
+type User struct {
+	Password       string `yaml:"password"`
+	RequestsPerDay int    `yaml:"requestsPerDay"` // 每天最多的请求数
+	BytesPerDay    int    `yaml:"bytesPerDay"`    // 每天最多的流量，包括上行与下行，单位 MB
+	MaxConnections int    `yaml:"maxConnections"` // 同时最多的连接数
+}
 
-// LoadConfig 从yaml 配置文件中读取一些全局配置
-func LoadConfig() {
+// TargetLimit 目标域名的限制规则，匹配同一条规则的所有域名共享限制，各项限制为 0 时不限制
+type TargetLimit struct {
+	Pattern        string  `yaml:"pattern"`        // 目标域名，支持 * 通配符，*.example.com 同时匹配 example.com 与其子域名
+	MaxRPS         float64 `yaml:"maxRPS"`         // 每秒最多新建的连接数
+	MaxConnections int     `yaml:"maxConnections"` // 同时最多的连接数
+	QueueTimeout   int     `yaml:"queueTimeout"`   // 超出限制时排队等待的最长时间，单位毫秒，0 表示立即拒绝
+}
 
-	filePath := "./config.yaml"
+// Match 判断目标域名是否匹配规则，域名不区分大小写
+func (t TargetLimit) Match(host string) bool {
+	pattern, host := strings.ToLower(t.Pattern), strings.ToLower(host)
+	if strings.HasPrefix(pattern, "*.") && host == pattern[2:] {
+		return true
+	}
+	matched, _ := path.Match(pattern, host)
+	return matched
+}
+
+// current 当前生效的全局配置，重新加载时整体替换
+var current atomic.Pointer[Config]
 
-	// 检测文件是否存在
-	exists := utils.FileExists(filePath)
-	if !exists {
-		log.Fatal("config.yaml 配置文件不存在，终止退出程序！")
-		os.Exit(1)
+// configPath 加载配置文件的路径，重新加载时使用
+var configPath string
+
+// Current 返回当前生效的全局配置，返回的配置只读，同一次操作中多次读取时应保存返回值，避免前后读到不同版本的配置
+func Current() *Config {
+	if cfg := current.Load(); cfg != nil {
+		return cfg
 	}
+	return &Config{}
+}
 
-	// 读取文件内容
-	data, err := ioutil.ReadFile(filePath)
+// SetConfig 替换当前生效的全局配置
+func SetConfig(cfg Config) {
+	current.Store(&cfg)
+	applyLogLevels(&cfg)
+}
+
+// DefaultConfigPath 未指定配置文件时使用的路径
+const DefaultConfigPath = "config.yaml"
+
+// LoadConfig 从yaml 配置文件中读取全局配置，filePath 为空时读取当前目录下的 config.yaml，配置不合法时终止程序
+func LoadConfig(filePath string) {
+	if filePath == "" {
+		filePath = DefaultConfigPath
+	}
+
+	cfg, err := ReadConfig(filePath)
 	if err != nil {
-		log.Fatalf("无法读取配置文件: %v", err)
+		log.Fatalf("加载配置文件 %s 失败:\n%v", filePath, err)
+	}
+	SetupLogging(cfg.Log.Format, os.Stderr)
+	SetConfig(cfg)
+	configPath = filePath
+
+	// 日志输出到标准错误，避免混入导出到标准输出的数据，API key 等敏感配置不会输出
+	// 完整的配置只在 DEBUG 级别输出，serve 启动时另行输出生效的配置，其他子命令的输出保持简洁
+	Logger(LogServer).Debug("配置已加载", "path", filePath, "config", fmt.Sprintf("%+v", cfg.Redacted()))
+}
+
+// ReadConfig 读取并校验配置文件，缺省的项使用默认值，敏感配置可以由 PROXYCHAIN_* 环境变量覆盖
+func ReadConfig(filePath string) (Config, error) {
+	if !utils.FileExists(filePath) {
+		return Config{}, fmt.Errorf("配置文件 %s 不存在", filePath)
 	}
 
-	// 解析 YAML 内容
-	err = yaml.Unmarshal(data, &GlobalConfig)
+	data, err := os.ReadFile(filePath)
 	if err != nil {
-		log.Fatalf("无法解析配置文件: %v", err)
+		return Config{}, fmt.Errorf("无法读取配置文件: %w", err)
+	}
+
+	// 使用严格模式解析，拼写错误的配置项会报错而不是被忽略
+	cfg := DefaultConfig()
+	if err := yaml.UnmarshalStrict(data, &cfg); err != nil {
+		return Config{}, fmt.Errorf("无法解析配置文件: %w", err)
 	}
 
-	fmt.Printf("配置已加载: %+v\n", GlobalConfig)
+	applyEnv(&cfg)
+	if err := cfg.Validate(); err != nil {
+		return Config{}, err
+	}
+	return cfg, nil
 }
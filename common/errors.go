@@ -0,0 +1,144 @@
+package common
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"syscall"
+)
+
+// ErrorClass 表示一次失败的分类，用于统计失败原因以及决定扣减的可信度
+type ErrorClass string
+
+const (
+	ErrorClassNone              ErrorClass = ""                   // 没有错误
+	ErrorClassClient            ErrorClass = "client"             // 客户端请求异常，与代理无关
+	ErrorClassConnectRefused    ErrorClass = "connect_refused"    // 上游代理拒绝连接
+	ErrorClassAuthRequired      ErrorClass = "auth_required"      // 上游代理要求认证
+	ErrorClassConnectRejected   ErrorClass = "connect_rejected"   // 上游代理拒绝了 CONNECT 请求
+	ErrorClassTargetUnreachable ErrorClass = "target_unreachable" // 目标地址不可达，包括上游的 DNS 解析失败
+	ErrorClassTimeout           ErrorClass = "timeout"            // 超时
+	ErrorClassTLS               ErrorClass = "tls"                // TLS 握手或证书错误
+	ErrorClassUnknown           ErrorClass = "unknown"            // 无法归类的错误
+)
+
+// ClientError 包装由客户端引起的错误，这类错误不会降低代理的可信度
+type ClientError struct {
+	Err error
+}
+
+func (e *ClientError) Error() string {
+	return "客户端错误: " + e.Err.Error()
+}
+
+func (e *ClientError) Unwrap() error {
+	return e.Err
+}
+
+// ConnectError 表示上游 HTTP 代理对 CONNECT 请求返回了非 200 的状态码
+type ConnectError struct {
+	StatusCode int
+	Status     string
+}
+
+func (e *ConnectError) Error() string {
+	return "HTTP 代理连接失败: " + e.Status
+}
+
+// ClassifyError 对错误进行分类
+func ClassifyError(err error) ErrorClass {
+	if err == nil {
+		return ErrorClassNone
+	}
+
+	var clientErr *ClientError
+	if errors.As(err, &clientErr) {
+		return ErrorClassClient
+	}
+
+	var connectErr *ConnectError
+	if errors.As(err, &connectErr) {
+		switch connectErr.StatusCode {
+		case http.StatusProxyAuthRequired, http.StatusUnauthorized:
+			return ErrorClassAuthRequired
+		case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return ErrorClassTargetUnreachable
+		default:
+			return ErrorClassConnectRejected
+		}
+	}
+
+	// 与上游代理之间的 TCP 连接被拒绝
+	if errors.Is(err, syscall.ECONNREFUSED) {
+		return ErrorClassConnectRefused
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return ErrorClassTimeout
+	}
+
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return ErrorClassTargetUnreachable
+	}
+
+	if isTLSError(err) {
+		return ErrorClassTLS
+	}
+
+	// SOCKS5 代理的应答错误只能通过错误信息区分
+	msg := err.Error()
+	switch {
+	case strings.Contains(msg, "no acceptable authentication methods"),
+		strings.Contains(msg, "invalid username/password"):
+		return ErrorClassAuthRequired
+	case strings.Contains(msg, "host unreachable"),
+		strings.Contains(msg, "network unreachable"),
+		strings.Contains(msg, "connection refused"),
+		strings.Contains(msg, "TTL expired"):
+		return ErrorClassTargetUnreachable
+	case strings.Contains(msg, "not allowed by ruleset"),
+		strings.Contains(msg, "general SOCKS server failure"),
+		strings.Contains(msg, "command not supported"),
+		strings.Contains(msg, "address type not supported"):
+		return ErrorClassConnectRejected
+	}
+
+	return ErrorClassUnknown
+}
+
+// isTLSError 判断错误是否来自 TLS 握手或证书校验
+func isTLSError(err error) bool {
+	var recordErr tls.RecordHeaderError
+	var alertErr tls.AlertError
+	var verifyErr *tls.CertificateVerificationError
+	var unknownAuthErr x509.UnknownAuthorityError
+	var invalidErr x509.CertificateInvalidError
+	var hostnameErr x509.HostnameError
+
+	return errors.As(err, &recordErr) ||
+		errors.As(err, &alertErr) ||
+		errors.As(err, &verifyErr) ||
+		errors.As(err, &unknownAuthErr) ||
+		errors.As(err, &invalidErr) ||
+		errors.As(err, &hostnameErr) ||
+		strings.Contains(err.Error(), "tls: ")
+}
+
+// Penalty 返回该分类的失败需要扣减的可信度，客户端错误不扣减
+// 未在配置中单独设置的分类使用 priorityDownNum
+func (c ErrorClass) Penalty() int {
+	if c == ErrorClassNone || c == ErrorClassClient {
+		return 0
+	}
+
+	if penalty, ok := Current().Penalties[string(c)]; ok {
+		return penalty
+	}
+
+	return Current().Config.PriorityDownNum
+}
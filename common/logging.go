@@ -0,0 +1,132 @@
+package common
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync/atomic"
+)
+
+// 日志的子系统，每个子系统可以单独设置日志级别
+const (
+	LogServer    = "server"    // 服务启动与退出、定时任务、配置与管理接口
+	LogListener  = "listener"  // 客户端连接与转发
+	LogChecker   = "checker"   // 健康检测、吞吐量探测与隔离区复检
+	LogHarvester = "harvester" // 从 hunter 与 fofa 获取代理
+	LogStorage   = "storage"   // 数据库与批量写入
+)
+
+// 日志格式
+const (
+	LogFormatText = "text"
+	LogFormatJSON = "json"
+)
+
+// LogSubsystems 全部日志子系统
+var LogSubsystems = []string{LogServer, LogListener, LogChecker, LogHarvester, LogStorage}
+
+var (
+	// logHandler 实际输出日志的处理器，配置加载后按格式替换
+	logHandler atomic.Pointer[slog.Handler]
+	// logLevels 各子系统当前的日志级别，配置重新加载时更新
+	logLevels = make(map[string]*slog.LevelVar)
+)
+
+func init() {
+	for _, subsystem := range LogSubsystems {
+		logLevels[subsystem] = new(slog.LevelVar)
+	}
+	SetupLogging(LogFormatText, os.Stderr)
+}
+
+// Logger 返回子系统的日志记录器，可以在配置加载之前创建，格式与级别随配置生效
+func Logger(subsystem string) *slog.Logger {
+	level, ok := logLevels[subsystem]
+	if !ok {
+		level = logLevels[LogServer]
+	}
+	return slog.New(&subsystemHandler{level: level}).With("subsystem", subsystem)
+}
+
+// SetupLogging 按格式创建日志处理器，标准库 log 包的输出也转到 server 子系统
+func SetupLogging(format string, w io.Writer) {
+	options := &slog.HandlerOptions{Level: slog.LevelDebug}
+
+	var handler slog.Handler
+	if format == LogFormatJSON {
+		// JSON 默认将时长输出为纳秒数，改为与文本格式一致的 "1.5s"
+		options.ReplaceAttr = func(_ []string, a slog.Attr) slog.Attr {
+			if a.Value.Kind() == slog.KindDuration {
+				return slog.String(a.Key, a.Value.Duration().String())
+			}
+			return a
+		}
+		handler = slog.NewJSONHandler(w, options)
+	} else {
+		handler = slog.NewTextHandler(w, options)
+	}
+	logHandler.Store(&handler)
+
+	slog.SetDefault(Logger(LogServer))
+}
+
+// applyLogLevels 按配置设置各子系统的日志级别，未单独设置的子系统使用 log.level
+func applyLogLevels(cfg *Config) {
+	for subsystem, level := range logLevels {
+		name := cfg.Log.Levels[subsystem]
+		if name == "" {
+			name = cfg.Log.Level
+		}
+		parsed, _ := parseLogLevel(name)
+		level.Set(parsed)
+	}
+}
+
+// parseLogLevel 解析 debug、info、warn、error，空字符串表示 info
+func parseLogLevel(name string) (slog.Level, bool) {
+	switch strings.ToLower(name) {
+	case "debug":
+		return slog.LevelDebug, true
+	case "", "info":
+		return slog.LevelInfo, true
+	case "warn", "warning":
+		return slog.LevelWarn, true
+	case "error":
+		return slog.LevelError, true
+	default:
+		return slog.LevelInfo, false
+	}
+}
+
+// subsystemHandler 按子系统的级别过滤日志，再交给当前的日志处理器输出
+// 处理器在配置加载后才确定，With 添加的属性与分组在输出时再应用
+type subsystemHandler struct {
+	level *slog.LevelVar
+	with  []func(slog.Handler) slog.Handler
+}
+
+func (h *subsystemHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+func (h *subsystemHandler) Handle(ctx context.Context, record slog.Record) error {
+	handler := *logHandler.Load()
+	for _, with := range h.with {
+		handler = with(handler)
+	}
+	return handler.Handle(ctx, record)
+}
+
+func (h *subsystemHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return h.extend(func(handler slog.Handler) slog.Handler { return handler.WithAttrs(attrs) })
+}
+
+func (h *subsystemHandler) WithGroup(name string) slog.Handler {
+	return h.extend(func(handler slog.Handler) slog.Handler { return handler.WithGroup(name) })
+}
+
+func (h *subsystemHandler) extend(with func(slog.Handler) slog.Handler) slog.Handler {
+	return &subsystemHandler{level: h.level, with: append(h.with[:len(h.with):len(h.with)], with)}
+}
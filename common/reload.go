@@ -0,0 +1,181 @@
+package common
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// restartFields 需要重新监听端口、重新打开数据库或重建检测器才能生效的配置项，
+// 重新加载时保留原来的值，在重启后生效
+var restartFields = []string{
+	"server.",
+	"database.",
+	"premium.port",
+	"checker.",
+	"throughput.",
+	"udp.",
+	"quarantine.recheckInterval",
+	"admin.enabled",
+	"admin.listen",
+	"log.format",
+}
+
+// overrides 命令行参数对配置文件的修改，例如 --db，重新加载配置文件时再次应用
+var overrides []func(cfg *Config)
+
+// Override 使用命令行参数修改当前配置，重新加载配置文件后同样生效，命令行参数始终优先于配置文件
+// 只在启动时、开始重新加载配置之前调用
+func Override(apply func(cfg *Config)) {
+	cfg := *Current()
+	apply(&cfg)
+	SetConfig(cfg)
+	overrides = append(overrides, apply)
+}
+
+// ConfigPath 返回加载配置文件的路径
+func ConfigPath() string {
+	return configPath
+}
+
+// ReloadConfig 重新读取配置文件并替换当前配置，配置不合法时保持当前配置不变
+// 返回生效的变更，以及因需要重启而被忽略的配置项
+func ReloadConfig() (changes []string, ignored []string, err error) {
+	cfg, err := ReadConfig(configPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, apply := range overrides {
+		apply(&cfg)
+	}
+
+	old := Current()
+	changes, ignored = diffConfig(old, &cfg)
+	if len(changes) > 0 {
+		SetConfig(cfg)
+	}
+	return changes, ignored, nil
+}
+
+// diffConfig 比较新旧配置的每一个配置项，需要重启才能生效的配置项恢复为旧值
+func diffConfig(old, cfg *Config) (changes []string, ignored []string) {
+	secrets := make(map[string]bool)
+	for _, env := range secretEnv {
+		secrets[env.path] = true
+	}
+
+	oldFields := configFields(reflect.ValueOf(old).Elem())
+	for i, field := range configFields(reflect.ValueOf(cfg).Elem()) {
+		oldValue := oldFields[i].value
+		if reflect.DeepEqual(oldValue.Interface(), field.value.Interface()) {
+			continue
+		}
+
+		if needsRestart(field.path) {
+			field.value.Set(oldValue)
+			ignored = append(ignored, field.path)
+			continue
+		}
+
+		switch {
+		case secrets[field.path]:
+			changes = append(changes, fmt.Sprintf("%s: 已修改", field.path))
+		case field.path == "auth.users":
+			changes = append(changes, diffUsers(old.Auth.Users, cfg.Auth.Users)...)
+		case field.value.Kind() == reflect.Map:
+			changes = append(changes, diffMap(field.path, oldValue, field.value)...)
+		default:
+			changes = append(changes, fmt.Sprintf("%s: %v -> %v", field.path, oldValue.Interface(), field.value.Interface()))
+		}
+	}
+	return changes, ignored
+}
+
+// diffMap 逐个键比较 map 类型的配置项，例如 penalties
+func diffMap(path string, old, cfg reflect.Value) []string {
+	keys := make(map[string]reflect.Value)
+	for _, m := range []reflect.Value{old, cfg} {
+		for _, key := range m.MapKeys() {
+			keys[fmt.Sprint(key.Interface())] = key
+		}
+	}
+	names := make([]string, 0, len(keys))
+	for name := range keys {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var changes []string
+	for _, name := range names {
+		oldValue, newValue := old.MapIndex(keys[name]), cfg.MapIndex(keys[name])
+		switch {
+		case !oldValue.IsValid():
+			changes = append(changes, fmt.Sprintf("%s.%s: 未设置 -> %v", path, name, newValue.Interface()))
+		case !newValue.IsValid():
+			changes = append(changes, fmt.Sprintf("%s.%s: %v -> 未设置", path, name, oldValue.Interface()))
+		case !reflect.DeepEqual(oldValue.Interface(), newValue.Interface()):
+			changes = append(changes, fmt.Sprintf("%s.%s: %v -> %v", path, name, oldValue.Interface(), newValue.Interface()))
+		}
+	}
+	return changes
+}
+
+// diffUsers 比较用户配置，用户配置中包含密码，只输出哪些用户被添加、删除或修改
+func diffUsers(old, cfg map[string]User) []string {
+	var changes []string
+	for _, name := range sortedKeys(cfg) {
+		previous, ok := old[name]
+		switch {
+		case !ok:
+			changes = append(changes, fmt.Sprintf("auth.users.%s: 已添加", name))
+		case previous != cfg[name]:
+			changes = append(changes, fmt.Sprintf("auth.users.%s: 已修改", name))
+		}
+	}
+	for _, name := range sortedKeys(old) {
+		if _, ok := cfg[name]; !ok {
+			changes = append(changes, fmt.Sprintf("auth.users.%s: 已删除", name))
+		}
+	}
+	return changes
+}
+
+// configField 配置中的一个配置项
+type configField struct {
+	path  string // 与配置文件一致的路径，例如 config.taskTime
+	value reflect.Value
+}
+
+// configFields 按声明顺序展开配置结构体中的全部配置项，map 作为一个整体比较
+func configFields(v reflect.Value) []configField {
+	var fields []configField
+	var walk func(v reflect.Value, prefix string)
+	walk = func(v reflect.Value, prefix string) {
+		for i := 0; i < v.NumField(); i++ {
+			field := v.Type().Field(i)
+			name, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+			if name == "" {
+				name = strings.ToLower(field.Name)
+			}
+
+			if field.Type.Kind() == reflect.Struct {
+				walk(v.Field(i), prefix+name+".")
+				continue
+			}
+			fields = append(fields, configField{path: prefix + name, value: v.Field(i)})
+		}
+	}
+	walk(v, "")
+	return fields
+}
+
+// needsRestart 判断配置项是否需要重启才能生效
+func needsRestart(path string) bool {
+	for _, field := range restartFields {
+		if path == field || (strings.HasSuffix(field, ".") && strings.HasPrefix(path, field)) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,126 @@
+package common
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// TestDiffConfig 需要重启的配置项恢复为旧值并报告为忽略，其余配置项按类型输出变更，敏感配置与用户密码不输出
+func TestDiffConfig(t *testing.T) {
+	old := DefaultConfig()
+	old.Penalties = map[string]int{"timeout": 5, "refused": 10}
+	old.Auth.Users = map[string]User{"alice": {Password: "a"}, "bob": {Password: "b"}}
+
+	cfg := DefaultConfig()
+	cfg.Penalties = map[string]int{"timeout": 8, "reset": 3}
+	cfg.Auth.Users = map[string]User{"alice": {Password: "changed"}, "carol": {Password: "c"}}
+	cfg.Server.Port = "40000"
+	cfg.Database.Path = "other.db"
+	cfg.Checker.Interval = 30
+	cfg.Admin.Listen = "127.0.0.1:40001"
+	cfg.Admin.Token = "secret-token"
+	cfg.Score.Max = 150
+	cfg.Quarantine.Retention = 12
+
+	changes, ignored := diffConfig(&old, &cfg)
+
+	wantChanges := []string{
+		"score.max: 0 -> 150",
+		"penalties.refused: 10 -> 未设置",
+		"penalties.reset: 未设置 -> 3",
+		"penalties.timeout: 5 -> 8",
+		"quarantine.retention: 0 -> 12",
+		"admin.token: 已修改",
+		"auth.users.alice: 已修改",
+		"auth.users.carol: 已添加",
+		"auth.users.bob: 已删除",
+	}
+	if !slices.Equal(changes, wantChanges) {
+		t.Errorf("变更为 %q\n期望 %q", changes, wantChanges)
+	}
+	wantIgnored := []string{"server.port", "database.path", "checker.interval", "admin.listen"}
+	if !slices.Equal(ignored, wantIgnored) {
+		t.Errorf("忽略的配置项为 %q，期望 %q", ignored, wantIgnored)
+	}
+
+	// 被忽略的配置项恢复为旧值，其余保留新值
+	if cfg.Server.Port != old.Server.Port || cfg.Database.Path != old.Database.Path ||
+		cfg.Checker.Interval != old.Checker.Interval || cfg.Admin.Listen != old.Admin.Listen {
+		t.Errorf("需要重启的配置项没有恢复为旧值: %+v", cfg)
+	}
+	if cfg.Score.Max != 150 || cfg.Admin.Token != "secret-token" {
+		t.Errorf("可以热加载的配置项被恢复: %+v", cfg)
+	}
+
+	if changes, ignored := diffConfig(&old, &old); len(changes) != 0 || len(ignored) != 0 {
+		t.Errorf("相同的配置产生了变更 %q 与忽略 %q", changes, ignored)
+	}
+}
+
+func TestNeedsRestart(t *testing.T) {
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{"server.port", true},
+		{"database.writeBehind.enabled", true},
+		{"premium.port", true},
+		{"premium.enabled", false},
+		{"quarantine.recheckInterval", true},
+		{"quarantine.retention", false},
+		{"admin.enabled", true},
+		{"admin.token", false},
+		{"log.format", true},
+		{"log.level", false},
+		// 前缀只匹配完整的配置段
+		{"serverName", false},
+	}
+
+	for _, tt := range tests {
+		if got := needsRestart(tt.path); got != tt.want {
+			t.Errorf("needsRestart(%q) = %v，期望 %v", tt.path, got, tt.want)
+		}
+	}
+}
+
+// TestReloadConfigKeepsOverrides 重新加载配置文件后命令行参数的修改仍然生效，不会被报告为需要重启的变更
+func TestReloadConfigKeepsOverrides(t *testing.T) {
+	previous, previousPath, previousOverrides := *Current(), configPath, overrides
+	t.Cleanup(func() {
+		SetConfig(previous)
+		configPath, overrides = previousPath, previousOverrides
+	})
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	writeConfig := func(content string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeConfig("database:\n  path: file.db\n")
+	cfg, err := ReadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetConfig(cfg)
+	configPath, overrides = path, nil
+	Override(func(cfg *Config) { cfg.Database.Path = "flag.db" })
+
+	writeConfig("database:\n  path: file.db\nscore:\n  max: 150\n  neutral: 100\n")
+	changes, ignored, err := ReloadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ignored) != 0 {
+		t.Errorf("忽略了配置项 %q，期望没有", ignored)
+	}
+	if !slices.Equal(changes, []string{"score.max: 0 -> 150", "score.neutral: 0 -> 100"}) {
+		t.Errorf("变更为 %q", changes)
+	}
+	if Current().Database.Path != "flag.db" || Current().Score.Max != 150 {
+		t.Errorf("重新加载后数据库为 %q、评分上限为 %d", Current().Database.Path, Current().Score.Max)
+	}
+}
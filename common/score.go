@@ -0,0 +1,96 @@
+package common
+
+import (
+	"math"
+	"time"
+)
+
+// 评分模型的默认参数，配置缺省或不合法时使用
+const (
+	defaultScoreMin      = 0
+	defaultScoreMax      = 200
+	defaultScoreNeutral  = 100
+	defaultScoreHalfLife = 24 * time.Hour
+	defaultScoreWeight   = 1.0
+)
+
+// ScoreModel 描述代理可信度的评分模型
+// 可信度被限制在 [Min, Max] 区间内，并随时间按半衰期向中性值衰减，
+// 健康检测与实际流量带来的变化分别乘以各自的权重
+type ScoreModel struct {
+	Min           int
+	Max           int
+	Neutral       int           // 新代理的初始可信度，也是衰减的目标
+	HalfLife      time.Duration // 偏离中性值的部分衰减一半所需的时间
+	CheckWeight   float64       // 健康检测结果的权重
+	TrafficWeight float64       // 实际流量结果的权重
+}
+
+// CurrentScoreModel 根据全局配置返回评分模型，区间不合法时整体使用默认区间
+func CurrentScoreModel() ScoreModel {
+	cfg := Current().Score
+
+	model := ScoreModel{
+		Min:           cfg.Min,
+		Max:           cfg.Max,
+		Neutral:       cfg.Neutral,
+		HalfLife:      time.Duration(cfg.HalfLife) * time.Hour,
+		CheckWeight:   cfg.CheckWeight,
+		TrafficWeight: cfg.TrafficWeight,
+	}
+
+	if model.Max == 0 && model.Neutral == 0 {
+		model.Min, model.Max, model.Neutral = defaultScoreMin, defaultScoreMax, defaultScoreNeutral
+	}
+	if model.Min >= model.Max || model.Neutral <= model.Min || model.Neutral >= model.Max {
+		model.Min, model.Max, model.Neutral = defaultScoreMin, defaultScoreMax, defaultScoreNeutral
+	}
+	if model.HalfLife <= 0 {
+		model.HalfLife = defaultScoreHalfLife
+	}
+	if model.CheckWeight <= 0 {
+		model.CheckWeight = defaultScoreWeight
+	}
+	if model.TrafficWeight <= 0 {
+		model.TrafficWeight = defaultScoreWeight
+	}
+
+	return model
+}
+
+// Clamp 将可信度限制在区间内
+func (m ScoreModel) Clamp(score float64) float64 {
+	return math.Min(math.Max(score, float64(m.Min)), float64(m.Max))
+}
+
+// DecayFactor 返回经过 elapsed 之后偏离中性值的部分保留的比例
+func (m ScoreModel) DecayFactor(elapsed time.Duration) float64 {
+	if elapsed <= 0 {
+		return 1
+	}
+	return math.Pow(0.5, float64(elapsed)/float64(m.HalfLife))
+}
+
+// Decay 返回可信度经过 elapsed 之后向中性值衰减的结果
+func (m ScoreModel) Decay(score float64, elapsed time.Duration) float64 {
+	neutral := float64(m.Neutral)
+	return neutral + (score-neutral)*m.DecayFactor(elapsed)
+}
+
+// Weighted 按结果来源的权重换算可信度变化量，非零的变化至少为 1
+func (m ScoreModel) Weighted(delta int, traffic bool) int {
+	if delta == 0 {
+		return 0
+	}
+
+	weight := m.CheckWeight
+	if traffic {
+		weight = m.TrafficWeight
+	}
+
+	weighted := int(math.Round(float64(delta) * weight))
+	if weighted == 0 {
+		weighted = 1
+	}
+	return weighted
+}
@@ -17,8 +17,10 @@ type ProxyData struct {
 type HunterResponse struct {
 	Code int `json:"code"`
 	Data struct {
-		Total int         `json:"total"`
-		Arr   []ProxyBase `json:"arr"`
+		Total        int         `json:"total"`
+		Arr          []ProxyBase `json:"arr"`
+		ConsumeQuota string      `json:"consume_quota"` // 本次消耗的积分，例如 "消耗积分：10"
+		RestQuota    string      `json:"rest_quota"`    // 剩余积分，例如 "今日剩余积分：490"
 	} `json:"data"`
 }
 
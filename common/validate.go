@@ -0,0 +1,261 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// 获取代理的模式
+const (
+	ProxyModeRandom   = "random"   // 随机
+	ProxyModePriority = "priority" // 按优先级
+)
+
+// 敏感配置在日志中的替代文本
+const redactedValue = "******"
+
+// dsnPassword 匹配 key=value 形式连接串中的密码
+var dsnPassword = regexp.MustCompile(`(password\s*=\s*)('[^']*'|\S+)`)
+
+// secretEnv 可以通过环境变量覆盖的敏感配置，环境变量优先于配置文件
+var secretEnv = []struct {
+	name  string
+	path  string // 配置项在配置文件中的路径
+	field func(cfg *Config) *string
+}{
+	{"PROXYCHAIN_HUNTER_API_KEY", "hunter.apiKey", func(cfg *Config) *string { return &cfg.Hunter.APIKey }},
+	{"PROXYCHAIN_FOFA_API_KEY", "fofa.apiKey", func(cfg *Config) *string { return &cfg.Fofa.APIKey }},
+	{"PROXYCHAIN_DATABASE_DSN", "database.dsn", func(cfg *Config) *string { return &cfg.Database.DSN }},
+	{"PROXYCHAIN_ADMIN_TOKEN", "admin.token", func(cfg *Config) *string { return &cfg.Admin.Token }},
+}
+
+// DefaultConfig 返回带默认值的配置，配置文件中缺省的项保留这些默认值
+// 各子系统的细节参数（检测、评分、隔离区等）在使用处按 0 表示默认值处理
+func DefaultConfig() Config {
+	var cfg Config
+	cfg.Server.Host = "127.0.0.1"
+	cfg.Server.Port = "33445"
+	cfg.Database.Type = "sqlite"
+	cfg.Database.Path = "proxychain.db"
+	cfg.Config.ObtainingProxyMode = ProxyModeRandom
+	cfg.Config.TaskTime = 60
+	cfg.Config.MiniProxyCount = 80
+	cfg.Config.PriorityDownNum = 10
+	cfg.Config.PriorityUpNum = 2
+	cfg.Premium.UserTag = "premium"
+	cfg.Admin.Listen = "127.0.0.1:33450"
+	cfg.Log.Format = LogFormatText
+	cfg.Log.Level = "info"
+	cfg.AccessLog.Enabled = true
+	cfg.AccessLog.Path = "logs/access.jsonl"
+	return cfg
+}
+
+// applyEnv 使用 PROXYCHAIN_* 环境变量覆盖敏感配置
+func applyEnv(cfg *Config) {
+	for _, env := range secretEnv {
+		if value, ok := os.LookupEnv(env.name); ok {
+			*env.field(cfg) = value
+		}
+	}
+}
+
+// Validate 检查配置是否合法，返回包含全部问题的错误
+func (cfg *Config) Validate() error {
+	var errs []error
+	check := func(ok bool, field, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf("%s: %s", field, fmt.Sprintf(format, args...)))
+		}
+	}
+
+	check(validPort(cfg.Server.Port), "server.port", "必须是 1-65535 之间的端口，当前为 %q", cfg.Server.Port)
+
+	switch cfg.Database.Type {
+	case "sqlite":
+		check(cfg.Database.Path != "", "database.path", "使用 sqlite 时不能为空")
+	case "postgres":
+		check(cfg.Database.DSN != "", "database.dsn", "使用 postgres 时不能为空，也可以通过环境变量 PROXYCHAIN_DATABASE_DSN 设置")
+	case "memory":
+	default:
+		check(false, "database.type", "只能是 sqlite、postgres 或 memory，当前为 %q", cfg.Database.Type)
+	}
+	check(cfg.Database.WriteBehind.FlushInterval >= 0, "database.writeBehind.flushInterval", "不能为负数")
+	check(cfg.Database.WriteBehind.MaxPending >= 0, "database.writeBehind.maxPending", "不能为负数")
+
+	mode := cfg.Config.ObtainingProxyMode
+	check(mode == ProxyModeRandom || mode == ProxyModePriority,
+		"config.obtainingProxyMode", "只能是 %s 或 %s，当前为 %q", ProxyModeRandom, ProxyModePriority, mode)
+	check(cfg.Config.TaskTime > 0, "config.taskTime", "必须大于0，当前为 %d", cfg.Config.TaskTime)
+	check(cfg.Config.MiniProxyCount >= 0, "config.miniProxyCount", "不能为负数")
+	check(cfg.Config.PriorityDownNum >= 0, "config.priorityDownNum", "不能为负数")
+	check(cfg.Config.PriorityUpNum >= 0, "config.priorityUpNum", "不能为负数")
+
+	for _, field := range []struct {
+		name  string
+		value int
+	}{
+		{"server.shutdownTimeout", cfg.Server.ShutdownTimeout},
+		{"checker.concurrency", cfg.Checker.Concurrency},
+		{"checker.timeout", cfg.Checker.Timeout},
+		{"checker.freshInterval", cfg.Checker.FreshInterval},
+		{"checker.interval", cfg.Checker.Interval},
+		{"checker.maxBackoff", cfg.Checker.MaxBackoff},
+		{"throughput.size", cfg.Throughput.Size},
+		{"throughput.timeout", cfg.Throughput.Timeout},
+		{"throughput.interval", cfg.Throughput.Interval},
+		{"throughput.minThroughput", cfg.Throughput.MinThroughput},
+		{"udp.timeout", cfg.UDP.Timeout},
+		{"udp.interval", cfg.UDP.Interval},
+		{"score.halfLife", cfg.Score.HalfLife},
+		{"premium.minRecords", cfg.Premium.MinRecords},
+		{"quarantine.retention", cfg.Quarantine.Retention},
+		{"quarantine.recheckInterval", cfg.Quarantine.RecheckInterval},
+		{"quarantine.restorePriority", cfg.Quarantine.RestorePriority},
+		{"accessLog.maxSize", cfg.AccessLog.MaxSize},
+		{"accessLog.rotateInterval", cfg.AccessLog.RotateInterval},
+		{"accessLog.maxBackups", cfg.AccessLog.MaxBackups},
+		{"history.retention", cfg.History.Retention},
+		{"history.uptimeWindow", cfg.History.UptimeWindow},
+	} {
+		check(field.value >= 0, field.name, "不能为负数，0 表示使用默认值")
+	}
+	check(!cfg.Throughput.Enabled || cfg.Throughput.URL != "", "throughput.url", "启用吞吐量探测时不能为空")
+
+	score := cfg.Score
+	if score.Min != 0 || score.Max != 0 || score.Neutral != 0 {
+		check(score.Min < score.Neutral && score.Neutral < score.Max, "score",
+			"必须满足 min < neutral < max，当前为 min=%d neutral=%d max=%d", score.Min, score.Neutral, score.Max)
+	}
+	check(score.CheckWeight >= 0, "score.checkWeight", "不能为负数")
+	check(score.TrafficWeight >= 0, "score.trafficWeight", "不能为负数")
+
+	classes := penaltyClasses()
+	for _, class := range sortedKeys(cfg.Penalties) {
+		field := "penalties." + class
+		penalty := cfg.Penalties[class]
+		check(slices.Contains(classes, class), field, "未知的失败分类，可选: %s", strings.Join(classes, "、"))
+		check(penalty >= 0, field, "不能为负数")
+	}
+
+	premium := cfg.Premium
+	if premium.Port != "" {
+		check(validPort(premium.Port), "premium.port", "必须是 1-65535 之间的端口，当前为 %q", premium.Port)
+		check(premium.Port != cfg.Server.Port, "premium.port", "不能与 server.port 相同")
+	}
+	check(premium.PromoteUptime >= 0 && premium.PromoteUptime <= 100, "premium.promoteUptime", "必须在 0-100 之间")
+	check(premium.DemoteUptime >= 0 && premium.DemoteUptime <= 100, "premium.demoteUptime", "必须在 0-100 之间")
+	if premium.DemotePriority > 0 && premium.PromotePriority > 0 {
+		check(premium.DemotePriority <= premium.PromotePriority, "premium.demotePriority", "不能高于 promotePriority")
+	}
+	if premium.DemoteUptime > 0 && premium.PromoteUptime > 0 {
+		check(premium.DemoteUptime <= premium.PromoteUptime, "premium.demoteUptime", "不能高于 promoteUptime")
+	}
+
+	check(cfg.Upstream.MaxConnections >= 0, "upstream.maxConnections", "不能为负数，0 表示不限制")
+	check(cfg.Upstream.MaxRPS >= 0, "upstream.maxRPS", "不能为负数，0 表示不限制")
+
+	patterns := make(map[string]bool)
+	for i, target := range cfg.Targets {
+		field := fmt.Sprintf("targets[%d]", i)
+		_, err := path.Match(target.Pattern, "")
+		check(target.Pattern != "" && err == nil, field+".pattern", "必须是域名或带 * 通配符的域名，当前为 %q", target.Pattern)
+		check(!patterns[strings.ToLower(target.Pattern)], field+".pattern", "与前面的规则重复: %q", target.Pattern)
+		check(target.MaxRPS >= 0, field+".maxRPS", "不能为负数，0 表示不限制")
+		check(target.MaxConnections >= 0, field+".maxConnections", "不能为负数，0 表示不限制")
+		check(target.QueueTimeout >= 0, field+".queueTimeout", "不能为负数，0 表示超出限制时立即拒绝")
+		patterns[strings.ToLower(target.Pattern)] = true
+	}
+
+	check(!cfg.Metrics.Enabled || cfg.Admin.Enabled, "metrics.enabled", "/metrics 由管理接口提供，启用时需要同时启用 admin.enabled")
+
+	if cfg.Admin.Enabled {
+		_, _, err := net.SplitHostPort(cfg.Admin.Listen)
+		check(err == nil, "admin.listen", "必须是 host:port 形式的地址，当前为 %q", cfg.Admin.Listen)
+		check(cfg.Admin.Token != "", "admin.token", "启用管理接口时不能为空，也可以通过环境变量 PROXYCHAIN_ADMIN_TOKEN 设置")
+	}
+
+	for _, name := range sortedKeys(cfg.Auth.Users) {
+		field, user := "auth.users."+name, cfg.Auth.Users[name]
+		check(name != "" && !strings.Contains(name, "+"), field, "用户名不能为空，也不能包含 +，+ 用于在用户名后附加代理池标记")
+		check(user.Password != "", field+".password", "不能为空")
+		check(user.RequestsPerDay >= 0, field+".requestsPerDay", "不能为负数，0 表示不限制")
+		check(user.BytesPerDay >= 0, field+".bytesPerDay", "不能为负数，0 表示不限制")
+		check(user.MaxConnections >= 0, field+".maxConnections", "不能为负数，0 表示不限制")
+	}
+
+	check(!cfg.AccessLog.Enabled || cfg.AccessLog.Path != "", "accessLog.path", "启用访问日志时不能为空")
+
+	check(cfg.Log.Format == LogFormatText || cfg.Log.Format == LogFormatJSON,
+		"log.format", "只能是 %s 或 %s，当前为 %q", LogFormatText, LogFormatJSON, cfg.Log.Format)
+	_, ok := parseLogLevel(cfg.Log.Level)
+	check(ok, "log.level", "只能是 debug、info、warn 或 error，当前为 %q", cfg.Log.Level)
+	for _, subsystem := range sortedKeys(cfg.Log.Levels) {
+		field := "log.levels." + subsystem
+		_, ok := parseLogLevel(cfg.Log.Levels[subsystem])
+		check(slices.Contains(LogSubsystems, subsystem), field, "未知的子系统，可选: %s", strings.Join(LogSubsystems, "、"))
+		check(ok, field, "只能是 debug、info、warn 或 error，当前为 %q", cfg.Log.Levels[subsystem])
+	}
+
+	return errors.Join(errs...)
+}
+
+// Redacted 返回隐藏了 API key 与数据库密码的配置副本，用于输出日志
+func (cfg Config) Redacted() Config {
+	dsn := cfg.Database.DSN
+	for _, env := range secretEnv {
+		if field := env.field(&cfg); *field != "" {
+			*field = redactedValue
+		}
+	}
+
+	if len(cfg.Auth.Users) > 0 {
+		users := make(map[string]User, len(cfg.Auth.Users))
+		for name, user := range cfg.Auth.Users {
+			user.Password = redactedValue
+			users[name] = user
+		}
+		cfg.Auth.Users = users
+	}
+
+	// 连接串只隐藏密码，保留地址方便排查问题
+	if u, err := url.Parse(dsn); err == nil && u.User != nil {
+		cfg.Database.DSN = u.Redacted()
+	} else if dsn != "" {
+		cfg.Database.DSN = dsnPassword.ReplaceAllString(dsn, "${1}"+redactedValue)
+	}
+	return cfg
+}
+
+// penaltyClasses 返回可以单独设置扣减值的失败分类
+func penaltyClasses() []string {
+	classes := []string{
+		string(ErrorClassConnectRefused), string(ErrorClassAuthRequired), string(ErrorClassConnectRejected),
+		string(ErrorClassTargetUnreachable), string(ErrorClassTimeout), string(ErrorClassTLS), string(ErrorClassUnknown),
+	}
+	sort.Strings(classes)
+	return classes
+}
+
+func validPort(port string) bool {
+	n, err := strconv.Atoi(port)
+	return err == nil && n > 0 && n <= 65535
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,193 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"proxychain/common"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 访问日志的默认参数，配置缺省时使用
+const (
+	defaultAccessLogMaxSize        = 100 // 单个文件的最大大小，单位 MB
+	defaultAccessLogRotateInterval = 24 * time.Hour
+	defaultAccessLogMaxBackups     = 7
+
+	// accessLogTimeLayout 轮转后文件名中的时间格式
+	accessLogTimeLayout = "20060102-150405.000"
+)
+
+// accessEntry 访问日志中的一行，对应一个转发的请求或隧道
+type accessEntry struct {
+	Time       time.Time `json:"time"`
+	RequestID  string    `json:"request_id"`
+	Client     string    `json:"client"`
+	User       string    `json:"user,omitempty"` // 代理认证的用户名
+	Kind       string    `json:"kind"`
+	Method     string    `json:"method"`
+	Target     string    `json:"target"`
+	Upstream   string    `json:"upstream,omitempty"` // 最后一次尝试使用的上游代理
+	Tier       Tier      `json:"tier"`
+	Attempts   int       `json:"attempts"`
+	Status     int       `json:"status"`
+	BytesIn    int64     `json:"bytes_in"`  // 从客户端读取的字节数
+	BytesOut   int64     `json:"bytes_out"` // 写给客户端的字节数
+	DurationMs int64     `json:"duration_ms"`
+	ErrorClass string    `json:"error_class,omitempty"`
+}
+
+// accessLogger 将访问日志按行写入文件，超过大小或时间间隔后轮转
+type accessLogger struct {
+	mu       sync.Mutex
+	file     *os.File
+	path     string // 当前打开的文件路径，配置重新加载后路径不同时重新打开
+	size     int64
+	openedAt time.Time
+}
+
+var accessLog accessLogger
+
+// finalStatus 返回连接的最终状态：HTTP 请求为目标返回的状态码，隧道建立成功为 200
+// 所有代理均失败为 502，没有可用代理为 503
+func (s *session) finalStatus() int {
+	switch {
+	case s.status != 0:
+		return s.status
+	case s.errorClass == errorClassNoProxy:
+		return http.StatusServiceUnavailable
+	case s.success:
+		return http.StatusOK
+	default:
+		return http.StatusBadGateway
+	}
+}
+
+// record 写入连接的访问日志，未启用访问日志时忽略
+func (l *accessLogger) record(s *session) {
+	cfg := common.Current().AccessLog
+	if !cfg.Enabled {
+		l.close()
+		return
+	}
+
+	entry := accessEntry{
+		Time:       s.start,
+		RequestID:  s.id,
+		Client:     s.clientAddr,
+		User:       s.user,
+		Kind:       s.kind,
+		Method:     s.method,
+		Target:     s.target,
+		Upstream:   s.proxy,
+		Tier:       s.tier,
+		Attempts:   s.attempts,
+		Status:     s.finalStatus(),
+		BytesIn:    s.conn.read.Load(),
+		BytesOut:   s.conn.written.Load(),
+		DurationMs: time.Since(s.start).Milliseconds(),
+		ErrorClass: s.errorClass,
+	}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		s.log.Error("编码访问日志失败", "error", err)
+		return
+	}
+
+	if err := l.write(append(line, '\n'), cfg.Path, cfg.MaxSize, cfg.RotateInterval, cfg.MaxBackups); err != nil {
+		s.log.Error("写入访问日志失败", "path", cfg.Path, "error", err)
+	}
+}
+
+// write 写入一行访问日志，必要时先打开或轮转文件
+func (l *accessLogger) write(line []byte, path string, maxSize, rotateInterval, maxBackups int) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.file != nil && l.path != path {
+		l.file.Close()
+		l.file = nil
+	}
+
+	limit := int64(positiveOr(maxSize, defaultAccessLogMaxSize)) * 1024 * 1024
+	interval := time.Duration(rotateInterval) * time.Hour
+	if interval <= 0 {
+		interval = defaultAccessLogRotateInterval
+	}
+	if l.file != nil && (l.size+int64(len(line)) > limit || time.Since(l.openedAt) >= interval) {
+		if err := l.rotate(positiveOr(maxBackups, defaultAccessLogMaxBackups)); err != nil {
+			return err
+		}
+	}
+
+	if l.file == nil {
+		if err := l.open(path); err != nil {
+			return err
+		}
+	}
+
+	n, err := l.file.Write(line)
+	l.size += int64(n)
+	return err
+}
+
+// open 以追加方式打开访问日志，目录不存在时创建
+func (l *accessLogger) open(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	l.file, l.path, l.size, l.openedAt = file, path, info.Size(), time.Now()
+	return nil
+}
+
+// rotate 将当前文件重命名为带时间的备份，例如 access-20240102-150405.000.jsonl，并删除超出数量的旧备份
+func (l *accessLogger) rotate(maxBackups int) error {
+	path := l.path
+	l.file.Close()
+	l.file = nil
+
+	ext := filepath.Ext(path)
+	prefix := strings.TrimSuffix(path, ext) + "-"
+	backup := prefix + time.Now().Format(accessLogTimeLayout) + ext
+	if err := os.Rename(path, backup); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("轮转访问日志失败: %w", err)
+	}
+
+	backups, err := filepath.Glob(prefix + "*" + ext)
+	if err != nil {
+		return err
+	}
+	// 时间格式按字典序即按时间排序，最旧的排在前面
+	sort.Strings(backups)
+	for _, old := range backups[:max(len(backups)-maxBackups, 0)] {
+		os.Remove(old)
+	}
+	return nil
+}
+
+// close 关闭访问日志文件，之后再次写入时重新打开
+func (l *accessLogger) close() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.file != nil {
+		l.file.Close()
+		l.file = nil
+	}
+}
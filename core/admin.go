@@ -0,0 +1,410 @@
+package core
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"proxychain/common"
+	"proxychain/database"
+	"proxychain/proxyPool"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// 手动触发的检测与获取代理同一时间只运行一个
+var (
+	adminCheckRunning   atomic.Bool
+	adminHarvestRunning atomic.Bool
+)
+
+// adminAPI 管理接口，用于查看与调整运行中的实例
+type adminAPI struct {
+	srv *server
+	ps  database.Storage
+}
+
+// startAdmin 在独立的端口上启动管理接口
+func startAdmin(srv *server, ps database.Storage) error {
+	cfg := common.Current().Admin
+	if !cfg.Enabled {
+		return nil
+	}
+
+	if err := srv.serveHTTP(cfg.Listen, newAdminHandler(srv, ps)); err != nil {
+		return fmt.Errorf("启动管理接口失败: %w", err)
+	}
+	adminLog.Info("管理接口已启动", "address", cfg.Listen)
+	return nil
+}
+
+// newAdminHandler 返回管理接口的路由，/api/ 下的接口都需要令牌，控制台页面本身不包含数据，无需令牌
+// /metrics 按 metrics.public 决定是否需要令牌
+func newAdminHandler(srv *server, ps database.Storage) http.Handler {
+	api := &adminAPI{srv: srv, ps: ps}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/status", api.status)
+	mux.HandleFunc("GET /api/proxies", api.listProxies)
+	mux.HandleFunc("POST /api/proxies", api.addProxy)
+	mux.HandleFunc("GET /api/proxies/current", api.currentProxies)
+	mux.HandleFunc("DELETE /api/proxies/{ip}/{port}", api.deleteProxy)
+	mux.HandleFunc("POST /api/proxies/{ip}/{port}/ban", api.banProxy)
+	mux.HandleFunc("POST /api/proxies/{ip}/{port}/restore", api.restoreProxy)
+	mux.HandleFunc("POST /api/check", api.forceCheck)
+	mux.HandleFunc("POST /api/harvest", api.forceHarvest)
+	mux.HandleFunc("POST /api/refresh", api.refresh)
+	mux.HandleFunc("PUT /api/mode", api.setMode)
+	mux.HandleFunc("GET /api/dashboard", api.dashboard)
+	mux.HandleFunc("GET /api/routes", api.routes)
+	mux.HandleFunc("GET /api/usage", api.usage)
+	mux.HandleFunc("GET /api/upstreams", api.upstreamLimits)
+
+	root := http.NewServeMux()
+	root.HandleFunc("GET /{$}", serveDashboard)
+	root.HandleFunc("GET /metrics", api.serveMetrics)
+	root.Handle("/api/", requireToken(mux))
+	return root
+}
+
+// requireToken 校验 Authorization: Bearer <token> 请求头，令牌随配置重新加载
+func requireToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		expected := common.Current().Admin.Token
+		if !ok || expected == "" || subtle.ConstantTimeCompare([]byte(token), []byte(expected)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="proxychain"`)
+			writeError(w, http.StatusUnauthorized, "令牌无效")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// status 返回运行状态与代理数量
+func (api *adminAPI) status(w http.ResponseWriter, r *http.Request) {
+	active, err := api.ps.GetProxyCount()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	premium, err := api.ps.GetPremiumCount()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	quarantined, err := api.ps.GetQuarantinedProxies()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	mu.Lock()
+	standard, premiumCurrent := len(GlobeProxyList), len(GlobePremiumProxyList)
+	mu.Unlock()
+
+	cfg := common.Current()
+	writeJSON(w, http.StatusOK, map[string]any{
+		"mode":             cfg.Config.ObtainingProxyMode,
+		"active_proxies":   active,
+		"premium_proxies":  premium,
+		"quarantined":      len(quarantined),
+		"current_standard": standard,
+		"current_premium":  premiumCurrent,
+		"active_conns":     api.srv.activeConns(),
+		"check_running":    adminCheckRunning.Load() || healthChecker.Running(),
+		"harvest_running":  adminHarvestRunning.Load(),
+		"started_at":       api.srv.startedAt,
+		"uptime_seconds":   int(time.Since(api.srv.startedAt).Seconds()),
+	})
+}
+
+// listProxies 按查询参数筛选代理，参数与 list 子命令一致：country、protocol、min_score、max_score、premium、all、limit
+func (api *adminAPI) listProxies(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := proxyFilter{
+		country:  query.Get("country"),
+		protocol: query.Get("protocol"),
+		premium:  query.Get("premium") == "true",
+		all:      query.Get("all") == "true",
+	}
+
+	var err error
+	if filter.limit, err = optionalInt(query.Get("limit"), 0); err != nil {
+		writeError(w, http.StatusBadRequest, "limit 不合法")
+		return
+	}
+	for name, target := range map[string]**int{"min_score": &filter.minScore, "max_score": &filter.maxScore} {
+		if value := query.Get(name); value != "" {
+			score, err := strconv.Atoi(value)
+			if err != nil {
+				writeError(w, http.StatusBadRequest, name+" 不合法")
+				return
+			}
+			*target = &score
+		}
+	}
+
+	records, err := api.ps.ExportProxies()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	matched := filter.apply(records)
+	if matched == nil {
+		matched = []database.ProxyRecord{}
+	}
+	writeJSON(w, http.StatusOK, matched)
+}
+
+// addProxy 添加代理，请求体为 {"url": "socks5://1.2.3.4:1080"}，新代理以中性值的可信度加入
+func (api *adminAPI) addProxy(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		URL string `json:"url"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.URL == "" {
+		writeError(w, http.StatusBadRequest, `请求体应为 {"url": "protocol://ip:port"}`)
+		return
+	}
+
+	records, err := readListRecords(strings.NewReader(body.URL))
+	if err != nil || len(records) != 1 {
+		writeError(w, http.StatusBadRequest, "代理地址不合法")
+		return
+	}
+
+	record := records[0]
+	err = api.ps.UpsertProxy(record.IP, record.Port, record.Protocol, record.Country, record.Province, record.City)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	adminLog.Info("添加代理", "proxy", body.URL)
+	writeJSON(w, http.StatusCreated, record)
+}
+
+// currentProxies 返回当前用于转发的代理列表与使用次数
+func (api *adminAPI) currentProxies(w http.ResponseWriter, r *http.Request) {
+	mu.Lock()
+	usage := make(map[string]int, len(usageCount))
+	for proxy, count := range usageCount {
+		usage[proxy] = count
+	}
+	standard := append([]string{}, GlobeProxyList...)
+	premium := append([]string{}, GlobePremiumProxyList...)
+	mu.Unlock()
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"mode":     common.Current().Config.ObtainingProxyMode,
+		"standard": standard,
+		"premium":  premium,
+		"usage":    usage,
+	})
+}
+
+// deleteProxy 永久删除代理
+func (api *adminAPI) deleteProxy(w http.ResponseWriter, r *http.Request) {
+	api.changeProxy(w, r, "删除", api.ps.DeleteProxy)
+}
+
+// banProxy 封禁代理，封禁的代理留在隔离区中，不会被复检恢复
+func (api *adminAPI) banProxy(w http.ResponseWriter, r *http.Request) {
+	api.changeProxy(w, r, "封禁", func(ip string, port int) (int64, error) {
+		return api.ps.QuarantineProxy(ip, port, database.ReasonBanned)
+	})
+}
+
+// restoreProxy 将隔离区中的代理（包括封禁的代理）恢复使用
+func (api *adminAPI) restoreProxy(w http.ResponseWriter, r *http.Request) {
+	ip, port, ok := proxyAddress(w, r)
+	if !ok {
+		return
+	}
+
+	priority := positiveOr(common.Current().Quarantine.RestorePriority, defaultRestorePriority)
+	restored, err := api.ps.RestoreProxy(ip, port, priority)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if restored == 0 {
+		writeError(w, http.StatusNotFound, "代理不存在或不在隔离区中")
+		return
+	}
+	adminLog.Info("恢复代理", "ip", ip, "port", port, "priority", priority)
+	writeJSON(w, http.StatusOK, map[string]any{"ip": ip, "port": port, "priority": priority})
+}
+
+// changeProxy 对路径中的代理执行删除或封禁，并将其从当前使用的代理列表中移除
+func (api *adminAPI) changeProxy(w http.ResponseWriter, r *http.Request, action string, change func(ip string, port int) (int64, error)) {
+	ip, port, ok := proxyAddress(w, r)
+	if !ok {
+		return
+	}
+
+	affected, err := change(ip, port)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if affected == 0 {
+		writeError(w, http.StatusNotFound, "代理不存在")
+		return
+	}
+
+	removeCurrentProxy(ip, port)
+	adminLog.Info(action+"代理", "ip", ip, "port", port)
+	writeJSON(w, http.StatusOK, map[string]any{"ip": ip, "port": port, "affected": affected})
+}
+
+// forceCheck 立即在后台检测数据库中的全部代理，与定时检测共用检测器，定时检测正在进行时返回 409
+func (api *adminAPI) forceCheck(w http.ResponseWriter, r *http.Request) {
+	if healthChecker.Running() {
+		writeError(w, http.StatusConflict, "检测正在进行")
+		return
+	}
+	api.runOnce(w, &adminCheckRunning, "检测", func() {
+		proxies, err := api.ps.GetActiveProxiesByPriority()
+		if err != nil {
+			adminLog.Error("获取代理失败", "error", err)
+			return
+		}
+		var proxyURLs []string
+		for _, proxy := range proxies {
+			proxyURLs = append(proxyURLs, proxy.URL)
+		}
+
+		results, ok := healthChecker.CheckAll(api.srv.ctx, proxyURLs, checkTargetURLs)
+		if !ok {
+			adminLog.Warn("定时检测刚刚开始，跳过本次检测")
+			return
+		}
+		applyCheckResults(api.ps, results)
+		quarantineLowPriorityProxies(api.ps)
+		if err := loadProxies(api.ps); err != nil {
+			adminLog.Error("重新加载代理列表失败", "error", err)
+		}
+	})
+}
+
+// forceHarvest 立即在后台从 hunter 与 fofa 获取代理
+func (api *adminAPI) forceHarvest(w http.ResponseWriter, r *http.Request) {
+	cfg := common.Current()
+	if cfg.Hunter.APIKey == "" && cfg.Fofa.APIKey == "" {
+		writeError(w, http.StatusBadRequest, "没有配置 hunter 或 fofa 的 API key")
+		return
+	}
+	api.runOnce(w, &adminHarvestRunning, "获取代理", func() {
+		proxyPool.GetProxyBase(api.srv.ctx, api.ps)
+	})
+}
+
+// runOnce 在后台运行任务，同类任务正在运行时返回 409
+func (api *adminAPI) runOnce(w http.ResponseWriter, running *atomic.Bool, name string, task func()) {
+	if !running.CompareAndSwap(false, true) {
+		writeError(w, http.StatusConflict, name+"正在进行")
+		return
+	}
+
+	adminLog.Info("开始" + name)
+	api.srv.goTask(func() {
+		defer running.Store(false)
+		task()
+		adminLog.Info(name + "完成")
+	})
+	writeJSON(w, http.StatusAccepted, map[string]any{"started": name})
+}
+
+// refresh 重新加载当前使用的代理列表并重置使用次数
+func (api *adminAPI) refresh(w http.ResponseWriter, r *http.Request) {
+	if err := refreshProxyList(); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	api.currentProxies(w, r)
+}
+
+// setMode 修改获取代理的模式，请求体为 {"mode": "priority"}
+// 修改只在内存中生效，配置文件被修改并重新加载时以配置文件为准
+func (api *adminAPI) setMode(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Mode string `json:"mode"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, `请求体应为 {"mode": "random|priority"}`)
+		return
+	}
+
+	cfg := *common.Current()
+	cfg.Config.ObtainingProxyMode = body.Mode
+	if err := cfg.Validate(); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	previous := common.Current().Config.ObtainingProxyMode
+	common.SetConfig(cfg)
+	adminLog.Info("修改获取代理的模式", "from", previous, "to", body.Mode)
+
+	if err := loadProxies(api.ps); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	api.currentProxies(w, r)
+}
+
+// removeCurrentProxy 将代理从当前使用的代理列表中移除
+func removeCurrentProxy(ip string, port int) {
+	address := net.JoinHostPort(ip, strconv.Itoa(port))
+	remove := func(list []string) []string {
+		kept := list[:0:0]
+		for _, proxy := range list {
+			if !strings.HasSuffix(proxy, "://"+address) {
+				kept = append(kept, proxy)
+			}
+		}
+		return kept
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	GlobeProxyList = remove(GlobeProxyList)
+	GlobePremiumProxyList = remove(GlobePremiumProxyList)
+	proxyIndex, premiumIndex = 0, 0
+}
+
+// proxyAddress 解析路径中的代理地址
+func proxyAddress(w http.ResponseWriter, r *http.Request) (string, int, bool) {
+	ip := r.PathValue("ip")
+	port, err := strconv.Atoi(r.PathValue("port"))
+	if ip == "" || err != nil || port <= 0 || port > 65535 {
+		writeError(w, http.StatusBadRequest, "代理地址不合法")
+		return "", 0, false
+	}
+	return ip, port, true
+}
+
+func optionalInt(value string, def int) (int, error) {
+	if value == "" {
+		return def, nil
+	}
+	return strconv.Atoi(value)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil && !errors.Is(err, context.Canceled) {
+		adminLog.Debug("写入响应失败", "error", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
@@ -0,0 +1,104 @@
+package core
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"proxychain/common"
+	"proxychain/database"
+	"proxychain/proxyPool"
+	"testing"
+	"time"
+)
+
+const testAdminToken = "test-token"
+
+// newTestAdmin 返回使用内存存储的管理接口
+func newTestAdmin(t *testing.T, ps database.Storage) http.Handler {
+	t.Helper()
+	setTestConfig(t, func(cfg *common.Config) { cfg.Admin.Token = testAdminToken })
+	return newAdminHandler(newServer(ps), ps)
+}
+
+// adminRequest 携带令牌请求管理接口，返回状态码
+func adminRequest(handler http.Handler, method, path string) int {
+	r := httptest.NewRequest(method, path, nil)
+	r.Header.Set("Authorization", "Bearer "+testAdminToken)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	return w.Code
+}
+
+func TestAdminRestoreProxy(t *testing.T) {
+	ps := database.NewMemoryStorage()
+	ps.UpsertProxy("10.0.0.1", 8080, "http", "中国", "", "")
+	ps.UpsertProxy("10.0.0.2", 8080, "http", "中国", "", "")
+	ps.QuarantineProxy("10.0.0.1", 8080, database.ReasonBanned)
+	handler := newTestAdmin(t, ps)
+
+	tests := []struct {
+		name string
+		path string
+		want int
+	}{
+		{"隔离区中的代理", "/api/proxies/10.0.0.1/8080/restore", http.StatusOK},
+		{"已经恢复的代理", "/api/proxies/10.0.0.1/8080/restore", http.StatusNotFound},
+		{"未被隔离的代理", "/api/proxies/10.0.0.2/8080/restore", http.StatusNotFound},
+		{"不存在的代理", "/api/proxies/10.0.0.3/8080/restore", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		if got := adminRequest(handler, http.MethodPost, tt.path); got != tt.want {
+			t.Errorf("%s: 状态码 %d，期望 %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+// TestAdminForceCheckConflict 手动检测与定时检测共用检测器，定时检测进行中时返回 409
+func TestAdminForceCheckConflict(t *testing.T) {
+	// 接受连接但从不响应的代理，使检测一直进行到被取消
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	previous := healthChecker
+	healthChecker = proxyPool.NewChecker()
+	t.Cleanup(func() { healthChecker = previous })
+
+	ps := database.NewMemoryStorage()
+	handler := newTestAdmin(t, ps)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		healthChecker.CheckDue(ctx, []string{"http://" + listener.Addr().String()}, []string{"http://example.com"})
+	}()
+	for !healthChecker.Running() {
+		time.Sleep(time.Millisecond)
+	}
+
+	if got := adminRequest(handler, http.MethodPost, "/api/check"); got != http.StatusConflict {
+		t.Errorf("定时检测进行中时状态码 %d，期望 %d", got, http.StatusConflict)
+	}
+	cancel()
+	<-done
+
+	if got := adminRequest(handler, http.MethodPost, "/api/check"); got != http.StatusAccepted {
+		t.Errorf("没有检测进行时状态码 %d，期望 %d", got, http.StatusAccepted)
+	}
+	for adminCheckRunning.Load() {
+		time.Sleep(time.Millisecond)
+	}
+}
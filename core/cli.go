@@ -0,0 +1,141 @@
+package core
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"proxychain/common"
+	"proxychain/database"
+	"strings"
+)
+
+// Command 一个命令行子命令
+type Command struct {
+	Name   string
+	Usage  string
+	Run    func(args []string) error
+	IPData bool // 是否需要纯真 IP 数据库补全代理位置
+}
+
+// Commands 全部子命令，不指定子命令时运行 serve
+var Commands = []Command{
+	{Name: "serve", Usage: "启动代理服务与定时任务", Run: RunServe, IPData: true},
+	{Name: "check", Usage: "检测一次数据库中的代理或指定列表中的代理", Run: RunCheck},
+	{Name: "harvest", Usage: "从 hunter 与 fofa 获取一次代理并保存可用的代理", Run: RunHarvest, IPData: true},
+	{Name: "list", Usage: "按国家、协议与可信度筛选并列出代理", Run: RunList},
+	{Name: "stats", Usage: "输出代理池的统计信息", Run: RunStats},
+	{Name: "import", Usage: "从 json、csv 或地址列表导入代理", Run: RunImport},
+	{Name: "export", Usage: "导出全部代理为 json、csv 或地址列表", Run: RunExport},
+	{Name: "backup", Usage: "在线备份 SQLite 数据库", Run: RunBackup},
+	{Name: "replay", Usage: "使用指定的评分参数回放检测历史", Run: RunReplay},
+	{Name: "usage", Usage: "输出各用户的请求数与流量用量", Run: RunUsage},
+}
+
+// FindCommand 按名称查找子命令
+func FindCommand(name string) (Command, bool) {
+	for _, command := range Commands {
+		if command.Name == name {
+			return command, true
+		}
+	}
+	return Command{}, false
+}
+
+// PrintUsage 输出子命令列表
+func PrintUsage(w io.Writer) {
+	fmt.Fprintln(w, "用法: proxychain [子命令] [--config 配置文件] [--db 数据库] [参数]")
+	fmt.Fprintln(w, "\n子命令:")
+	for _, command := range Commands {
+		fmt.Fprintf(w, "  %-8s %s\n", command.Name, command.Usage)
+	}
+	fmt.Fprintln(w, "\n使用 proxychain <子命令> -h 查看子命令的参数")
+}
+
+// SplitCommand 从参数中找出子命令，返回子命令名称与去掉名称后的参数
+// 子命令之前可以出现 --config 与 --db，没有子命令时返回 serve
+func SplitCommand(args []string) (string, []string) {
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if !strings.HasPrefix(arg, "-") {
+			rest := append(append([]string{}, args[:i]...), args[i+1:]...)
+			return arg, rest
+		}
+
+		name, _, hasValue := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		if name != "config" && name != "db" {
+			break
+		}
+		if !hasValue {
+			// 跳过参数的值
+			i++
+		}
+	}
+	return "serve", args
+}
+
+// GlobalFlags 在加载配置之前从参数中读取 --config 与 --db，参数可以出现在子命令参数中的任意位置
+func GlobalFlags(args []string) (configPath, dbPath string) {
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			break
+		}
+
+		name, value, hasValue := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		if !strings.HasPrefix(arg, "-") || (name != "config" && name != "db") {
+			continue
+		}
+		if !hasValue && i+1 < len(args) {
+			i++
+			value = args[i]
+		}
+
+		if name == "config" {
+			configPath = value
+		} else {
+			dbPath = value
+		}
+	}
+	return configPath, dbPath
+}
+
+// OverrideDatabase 使用 --db 指定的数据库替换配置文件中的数据库，重新加载配置文件后仍然使用 --db 指定的数据库
+// postgres:// 开头的连接串使用 PostgreSQL，其余视为 SQLite 数据库文件路径
+func OverrideDatabase(dbPath string) {
+	if dbPath == "" {
+		return
+	}
+
+	common.Override(func(cfg *common.Config) {
+		switch {
+		case strings.HasPrefix(dbPath, "postgres://") || strings.HasPrefix(dbPath, "postgresql://"):
+			cfg.Database.Type, cfg.Database.DSN = database.TypePostgres, dbPath
+		case cfg.Database.Type == database.TypePostgres:
+			cfg.Database.DSN = dbPath
+		default:
+			cfg.Database.Type, cfg.Database.Path = database.TypeSQLite, dbPath
+		}
+	})
+}
+
+// newFlagSet 创建子命令的参数集合，--config 与 --db 已由 GlobalFlags 读取，这里声明是为了出现在帮助信息中
+func newFlagSet(name string) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.String("config", common.DefaultConfigPath, "配置文件路径")
+	flags.String("db", "", "数据库，SQLite 文件路径或 postgres:// 连接串，缺省时使用配置文件中的数据库")
+	return flags
+}
+
+// RunServe 启动代理服务，阻塞直到进程退出
+func RunServe(args []string) error {
+	flags := newFlagSet("serve")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	// 多余的参数通常是放错位置的子命令，直接启动服务会让脚本一直阻塞
+	if flags.NArg() > 0 {
+		return fmt.Errorf("未知的参数: %s", strings.Join(flags.Args(), " "))
+	}
+
+	return StartPipeline()
+}
@@ -0,0 +1,37 @@
+package core
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSplitCommand(t *testing.T) {
+	tests := []struct {
+		args []string
+		name string
+		rest []string
+	}{
+		{nil, "serve", nil},
+		{[]string{"list", "--country", "中国"}, "list", []string{"--country", "中国"}},
+		{[]string{"--db", "x.db", "stats"}, "stats", []string{"--db", "x.db"}},
+		{[]string{"--config", "c.yaml", "list", "--db=y.db"}, "list", []string{"--config", "c.yaml", "--db=y.db"}},
+		{[]string{"--config=c.yaml", "-db", "x.db", "export", "--format", "csv"}, "export", []string{"--config=c.yaml", "-db", "x.db", "--format", "csv"}},
+		{[]string{"--db", "x.db"}, "serve", []string{"--db", "x.db"}},
+		{[]string{"-h"}, "serve", []string{"-h"}},
+	}
+
+	for _, tt := range tests {
+		name, rest := SplitCommand(tt.args)
+		if name != tt.name || !reflect.DeepEqual(rest, tt.rest) {
+			t.Errorf("SplitCommand(%q) = %q, %q，期望 %q, %q", tt.args, name, rest, tt.name, tt.rest)
+		}
+	}
+}
+
+func TestRunServeRejectsStrayArguments(t *testing.T) {
+	err := RunServe([]string{"--db", "x.db", "stats"})
+	if err == nil || !strings.Contains(err.Error(), "stats") {
+		t.Fatalf("RunServe 返回 %v，期望拒绝多余的参数", err)
+	}
+}
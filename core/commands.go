@@ -0,0 +1,361 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"proxychain/common"
+	"proxychain/database"
+	"proxychain/proxyPool"
+	"sort"
+	"strings"
+	"time"
+)
+
+// RunCheck 检测一次代理。不指定文件时检测数据库中全部可用的代理并更新可信度，
+// 指定地址列表文件（- 表示标准输入）时只输出检测结果，-store 将可用的代理保存到数据库
+func RunCheck(args []string) error {
+	flags := newFlagSet("check")
+	dryRun := flags.Bool("dry-run", false, "检测数据库中的代理时只输出结果，不更新可信度与检测历史")
+	store := flags.Bool("store", false, "检测列表中的代理时将可用的代理保存到数据库")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if flags.NArg() > 0 {
+		return checkList(flags.Args(), *store)
+	}
+
+	ps, err := openStorage()
+	if err != nil {
+		return fmt.Errorf("打开数据库失败: %w", err)
+	}
+	defer ps.Close()
+
+	proxies, err := ps.GetActiveProxiesByPriority()
+	if err != nil {
+		return fmt.Errorf("读取代理失败: %w", err)
+	}
+	var proxyURLs []string
+	for _, proxy := range proxies {
+		proxyURLs = append(proxyURLs, proxy.URL)
+	}
+
+	// 新建的检测器中所有代理都已到期，即检测全部代理
+	results, _ := proxyPool.NewChecker().CheckDue(context.Background(), proxyURLs, checkTargetURLs)
+	if !*dryRun {
+		applyCheckResults(ps, results)
+		quarantineLowPriorityProxies(ps)
+	}
+
+	printCheckSummary(results)
+	return nil
+}
+
+// checkList 检测地址列表文件中的代理
+func checkList(paths []string, store bool) error {
+	var records []database.ProxyRecord
+	for _, path := range paths {
+		r := io.Reader(os.Stdin)
+		if path != "-" {
+			file, err := os.Open(path)
+			if err != nil {
+				return err
+			}
+			defer file.Close()
+			r = file
+		}
+
+		list, err := readListRecords(r)
+		if err != nil {
+			return fmt.Errorf("解析 %s 失败: %w", path, err)
+		}
+		records = append(records, list...)
+	}
+
+	var proxyURLs []string
+	for _, record := range records {
+		proxyURLs = append(proxyURLs, fmt.Sprintf("%s://%s:%d", record.Protocol, record.IP, record.Port))
+	}
+	results := proxyPool.CheckProxy(context.Background(), proxyURLs, checkTargetURLs)
+	printCheckSummary(results)
+
+	if !store {
+		return nil
+	}
+
+	ps, err := openStorage()
+	if err != nil {
+		return fmt.Errorf("打开数据库失败: %w", err)
+	}
+	defer ps.Close()
+
+	var stored int
+	for i, result := range results {
+		if !result.Success {
+			continue
+		}
+		record := records[i]
+		err := ps.UpsertProxy(record.IP, record.Port, record.Protocol, record.Country, record.Province, record.City)
+		if err != nil {
+			return fmt.Errorf("存储代理 %s 失败: %w", result.ProxyAddr, err)
+		}
+		stored++
+	}
+	fmt.Printf("已将 %d 个可用代理保存到数据库\n", stored)
+	return nil
+}
+
+// printCheckSummary 输出检测结果的汇总，包括各类失败原因的数量
+func printCheckSummary(results []proxyPool.ProxyCheckResult) {
+	var succeeded int
+	var latency time.Duration
+	failures := make(map[string]int)
+	for _, result := range results {
+		if result.Success {
+			succeeded++
+			latency += result.Latency
+		} else {
+			failures[string(common.ClassifyError(result.Error))]++
+		}
+	}
+
+	fmt.Printf("检测 %d 个代理，可用 %d 个，不可用 %d 个", len(results), succeeded, len(results)-succeeded)
+	if succeeded > 0 {
+		fmt.Printf("，平均耗时 %v", (latency / time.Duration(succeeded)).Round(time.Millisecond))
+	}
+	fmt.Println()
+	printCounts("失败原因", failures, 0)
+}
+
+// RunHarvest 从配置了 API key 的来源获取一次代理，检测后保存可用的代理
+func RunHarvest(args []string) error {
+	flags := newFlagSet("harvest")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if common.Current().Hunter.APIKey == "" && common.Current().Fofa.APIKey == "" {
+		return errors.New("没有配置 hunter 或 fofa 的 API key")
+	}
+
+	ps, err := openStorage()
+	if err != nil {
+		return fmt.Errorf("打开数据库失败: %w", err)
+	}
+	defer ps.Close()
+
+	before, err := ps.GetProxyCount()
+	if err != nil {
+		return fmt.Errorf("获取代理数量失败: %w", err)
+	}
+
+	proxyPool.GetProxyBase(context.Background(), ps)
+
+	after, err := ps.GetProxyCount()
+	if err != nil {
+		return fmt.Errorf("获取代理数量失败: %w", err)
+	}
+	fmt.Printf("获取完成，可用代理数量 %d -> %d\n", before, after)
+	return nil
+}
+
+// RunList 按条件筛选并列出代理，默认不包含隔离区中的代理
+func RunList(args []string) error {
+	flags := newFlagSet("list")
+	country := flags.String("country", "", "只列出指定国家的代理")
+	protocol := flags.String("protocol", "", "只列出指定协议的代理，例如 http、socks5")
+	minScore := flags.Int("min-score", 0, "只列出可信度不低于该值的代理")
+	maxScore := flags.Int("max-score", 0, "只列出可信度不高于该值的代理")
+	premium := flags.Bool("premium", false, "只列出高级代理池中的代理")
+	all := flags.Bool("all", false, "同时列出隔离区中的代理")
+	limit := flags.Int("limit", 0, "最多列出的数量，0 表示全部")
+	format := flags.String("format", "table", "输出格式: table、json、csv 或 list")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	// 只有显式指定的可信度条件才生效，因为可信度区间由评分模型决定
+	filter := proxyFilter{country: *country, protocol: *protocol, premium: *premium, all: *all, limit: *limit}
+	flags.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "min-score":
+			filter.minScore = minScore
+		case "max-score":
+			filter.maxScore = maxScore
+		}
+	})
+
+	ps, err := openStorage()
+	if err != nil {
+		return fmt.Errorf("打开数据库失败: %w", err)
+	}
+	defer ps.Close()
+
+	records, err := ps.ExportProxies()
+	if err != nil {
+		return fmt.Errorf("读取代理失败: %w", err)
+	}
+	matched := filter.apply(records)
+
+	if *format != "table" {
+		return writeRecords(os.Stdout, *format, matched)
+	}
+
+	fmt.Printf("%-30s %6s %-8s %-8s %-12s %s\n", "代理", "可信度", "层级", "状态", "带宽", "位置")
+	for _, record := range matched {
+		tier, status, bandwidth := TierStandard, "可用", "-"
+		if record.Premium {
+			tier = TierPremium
+		}
+		if !record.Active {
+			status = "隔离"
+		}
+		if record.Bandwidth != nil {
+			bandwidth = fmt.Sprintf("%.1f KB/s", *record.Bandwidth/1024)
+		}
+		location := strings.TrimSpace(strings.Join([]string{record.Country, record.Province, record.City}, " "))
+		fmt.Printf("%-30s %6d %-8s %-8s %-12s %s\n",
+			fmt.Sprintf("%s://%s:%d", record.Protocol, record.IP, record.Port),
+			record.Priority, tier, status, bandwidth, location)
+	}
+	fmt.Printf("共 %d 个代理\n", len(matched))
+	return nil
+}
+
+// proxyFilter 列出代理时的筛选条件，list 子命令与管理接口共用
+type proxyFilter struct {
+	country  string
+	protocol string
+	minScore *int // 为 nil 时不限制
+	maxScore *int
+	premium  bool // 只保留高级代理
+	all      bool // 包含隔离区中的代理
+	limit    int  // 0 表示不限制数量
+}
+
+// apply 返回满足筛选条件的代理，保持原有顺序
+func (f proxyFilter) apply(records []database.ProxyRecord) []database.ProxyRecord {
+	var matched []database.ProxyRecord
+	for _, record := range records {
+		switch {
+		case !f.all && !record.Active,
+			f.country != "" && record.Country != f.country,
+			f.protocol != "" && !strings.EqualFold(record.Protocol, f.protocol),
+			f.minScore != nil && record.Priority < *f.minScore,
+			f.maxScore != nil && record.Priority > *f.maxScore,
+			f.premium && !record.Premium:
+			continue
+		}
+		matched = append(matched, record)
+		if f.limit > 0 && len(matched) >= f.limit {
+			break
+		}
+	}
+	return matched
+}
+
+// RunStats 输出代理池的数量、分布与统计窗口内的可用率
+func RunStats(args []string) error {
+	flags := newFlagSet("stats")
+	window := flags.Duration("window", uptimeWindow(), "统计可用率的时间窗口")
+	top := flags.Int("top", 10, "按国家统计时输出的国家数量，0 表示全部")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	ps, err := openStorage()
+	if err != nil {
+		return fmt.Errorf("打开数据库失败: %w", err)
+	}
+	defer ps.Close()
+
+	records, err := ps.ExportProxies()
+	if err != nil {
+		return fmt.Errorf("读取代理失败: %w", err)
+	}
+
+	model := common.CurrentScoreModel()
+	bucketSize := max((model.Max-model.Min)/5, 1)
+
+	var active, premium int
+	countries := make(map[string]int)
+	protocols := make(map[string]int)
+	var scores [5]int
+	reasons := make(map[string]int)
+	for _, record := range records {
+		if !record.Active {
+			reasons[record.QuarantineReason]++
+			continue
+		}
+		active++
+		if record.Premium {
+			premium++
+		}
+		country := record.Country
+		if country == "" {
+			country = "未知"
+		}
+		countries[country]++
+		protocols[record.Protocol]++
+
+		// 可信度按评分区间五等分统计，超出区间的旧数据归入两端
+		scores[min(max((record.Priority-model.Min)/bucketSize, 0), len(scores)-1)]++
+	}
+
+	fmt.Printf("代理总数 %d，可用 %d（其中高级代理 %d），隔离区 %d\n",
+		len(records), active, premium, len(records)-active)
+	printCounts("协议", protocols, 0)
+	printCounts("国家", countries, *top)
+	fmt.Println("可信度:")
+	for i, count := range scores {
+		low := model.Min + i*bucketSize
+		fmt.Printf("  %-20s %d\n", fmt.Sprintf("%d-%d", low, low+bucketSize), count)
+	}
+	printCounts("隔离原因", reasons, 0)
+
+	uptimes, err := ps.GetUptimeStats(time.Now().Add(-*window))
+	if err != nil {
+		return fmt.Errorf("统计可用率失败: %w", err)
+	}
+	var total, successes int
+	for _, uptime := range uptimes {
+		total += uptime.Total
+		successes += uptime.Successes
+	}
+	if total > 0 {
+		fmt.Printf("最近 %v 共 %d 个代理产生 %d 条检测记录，整体可用率 %.1f%%\n",
+			*window, len(uptimes), total, float64(successes)*100/float64(total))
+	}
+	return nil
+}
+
+// printCounts 按数量从多到少输出分类统计，top 大于 0 时只输出前 top 项
+func printCounts(title string, counts map[string]int, top int) {
+	if len(counts) == 0 {
+		return
+	}
+
+	keys := make([]string, 0, len(counts))
+	for key := range counts {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if counts[keys[i]] != counts[keys[j]] {
+			return counts[keys[i]] > counts[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+
+	fmt.Printf("%s:\n", title)
+	for i, key := range keys {
+		if top > 0 && i >= top {
+			fmt.Printf("  ... 其余 %d 项\n", len(keys)-top)
+			break
+		}
+		fmt.Printf("  %-20s %d\n", key, counts[key])
+	}
+}
@@ -3,84 +3,139 @@ package core
 import (
 	"bufio"
 	"compress/gzip"
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
+	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net"
 	"net/http"
 	"net/url"
 	"proxychain/common"
 	"proxychain/database"
 	"proxychain/proxyPool"
+	"proxychain/socks5"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"golang.org/x/net/proxy"
 )
 
 var (
-	GlobeProxyList []string
-	proxyIndex     = 0
-	mu             sync.Mutex // 保护 proxyIndex 的并发访问
-	ps_tmp         *database.ProxyStorage
-	usageCount     = make(map[string]int) // 记录每个代理的使用次数
+	GlobeProxyList        []string
+	GlobePremiumProxyList []string // 高级代理池中当前使用的代理
+	proxyIndex            = 0
+	premiumIndex          = 0
+	mu                    sync.Mutex // 保护 proxyIndex 与 premiumIndex 的并发访问
+	ps_tmp                database.Storage
+	usageCount            = make(map[string]int) // 记录每个代理的使用次数
 )
 
-// loadProxies 从数据库中加载10个代理地址
-func loadProxies(ps *database.ProxyStorage) {
+// 各子系统的日志记录器
+var (
+	serverLog   = common.Logger(common.LogServer)
+	listenerLog = common.Logger(common.LogListener)
+	checkerLog  = common.Logger(common.LogChecker)
+	taskLog     = serverLog.With("component", "scheduler")
+	adminLog    = serverLog.With("component", "admin")
+)
+
+// maxAttempts 单个请求最多尝试的代理数量
+const maxAttempts = 3
+
+// errorClassNoProxy 没有可用代理时连接结果的错误分类
+const errorClassNoProxy = "no_proxy"
+
+// loadProxies 从数据库中加载10个代理地址，读取失败时保留当前的代理列表并返回错误
+func loadProxies(ps database.Storage) error {
 	ps_tmp = ps
 
 	var err error
 	var proxyList = make([]string, 0)
 
+	// 配置可能被重新加载，本次加载使用同一份配置
+	cfg := common.Current()
+
 	// 检查是否只获取中国的代理
-	onlyChina := common.GlobalConfig.Config.OnlyChina
+	onlyChina := cfg.Config.OnlyChina
+
+	// 配置的最低带宽单位为 KB/s，数据库中存储的是字节每秒
+	minBandwidth := float64(cfg.Throughput.MinThroughput) * 1024
 
 	// 按照模式来决定获取代理
-	if common.GlobalConfig.Config.ObtainingProxyMode == "random" {
+	if cfg.Config.ObtainingProxyMode == common.ProxyModeRandom {
 		if onlyChina {
-			proxyList, err = ps.GetRandomProxiesFromCountry(10, "中国")
+			proxyList, err = ps.GetRandomProxiesFromCountry(10, "中国", minBandwidth)
 		} else {
-			proxyList, err = ps.GetRandomProxies(10)
+			proxyList, err = ps.GetRandomProxies(10, minBandwidth)
 		}
 		if err != nil {
-			log.Fatalf("获取代理列表失败: %v", err)
+			return fmt.Errorf("获取代理列表失败: %w", err)
 		}
-		log.Printf("更新当前代理列表 random %v", proxyList)
-	} else if common.GlobalConfig.Config.ObtainingProxyMode == "priority" {
+	} else if cfg.Config.ObtainingProxyMode == common.ProxyModePriority {
 		if onlyChina {
-			proxyList, err = ps.GetActiveProxiesByPriorityFromCountry(10, "中国")
+			proxyList, err = ps.GetActiveProxiesByPriorityFromCountry(10, "中国", minBandwidth)
 		} else {
-			proxyList, err = ps.GetActiveProxiesByPriorityLimit(10)
+			proxyList, err = ps.GetActiveProxiesByPriorityLimit(10, minBandwidth)
 		}
 		if err != nil {
-			log.Fatalf("获取代理列表失败: %v", err)
+			return fmt.Errorf("获取代理列表失败: %w", err)
 		}
-		log.Printf("更新当前代理列表 priority %v", proxyList)
 	}
+	serverLog.Info("更新当前代理列表", "mode", cfg.Config.ObtainingProxyMode, "proxies", proxyList)
 
 	if len(proxyList) == 0 {
-		log.Println("数据库中没有可用的代理IP。")
-		proxyPool.GetProxyBase(ps)
+		serverLog.Warn("数据库中没有可用的代理，开始获取代理")
+		proxyPool.GetProxyBase(serverContext(), ps)
 	}
 
+	// 配置重新加载时 loadProxies 可能与定时任务并发执行，替换列表时加锁
+	mu.Lock()
 	GlobeProxyList = proxyList
+	proxyIndex = 0
+	mu.Unlock()
+
+	if cfg.Premium.Enabled {
+		loadPremiumProxies(ps, onlyChina, minBandwidth)
+	}
+	return nil
 }
 
-// getNextProxy 返回下一个代理地址
-func getNextProxy() string {
+// getNextProxy 返回指定层级的下一个代理地址，高级代理池为空时按配置回退到普通代理池
+func getNextProxy(tier Tier, logger *slog.Logger) string {
 	mu.Lock()
 	defer mu.Unlock()
 
+	if tier == TierPremium {
+		if len(GlobePremiumProxyList) > 0 {
+			if proxy, ok := pickProxy(GlobePremiumProxyList, &premiumIndex); ok {
+				usageCount[proxy]++
+				return proxy
+			}
+			if !common.Current().Premium.Fallback {
+				logger.Warn("高级代理均已达到并发或速率上限，无法获取下一个代理", "proxies", len(GlobePremiumProxyList))
+				return ""
+			}
+		} else if !common.Current().Premium.Fallback {
+			logger.Warn("高级代理列表为空，无法获取下一个代理")
+			return ""
+		}
+	}
+
 	if len(GlobeProxyList) == 0 {
-		log.Println("代理列表为空，无法获取下一个代理。")
-		proxyPool.GetProxyBase(ps_tmp)
+		logger.Warn("代理列表为空，无法获取下一个代理")
+		proxyPool.GetProxyBase(serverContext(), ps_tmp)
 		return ""
 	}
 
-	proxy := GlobeProxyList[proxyIndex]
-	proxyIndex = (proxyIndex + 1) % len(GlobeProxyList)
+	proxy, ok := pickProxy(GlobeProxyList, &proxyIndex)
+	if !ok {
+		logger.Warn("代理均已达到并发或速率上限，无法获取下一个代理", "proxies", len(GlobeProxyList))
+		return ""
+	}
 
 	// 增加使用次数
 	usageCount[proxy]++
@@ -89,10 +144,15 @@ func getNextProxy() string {
 }
 
 // refreshProxyList 刷新代理列表并重置计数
-func refreshProxyList() {
-	loadProxies(ps_tmp)
-	proxyIndex = 0
+func refreshProxyList() error {
+	if err := loadProxies(ps_tmp); err != nil {
+		return err
+	}
+
+	mu.Lock()
 	usageCount = make(map[string]int) // 重置使用次数计数
+	mu.Unlock()
+	return nil
 }
 
 func createDialer(proxyURL string) (proxy.Dialer, error) {
@@ -147,86 +207,218 @@ func (d *httpProxyDialer) Dial(network, addr string) (net.Conn, error) {
 
 	if resp.StatusCode != http.StatusOK {
 		conn.Close()
-		return nil, errors.New("HTTP 代理连接失败: " + resp.Status)
+		return nil, &common.ConnectError{StatusCode: resp.StatusCode, Status: resp.Status}
 	}
 
 	return conn, nil
 }
 
-func HandleConnection(clientConn net.Conn) {
-	defer clientConn.Close()
+// session 表示一个客户端连接，在多次重试之间共享
+type session struct {
+	id         string        // 请求 ID，出现在这个连接的每一行日志中
+	log        *slog.Logger  // 带有请求 ID 与客户端地址的日志记录器
+	conn       *countingConn // 统计与客户端之间传输的字节数
+	clientAddr string
+	user       string // 代理认证的用户名，没有认证时为空
+	account    string // 统计用量的用户名，即去掉代理池标记的用户名
+	accounted  bool   // 是否占用了用户的连接数限额，连接结束时释放并计入用量
+	targetRule string // 占用的目标域名规则，连接结束时释放
+	tier       Tier   // 客户端使用的代理池层级
+	start      time.Time
+
+	// 转发结果，连接结束时记录，kind 为空表示没有开始转发
+	kind       string
+	method     string
+	target     string
+	status     int    // 返回给客户端的 HTTP 状态码，隧道建立成功为 200
+	proxy      string // 最近一次尝试使用的代理
+	holding    bool   // 是否占用了 proxy 的上游连接限额，尝试失败或连接结束时释放
+	attempts   int
+	success    bool
+	errorClass string
+	latency    time.Duration
+}
 
-	clientAddr := clientConn.RemoteAddr().String()
-	proxyURL := getNextProxy()
+// newRequestID 生成随机的请求 ID
+func newRequestID() string {
+	var id [8]byte
+	rand.Read(id[:])
+	return hex.EncodeToString(id[:])
+}
+
+// useProxy 记录本次尝试使用的代理，proxyURL 为空表示没有可用的代理
+// 非空的 proxyURL 需要已经通过 upstreams.acquire 占用了上游连接限额
+func (s *session) useProxy(proxyURL string, attempt int) {
+	s.releaseUpstream()
+	s.proxy, s.attempts, s.holding = proxyURL, attempt, proxyURL != ""
 	if proxyURL == "" {
-		log.Printf("[%s] 无法获取代理，连接关闭。\n", clientAddr)
+		s.errorClass = errorClassNoProxy
+	}
+}
+
+// proxyFailed 降低代理的可信度，并记录本次尝试失败
+func (s *session) proxyFailed(ip string, port int, target string, cause error) {
+	s.releaseUpstream()
+	decreaseProxyPriority(s.log, ip, port, target, cause)
+
+	class := common.ClassifyError(cause)
+	s.success, s.errorClass = false, string(class)
+	s.log.Info("代理转发失败", "attempt", s.attempts, "proxy", s.proxy, "target", target, "error_class", class, "error", cause)
+	if class != common.ErrorClassClient {
+		monitor.recordAttempt(s.proxy, string(class))
+		attemptsTotal.add(1, "failure", string(class))
+	}
+}
+
+// proxySucceeded 增加代理的可信度，并记录本次尝试成功
+func (s *session) proxySucceeded(ip string, port int, target string, latency time.Duration) {
+	increaseProxyPriority(s.log, ip, port, target, latency)
+
+	s.success, s.errorClass, s.latency = true, "", latency
+	monitor.recordAttempt(s.proxy, "")
+	attemptsTotal.add(1, "success", "")
+	connectLatency.observe(latency.Seconds())
+}
+
+// finish 在连接结束时记录转发结果，供控制台、指标与访问日志使用
+func (s *session) finish() {
+	s.releaseUsage()
+	s.releaseUpstream()
+	s.releaseTarget()
+	if s.kind == "" {
 		return
 	}
 
-	// 解析IP和端口，准备在成功时增加优先级
-	ip, port := extractIPAndPort(proxyURL)
+	outcome := "success"
+	if !s.success {
+		outcome = "failure"
+	}
+	requestsTotal.add(1, s.kind, string(s.tier), outcome, s.errorClass)
+	if s.attempts > 1 {
+		retriesTotal.add(float64(s.attempts-1), s.kind)
+	}
+
+	monitor.recordRoute(routeEvent{
+		ID:         s.id,
+		Time:       time.Now(),
+		Client:     s.clientAddr,
+		Kind:       s.kind,
+		Target:     s.target,
+		Tier:       s.tier,
+		Proxy:      s.proxy,
+		Attempts:   s.attempts,
+		Success:    s.success,
+		ErrorClass: s.errorClass,
+		LatencyMs:  s.latency.Milliseconds(),
+		DurationMs: time.Since(s.start).Milliseconds(),
+	})
+	accessLog.record(s)
+}
 
-	dialer, err := createDialer(proxyURL)
+// HandleConnection 处理普通监听端口上的客户端连接
+func HandleConnection(clientConn net.Conn) {
+	handleConnection(clientConn, TierStandard)
+}
+
+// handleConnection 处理客户端连接，tier 为监听端口对应的代理池层级
+func handleConnection(clientConn net.Conn, tier Tier) {
+	defer clientConn.Close()
+
+	id, clientAddr := newRequestID(), clientConn.RemoteAddr().String()
+	s := &session{
+		id:         id,
+		log:        listenerLog.With("request_id", id, "client", clientAddr),
+		conn:       &countingConn{Conn: clientConn},
+		clientAddr: clientAddr,
+		tier:       tier,
+		start:      time.Now(),
+	}
+	defer s.finish()
+
+	// 根据首字节区分 SOCKS5 与 HTTP 代理请求
+	clientReader := bufio.NewReader(s.conn)
+	first, err := clientReader.Peek(1)
 	if err != nil {
-		log.Printf("[%s] 使用代理: %s, 创建拨号器失败: %v\n", clientAddr, proxyURL, err)
-		decreaseProxyPriority(ip, port)
-		tryNextProxy(clientConn)
+		return
+	}
+	if first[0] == socks5.Version5 {
+		handleSOCKS5(s, clientReader)
 		return
 	}
 
-	clientReader := bufio.NewReader(clientConn)
+	// 先读取客户端请求，请求异常属于客户端错误，不应影响任何代理的可信度
 	request, err := http.ReadRequest(clientReader)
 	if err != nil {
-		log.Printf("[%s] 使用代理: %s, 读取HTTP请求失败: %v\n", clientAddr, proxyURL, err)
-		decreaseProxyPriority(ip, port)
-		tryNextProxy(clientConn)
+		s.log.Debug("读取HTTP请求失败", "error", &common.ClientError{Err: err})
 		return
 	}
 
-	host := request.Host
-	if !strings.Contains(host, ":") {
-		if request.URL.Scheme == "https" {
-			host += ":443"
-		} else {
-			host += ":80"
-		}
+	// 配置了用户时要求认证，认证通过后检查用户的限额
+	username, password, _ := proxyAuthCredentials(request.Header.Get("Proxy-Authorization"))
+	if !s.authenticate(username, password) || !s.acquireQuota() {
+		s.describeRequest(request)
+		refuseHTTP(s)
+		return
+	}
+
+	// 根据请求头或代理认证的用户名选择代理池，相关请求头不会转发给目标
+	if requestedTier(request) == TierPremium {
+		s.tier = TierPremium
+	}
+
+	// 目标域名超出限制时排队等待，超时后拒绝，不占用任何上游代理
+	s.describeRequest(request)
+	if !s.acquireTarget() {
+		refuseHTTP(s)
+		return
 	}
 
 	// 添加 Accept-Encoding 头以支持 gzip 压缩
 	request.Header.Set("Accept-Encoding", "gzip")
 
-	log.Printf("[%s] 使用代理: %s, 原地址: %s -> 目标地址: %s",
-		clientAddr, proxyURL, clientAddr, host)
+	forwardRequest(s, request, 1)
+}
 
-	if request.Method == http.MethodConnect {
-		handleHTTPS(clientConn, request, dialer, ip, port)
-	} else {
-		handleHTTP(clientConn, request, dialer, ip, port)
+// forwardRequest 选择一个代理转发请求，attempt 表示当前是第几次尝试
+func forwardRequest(s *session, request *http.Request, attempt int) {
+	s.describeRequest(request)
+
+	proxyURL := getNextProxy(s.tier, s.log)
+	s.useProxy(proxyURL, attempt)
+	if proxyURL == "" {
+		s.log.Warn("无法获取代理，连接关闭")
+		return
 	}
-}
 
-func handleHTTPS(clientConn net.Conn, request *http.Request, dialer proxy.Dialer, ip string, port int) {
-	host := request.Host
+	// 解析IP和端口，准备在成功时增加优先级
+	ip, port := extractIPAndPort(proxyURL)
 
-	serverConn, err := dialer.Dial("tcp", host)
+	dialer, err := createDialer(proxyURL)
 	if err != nil {
-		log.Printf("连接到服务器失败: %v\n", err)
-		decreaseProxyPriority(ip, port)
-		tryNextProxy(clientConn)
+		s.proxyFailed(ip, port, "", err)
+		tryNextProxy(s, request, attempt)
 		return
 	}
-	defer serverConn.Close()
 
-	clientConn.Write([]byte("HTTP/1.1 200 Connection Established\r\n\r\n"))
+	s.log.Debug("使用代理转发", "kind", s.kind, "attempt", attempt, "proxy", proxyURL, "target", s.target, "tier", s.tier)
 
-	go io.Copy(serverConn, clientConn)
-	io.Copy(clientConn, serverConn)
+	if request.Method == http.MethodConnect {
+		handleHTTPS(s, request, dialer, ip, port, attempt)
+	} else {
+		handleHTTP(s, request, dialer, ip, port, attempt)
+	}
+}
 
-	// 增加成功代理的优先级
-	increaseProxyPriority(ip, port)
+// describeRequest 记录请求的类型、方法与目标地址
+func (s *session) describeRequest(request *http.Request) {
+	s.kind, s.method, s.target = "http", request.Method, targetHost(request)
+	if request.Method == http.MethodConnect {
+		s.kind = "connect"
+	}
 }
 
-func handleHTTP(clientConn net.Conn, request *http.Request, dialer proxy.Dialer, ip string, port int) {
+// targetHost 返回请求的目标地址，缺少端口时按协议补全
+func targetHost(request *http.Request) string {
 	host := request.Host
 	if !strings.Contains(host, ":") {
 		if request.URL.Scheme == "https" {
@@ -235,21 +427,49 @@ func handleHTTP(clientConn net.Conn, request *http.Request, dialer proxy.Dialer,
 			host += ":80"
 		}
 	}
+	return host
+}
+
+func handleHTTPS(s *session, request *http.Request, dialer proxy.Dialer, ip string, port int, attempt int) {
+	host := request.Host
 
+	start := time.Now()
 	serverConn, err := dialer.Dial("tcp", host)
 	if err != nil {
-		log.Printf("连接到服务器失败: %v\n", err)
-		decreaseProxyPriority(ip, port)
-		tryNextProxy(clientConn)
+		s.proxyFailed(ip, port, host, err)
+		tryNextProxy(s, request, attempt)
+		return
+	}
+	defer serverConn.Close()
+	latency := time.Since(start)
+
+	s.status = http.StatusOK
+	s.conn.Write([]byte("HTTP/1.1 200 Connection Established\r\n\r\n"))
+	defer openTunnel(s.kind)()
+
+	go io.Copy(serverConn, s.conn)
+	io.Copy(s.conn, serverConn)
+
+	// 增加成功代理的优先级
+	s.proxySucceeded(ip, port, host, latency)
+}
+
+func handleHTTP(s *session, request *http.Request, dialer proxy.Dialer, ip string, port int, attempt int) {
+	host := targetHost(request)
+
+	start := time.Now()
+	serverConn, err := dialer.Dial("tcp", host)
+	if err != nil {
+		s.proxyFailed(ip, port, host, err)
+		tryNextProxy(s, request, attempt)
 		return
 	}
 	defer serverConn.Close()
 
 	err = request.Write(serverConn)
 	if err != nil {
-		log.Printf("写入请求到服务器失败: %v\n", err)
-		decreaseProxyPriority(ip, port)
-		tryNextProxy(clientConn)
+		s.proxyFailed(ip, port, host, err)
+		tryNextProxy(s, request, attempt)
 		return
 	}
 
@@ -257,12 +477,12 @@ func handleHTTP(clientConn net.Conn, request *http.Request, dialer proxy.Dialer,
 	serverReader := bufio.NewReader(serverConn)
 	response, err := http.ReadResponse(serverReader, request)
 	if err != nil {
-		log.Printf("读取服务器响应失败: %v\n", err)
-		decreaseProxyPriority(ip, port)
-		tryNextProxy(clientConn)
+		s.proxyFailed(ip, port, host, err)
+		tryNextProxy(s, request, attempt)
 		return
 	}
 	defer response.Body.Close()
+	latency := time.Since(start)
 
 	// 解压缩服务器响应
 	var reader io.Reader
@@ -270,9 +490,8 @@ func handleHTTP(clientConn net.Conn, request *http.Request, dialer proxy.Dialer,
 	case "gzip":
 		reader, err = gzip.NewReader(response.Body)
 		if err != nil {
-			log.Printf("创建gzip解压缩器失败: %v\n", err)
-			decreaseProxyPriority(ip, port)
-			tryNextProxy(clientConn)
+			s.proxyFailed(ip, port, host, err)
+			tryNextProxy(s, request, attempt)
 			return
 		}
 		defer reader.(*gzip.Reader).Close()
@@ -280,83 +499,83 @@ func handleHTTP(clientConn net.Conn, request *http.Request, dialer proxy.Dialer,
 		reader = response.Body
 	}
 
-	// 将响应写回客户端
-	err = response.Write(clientConn)
+	// 将响应写回客户端，此时失败说明客户端已断开，不再重试也不扣减代理可信度
+	s.status = response.StatusCode
+	err = response.Write(s.conn)
 	if err != nil {
-		log.Printf("写入响应到客户端失败: %v\n", err)
-		decreaseProxyPriority(ip, port)
-		tryNextProxy(clientConn)
+		s.log.Debug("写入响应到客户端失败", "error", &common.ClientError{Err: err})
+		s.errorClass = string(common.ErrorClassClient)
 		return
 	}
-	io.Copy(clientConn, reader)
+	io.Copy(s.conn, reader)
 
 	// 增加成功代理的优先级
-	increaseProxyPriority(ip, port)
+	s.proxySucceeded(ip, port, host, latency)
 }
 
-// tryNextProxy 更换代理并重试连接
-func tryNextProxy(clientConn net.Conn) {
-	clientAddr := clientConn.RemoteAddr().String()
-	proxyURL := getNextProxy()
-	if proxyURL == "" {
-		log.Printf("[%s] 无法获取新的代理，连接关闭。\n", clientAddr)
+// tryNextProxy 更换代理并重放同一个请求，超过最大尝试次数或请求体无法重放时放弃
+func tryNextProxy(s *session, request *http.Request, attempt int) {
+	if attempt >= maxAttempts {
+		s.log.Warn("所有尝试的代理均失败，连接关闭", "attempts", attempt, "target", s.target)
 		return
 	}
 
-	// 解析IP和端口，准备在成功时增加优先级
-	ip, port := extractIPAndPort(proxyURL)
-
-	dialer, err := createDialer(proxyURL)
-	if err != nil {
-		log.Printf("[%s] 使用新代理: %s, 创建拨号器失败: %v\n", clientAddr, proxyURL, err)
-		decreaseProxyPriority(ip, port)
+	// 请求体已经发送给上一个代理，无法再次发送
+	if request.Method != http.MethodConnect && request.Body != nil && request.Body != http.NoBody {
+		s.log.Warn("请求携带请求体，无法更换代理重试，连接关闭", "target", s.target)
 		return
 	}
 
-	// 重新尝试连接
-	clientReader := bufio.NewReader(clientConn)
-	request, err := http.ReadRequest(clientReader)
-	if err != nil {
-		log.Printf("[%s] 使用新代理: %s, 读取HTTP请求失败: %v\n", clientAddr, proxyURL, err)
-		decreaseProxyPriority(ip, port)
+	forwardRequest(s, request, attempt+1)
+}
+
+// decreaseProxyPriority 按错误分类与实际流量的权重降低代理的优先级，并记录本次失败的流量结果
+// 客户端引起的错误与代理无关，既不扣减也不记录
+func decreaseProxyPriority(logger *slog.Logger, ip string, port int, target string, cause error) {
+	class := common.ClassifyError(cause)
+	if class == common.ErrorClassClient {
 		return
 	}
 
-	host := request.Host
-	if !strings.Contains(host, ":") {
-		if request.URL.Scheme == "https" {
-			host += ":443"
-		} else {
-			host += ":80"
+	if penalty := common.CurrentScoreModel().Weighted(class.Penalty(), true); penalty > 0 {
+		err := ps_tmp.DecreasePriority(ip, port, penalty)
+		if err != nil {
+			logger.Error("降低代理优先级失败", "ip", ip, "port", port, "error", err)
 		}
 	}
 
-	// 添加 Accept-Encoding 头以支持 gzip 压缩
-	request.Header.Set("Accept-Encoding", "gzip")
-
-	log.Printf("[%s] 使用新代理: %s, 原地址: %s -> 目标地址: %s",
-		clientAddr, proxyURL, clientAddr, host)
-
-	if request.Method == http.MethodConnect {
-		handleHTTPS(clientConn, request, dialer, ip, port)
-	} else {
-		handleHTTP(clientConn, request, dialer, ip, port)
-	}
+	recordTraffic(logger, database.CheckRecord{
+		IP:         ip,
+		Port:       port,
+		Target:     target,
+		Success:    false,
+		ErrorClass: string(class),
+	})
 }
 
-// decreaseProxyPriority 调用数据库接口降低代理的优先级
-func decreaseProxyPriority(ip string, port int) {
-	err := ps_tmp.DecreasePriority(ip, port)
+// increaseProxyPriority 按实际流量的权重增加代理的优先级，并记录本次成功的流量结果
+func increaseProxyPriority(logger *slog.Logger, ip string, port int, target string, latency time.Duration) {
+	reward := common.CurrentScoreModel().Weighted(common.Current().Config.PriorityUpNum, true)
+	err := ps_tmp.IncreasePriority(ip, port, reward)
 	if err != nil {
-		log.Printf("降低代理优先级失败: %v\n", err)
+		logger.Error("增加代理优先级失败", "ip", ip, "port", port, "error", err)
 	}
+
+	recordTraffic(logger, database.CheckRecord{
+		IP:      ip,
+		Port:    port,
+		Target:  target,
+		Success: true,
+		Latency: latency,
+	})
 }
 
-// increaseProxyPriority 调用数据库接口增加代理的优先级
-func increaseProxyPriority(ip string, port int) {
-	err := ps_tmp.IncreasePriority(ip, port)
-	if err != nil {
-		log.Printf("增加代理优先级失败: %v\n", err)
+// recordTraffic 将实际流量的结果写入检测历史
+func recordTraffic(logger *slog.Logger, record database.CheckRecord) {
+	record.CheckedAt = time.Now()
+	record.Source = database.SourceTraffic
+	if err := ps_tmp.RecordCheck(record); err != nil {
+		logger.Error("记录流量结果失败", "error", err)
 	}
 }
 
@@ -364,7 +583,7 @@ func increaseProxyPriority(ip string, port int) {
 func extractIPAndPort(proxyAddr string) (string, int) {
 	parsedURL, err := url.Parse(proxyAddr)
 	if err != nil {
-		log.Printf("解析代理地址失败: %v", err)
+		listenerLog.Error("解析代理地址失败", "proxy", proxyAddr, "error", err)
 		return "", 0
 	}
 
@@ -373,7 +592,7 @@ func extractIPAndPort(proxyAddr string) (string, int) {
 
 	port, err := strconv.Atoi(portStr)
 	if err != nil {
-		log.Printf("转换端口失败: %v", err)
+		listenerLog.Error("转换端口失败", "proxy", proxyAddr, "error", err)
 		return "", 0
 	}
 
@@ -0,0 +1,149 @@
+package core
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"proxychain/database"
+	"proxychain/proxyPool"
+	"time"
+)
+
+// 控制台展示的参数
+const (
+	dashboardRateSeconds = 120 // 请求速率曲线的时长，单位秒
+	dashboardTopFailing  = 10  // 展示失败次数最多的代理数量
+	routeKeepAlive       = 15 * time.Second
+)
+
+//go:embed web/dashboard.html
+var dashboardHTML []byte
+
+// serveDashboard 返回控制台页面，页面在浏览器中保存令牌并请求 /api/ 下的接口
+func serveDashboard(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(dashboardHTML)
+}
+
+// poolSummary 代理池按国家、协议与层级的分布，只统计可用的代理
+type poolSummary struct {
+	Total       int            `json:"total"`
+	Quarantined int            `json:"quarantined"`
+	ByCountry   map[string]int `json:"by_country"`
+	ByProtocol  map[string]int `json:"by_protocol"`
+	ByTier      map[Tier]int   `json:"by_tier"`
+}
+
+// trafficSummary 最近一段时间的请求速率与成功率
+type trafficSummary struct {
+	RatePerSecond float64      `json:"rate_per_second"` // 最近一分钟平均每秒结束的连接数
+	SuccessRate   float64      `json:"success_rate"`    // 最近一分钟的成功率，没有连接时为 0
+	Requests      int          `json:"requests"`
+	Successes     int          `json:"successes"`
+	Series        []rateSample `json:"series"`
+}
+
+// dashboard 返回控制台需要的全部数据
+func (api *adminAPI) dashboard(w http.ResponseWriter, r *http.Request) {
+	counts, err := api.ps.GetPoolCounts()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	series := monitor.rates(dashboardRateSeconds)
+	traffic := trafficSummary{Series: series}
+	for _, sample := range series[max(len(series)-60, 0):] {
+		traffic.Requests += sample.Total
+		traffic.Successes += sample.Success
+	}
+	traffic.RatePerSecond = float64(traffic.Requests) / 60
+	if traffic.Requests > 0 {
+		traffic.SuccessRate = float64(traffic.Successes) / float64(traffic.Requests)
+	}
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"pool":        summarizePool(counts),
+		"traffic":     traffic,
+		"top_failing": monitor.topFailing(dashboardTopFailing),
+		"harvest":     proxyPool.HarvestResults(),
+	})
+}
+
+// summarizePool 汇总分组后的代理数量，得到代理池按国家、协议与层级的分布
+func summarizePool(counts []database.PoolCount) poolSummary {
+	pool := poolSummary{
+		ByCountry:  make(map[string]int),
+		ByProtocol: make(map[string]int),
+		ByTier:     map[Tier]int{TierStandard: 0, TierPremium: 0},
+	}
+	for _, count := range counts {
+		if !count.Active {
+			pool.Quarantined += count.Count
+			continue
+		}
+
+		country := count.Country
+		if country == "" {
+			country = "未知"
+		}
+		pool.Total += count.Count
+		pool.ByCountry[country] += count.Count
+		pool.ByProtocol[count.Protocol] += count.Count
+		if count.Premium {
+			pool.ByTier[TierPremium] += count.Count
+		} else {
+			pool.ByTier[TierStandard] += count.Count
+		}
+	}
+	return pool
+}
+
+// routes 以 Server-Sent Events 推送最近的连接记录，之后实时推送新结束的连接
+func (api *adminAPI) routes(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, "不支持流式响应")
+		return
+	}
+
+	recent, events, cancel := monitor.subscribe()
+	defer cancel()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	send := func(event routeEvent) bool {
+		data, _ := json.Marshal(event)
+		_, err := fmt.Fprintf(w, "data: %s\n\n", data)
+		return err == nil
+	}
+	for _, event := range recent {
+		if !send(event) {
+			return
+		}
+	}
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(routeKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case event := <-events:
+			if !send(event) {
+				return
+			}
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		case <-api.srv.stopping:
+			return
+		}
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,296 @@
+package core
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"proxychain/common"
+	"proxychain/proxyPool"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// labelEscaper 按 Prometheus 文本格式转义标签取值
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// connectLatencyBuckets 经代理连接目标耗时的直方图分桶，单位秒
+var connectLatencyBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// 转发相关的指标，标签只包含连接类型、层级与错误分类等有限的取值，不包含客户端或代理的地址
+var (
+	requestsTotal = newCounterVec("proxychain_requests_total",
+		"客户端连接的转发结果", "kind", "tier", "outcome", "error_class")
+	attemptsTotal = newCounterVec("proxychain_upstream_attempts_total",
+		"使用上游代理的每次尝试的结果", "outcome", "error_class")
+	retriesTotal = newCounterVec("proxychain_retries_total",
+		"更换代理重试的次数", "kind")
+	clientBytesTotal = newCounterVec("proxychain_client_bytes_total",
+		"与客户端之间传输的字节数，in 为从客户端读取，out 为写给客户端", "direction")
+	healthChecksTotal = newCounterVec("proxychain_health_checks_total",
+		"健康检测的结果，pool 为 active 表示可用代理的检测，quarantine 表示隔离区的复检", "pool", "result")
+	upstreamSaturatedTotal = newCounterVec("proxychain_upstream_saturated_total",
+		"上游代理达到并发或速率上限而被跳过的次数")
+	targetLimitedTotal = newCounterVec("proxychain_target_limited_total",
+		"超出目标域名限制的连接，result 为 queued 表示排队后放行，rejected 表示排队超时被拒绝", "rule", "result")
+	connectLatency = newHistogram("proxychain_upstream_connect_seconds",
+		"经上游代理连接目标的耗时，只统计成功的连接", connectLatencyBuckets)
+
+	activeTunnels sync.Map // 连接类型 -> *atomic.Int64，正在转发数据的隧道数量
+)
+
+// openTunnel 记录一个开始转发数据的隧道，返回的函数在隧道关闭时调用
+func openTunnel(kind string) func() {
+	value, _ := activeTunnels.LoadOrStore(kind, new(atomic.Int64))
+	count := value.(*atomic.Int64)
+	count.Add(1)
+	return func() { count.Add(-1) }
+}
+
+// countingConn 统计客户端连接读写的字节数
+type countingConn struct {
+	net.Conn
+	read    atomic.Int64
+	written atomic.Int64
+}
+
+func (c *countingConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.read.Add(int64(n))
+	clientBytesTotal.add(float64(n), "in")
+	return n, err
+}
+
+func (c *countingConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	c.written.Add(int64(n))
+	clientBytesTotal.add(float64(n), "out")
+	return n, err
+}
+
+// recordHealthChecks 统计一轮健康检测的结果
+func recordHealthChecks(pool string, results []proxyPool.ProxyCheckResult) {
+	for _, result := range results {
+		if result.Success {
+			healthChecksTotal.add(1, pool, "pass")
+		} else {
+			healthChecksTotal.add(1, pool, "fail")
+		}
+	}
+}
+
+// serveMetrics 以 Prometheus 文本格式输出指标，配置 metrics.public 时无需令牌
+func (api *adminAPI) serveMetrics(w http.ResponseWriter, r *http.Request) {
+	cfg := common.Current().Metrics
+	if !cfg.Enabled {
+		http.NotFound(w, r)
+		return
+	}
+	if !cfg.Public {
+		requireToken(http.HandlerFunc(api.writeMetrics)).ServeHTTP(w, r)
+		return
+	}
+	api.writeMetrics(w, r)
+}
+
+func (api *adminAPI) writeMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	out := bufio.NewWriter(w)
+	defer out.Flush()
+
+	requestsTotal.write(out)
+	attemptsTotal.write(out)
+	retriesTotal.write(out)
+	upstreamSaturatedTotal.write(out)
+	targetLimitedTotal.write(out)
+	connectLatency.write(out)
+	clientBytesTotal.write(out)
+
+	writeMetricHeader(out, "proxychain_active_connections", "活动的客户端连接数", "gauge")
+	writeSample(out, "proxychain_active_connections", nil, float64(api.srv.activeConns()))
+
+	writeMetricHeader(out, "proxychain_active_tunnels", "正在转发数据的隧道数量", "gauge")
+	activeTunnels.Range(func(kind, count any) bool {
+		writeSample(out, "proxychain_active_tunnels", []string{"kind", kind.(string)}, float64(count.(*atomic.Int64).Load()))
+		return true
+	})
+
+	// 只读取分组后的数量，抓取不会读取整张代理表，也不会提前写入批量累积的变化
+	if counts, err := api.ps.GetPoolCounts(); err == nil {
+		pool := summarizePool(counts)
+		writeMetricHeader(out, "proxychain_pool_proxies", "代理池中的代理数量，quarantined 为隔离区中的代理", "gauge")
+		for _, tier := range []Tier{TierStandard, TierPremium} {
+			writeSample(out, "proxychain_pool_proxies", []string{"tier", string(tier)}, float64(pool.ByTier[tier]))
+		}
+		writeSample(out, "proxychain_pool_proxies", []string{"tier", "quarantined"}, float64(pool.Quarantined))
+		writeGaugeMap(out, "proxychain_pool_proxies_by_country", "可用代理按国家的数量", "country", pool.ByCountry)
+		writeGaugeMap(out, "proxychain_pool_proxies_by_protocol", "可用代理按协议的数量", "protocol", pool.ByProtocol)
+	}
+
+	healthChecksTotal.write(out)
+
+	harvest := proxyPool.HarvestResults()
+	writeMetricHeader(out, "proxychain_harvest_runs_total", "从数据源获取代理的次数", "counter")
+	for _, result := range harvest {
+		writeSample(out, "proxychain_harvest_runs_total", []string{"source", result.Source, "result", "success"}, float64(result.Runs-result.Failures))
+		writeSample(out, "proxychain_harvest_runs_total", []string{"source", result.Source, "result", "failure"}, float64(result.Failures))
+	}
+	writeMetricHeader(out, "proxychain_harvest_consumed_quota_total", "从数据源获取代理累计消耗的额度，hunter 为积分，fofa 为 F 点", "counter")
+	for _, result := range harvest {
+		writeSample(out, "proxychain_harvest_consumed_quota_total", []string{"source", result.Source}, float64(result.TotalConsumedQuota))
+	}
+	writeMetricHeader(out, "proxychain_harvest_stored_proxies", "最近一次从数据源获取并保存的代理数量", "gauge")
+	for _, result := range harvest {
+		writeSample(out, "proxychain_harvest_stored_proxies", []string{"source", result.Source}, float64(result.Stored))
+	}
+
+	writeMetricHeader(out, "proxychain_uptime_seconds", "代理服务已运行的时长", "gauge")
+	writeSample(out, "proxychain_uptime_seconds", nil, time.Since(api.srv.startedAt).Seconds())
+
+	// 按代理地址区分的指标数量随代理池增长，默认不输出
+	if common.Current().Metrics.PerProxy {
+		outcomes := monitor.outcomes()
+		writeMetricHeader(out, "proxychain_proxy_attempts_total", "每个上游代理的尝试次数", "counter")
+		for _, outcome := range outcomes {
+			writeSample(out, "proxychain_proxy_attempts_total", []string{"proxy", outcome.Proxy, "outcome", "success"}, float64(outcome.Successes))
+			writeSample(out, "proxychain_proxy_attempts_total", []string{"proxy", outcome.Proxy, "outcome", "failure"}, float64(outcome.Failures))
+		}
+		writeMetricHeader(out, "proxychain_proxy_active_connections", "正在使用每个上游代理的连接数", "gauge")
+		for _, status := range upstreams.snapshot() {
+			writeSample(out, "proxychain_proxy_active_connections", []string{"proxy", status.Proxy}, float64(status.Active))
+		}
+	}
+}
+
+// counterVec 按标签取值分别累加的计数器
+type counterVec struct {
+	name   string
+	help   string
+	labels []string
+
+	mu     sync.Mutex
+	values map[string]float64 // 以 \xff 连接的标签取值 -> 计数
+}
+
+func newCounterVec(name, help string, labels ...string) *counterVec {
+	return &counterVec{name: name, help: help, labels: labels, values: make(map[string]float64)}
+}
+
+// add 累加计数，标签取值的顺序与创建时的标签名一致
+func (c *counterVec) add(delta float64, labelValues ...string) {
+	key := strings.Join(labelValues, "\xff")
+	c.mu.Lock()
+	c.values[key] += delta
+	c.mu.Unlock()
+}
+
+func (c *counterVec) write(w io.Writer) {
+	c.mu.Lock()
+	keys := make([]string, 0, len(c.values))
+	for key := range c.values {
+		keys = append(keys, key)
+	}
+	values := make(map[string]float64, len(c.values))
+	for key, value := range c.values {
+		values[key] = value
+	}
+	c.mu.Unlock()
+	sort.Strings(keys)
+
+	writeMetricHeader(w, c.name, c.help, "counter")
+	for _, key := range keys {
+		var pairs []string
+		if len(c.labels) > 0 {
+			for i, value := range strings.Split(key, "\xff") {
+				pairs = append(pairs, c.labels[i], value)
+			}
+		}
+		writeSample(w, c.name, pairs, values[key])
+	}
+}
+
+// histogram 没有标签的直方图
+type histogram struct {
+	name    string
+	help    string
+	buckets []float64
+
+	mu     sync.Mutex
+	counts []uint64 // 每个分桶的计数，不累加
+	sum    float64
+	count  uint64
+}
+
+func newHistogram(name, help string, buckets []float64) *histogram {
+	return &histogram{name: name, help: help, buckets: buckets, counts: make([]uint64, len(buckets))}
+}
+
+func (h *histogram) observe(value float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for i, bound := range h.buckets {
+		if value <= bound {
+			h.counts[i]++
+			break
+		}
+	}
+	h.sum += value
+	h.count++
+}
+
+func (h *histogram) write(w io.Writer) {
+	h.mu.Lock()
+	counts := append([]uint64{}, h.counts...)
+	sum, count := h.sum, h.count
+	h.mu.Unlock()
+
+	writeMetricHeader(w, h.name, h.help, "histogram")
+	var cumulative uint64
+	for i, bound := range h.buckets {
+		cumulative += counts[i]
+		writeSample(w, h.name+"_bucket", []string{"le", strconv.FormatFloat(bound, 'g', -1, 64)}, float64(cumulative))
+	}
+	writeSample(w, h.name+"_bucket", []string{"le", "+Inf"}, float64(count))
+	writeSample(w, h.name+"_sum", nil, sum)
+	writeSample(w, h.name+"_count", nil, float64(count))
+}
+
+func writeMetricHeader(w io.Writer, name, help, typ string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+// writeSample 输出一个样本，pairs 为交替的标签名与标签取值
+func writeSample(w io.Writer, name string, pairs []string, value float64) {
+	if len(pairs) == 0 {
+		fmt.Fprintf(w, "%s %s\n", name, formatMetricValue(value))
+		return
+	}
+
+	labels := make([]string, 0, len(pairs)/2)
+	for i := 0; i+1 < len(pairs); i += 2 {
+		labels = append(labels, pairs[i]+`="`+labelEscaper.Replace(pairs[i+1])+`"`)
+	}
+	fmt.Fprintf(w, "%s{%s} %s\n", name, strings.Join(labels, ","), formatMetricValue(value))
+}
+
+func writeGaugeMap(w io.Writer, name, help, label string, values map[string]int) {
+	writeMetricHeader(w, name, help, "gauge")
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		writeSample(w, name, []string{label, key}, float64(values[key]))
+	}
+}
+
+func formatMetricValue(value float64) string {
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
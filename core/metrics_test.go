@@ -0,0 +1,58 @@
+package core
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"proxychain/common"
+	"proxychain/database"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestMetricsPoolCounts 抓取指标时输出代理池的分布，且不会提前写入批量累积的可信度变化
+func TestMetricsPoolCounts(t *testing.T) {
+	memory := database.NewMemoryStorage()
+	memory.UpsertProxy("10.0.0.1", 8080, "http", "中国", "", "")
+	memory.UpsertProxy("10.0.0.2", 1080, "socks5", "", "", "")
+	memory.PromoteProxy("10.0.0.2", 1080, "socks5")
+	memory.UpsertProxy("10.0.0.3", 8080, "http", "美国", "", "")
+	memory.QuarantineProxy("10.0.0.3", 8080, database.ReasonBanned)
+
+	batched := database.NewBatchedStorage(memory, time.Hour, 1000)
+	defer batched.Close()
+	batched.IncreasePriority("10.0.0.1", 8080, 50)
+
+	handler := newTestAdmin(t, batched)
+	setTestConfig(t, func(cfg *common.Config) { cfg.Metrics.Enabled, cfg.Metrics.Public = true, true })
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("状态码 %d", w.Code)
+	}
+
+	body := w.Body.String()
+	for _, sample := range []string{
+		`proxychain_pool_proxies{tier="standard"} 1`,
+		`proxychain_pool_proxies{tier="premium"} 1`,
+		`proxychain_pool_proxies{tier="quarantined"} 1`,
+		`proxychain_pool_proxies_by_country{country="中国"} 1`,
+		`proxychain_pool_proxies_by_country{country="未知"} 1`,
+		`proxychain_pool_proxies_by_protocol{protocol="socks5"} 1`,
+	} {
+		if !strings.Contains(body, sample) {
+			t.Errorf("指标中没有 %s", sample)
+		}
+	}
+
+	records, err := memory.ExportProxies()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, record := range records {
+		if record.IP == "10.0.0.1" && record.Priority != common.CurrentScoreModel().Neutral {
+			t.Errorf("抓取指标后可信度为 %d，批量累积的变化被提前写入", record.Priority)
+		}
+	}
+}
@@ -0,0 +1,202 @@
+package core
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// 控制台统计的参数
+const (
+	recentRouteSize   = 200 // 保留最近的连接记录数量
+	rateWindowSeconds = 300 // 按秒统计请求数的窗口
+	subscriberBuffer  = 64  // 实时连接记录的订阅缓冲，订阅方处理不过来时丢弃
+)
+
+// routeEvent 一次客户端连接的转发结果
+type routeEvent struct {
+	ID         string    `json:"request_id"`
+	Time       time.Time `json:"time"`
+	Client     string    `json:"client"`
+	Kind       string    `json:"kind"` // http、connect、socks5 或 udp
+	Target     string    `json:"target"`
+	Tier       Tier      `json:"tier"`
+	Proxy      string    `json:"proxy"`
+	Attempts   int       `json:"attempts"`
+	Success    bool      `json:"success"`
+	ErrorClass string    `json:"error_class,omitempty"`
+	LatencyMs  int64     `json:"latency_ms"` // 成功时经代理连接目标的耗时
+	DurationMs int64     `json:"duration_ms"`
+}
+
+// rateBucket 一秒内结束的连接数
+type rateBucket struct {
+	second  int64
+	total   int
+	success int
+}
+
+// proxyOutcome 进程启动以来单个代理每次尝试的结果
+type proxyOutcome struct {
+	Proxy       string    `json:"proxy"`
+	Failures    int       `json:"failures"`
+	Successes   int       `json:"successes"`
+	LastError   string    `json:"last_error,omitempty"`
+	LastFailure time.Time `json:"last_failure"`
+}
+
+// trafficMonitor 在内存中记录最近的连接、每秒的连接数与各代理的失败次数，供控制台展示
+type trafficMonitor struct {
+	mu          sync.Mutex
+	recent      []routeEvent // 环形缓冲区
+	next        int
+	buckets     [rateWindowSeconds]rateBucket
+	proxies     map[string]*proxyOutcome
+	subscribers map[chan routeEvent]struct{}
+}
+
+var monitor = newTrafficMonitor()
+
+func newTrafficMonitor() *trafficMonitor {
+	return &trafficMonitor{
+		recent:      make([]routeEvent, 0, recentRouteSize),
+		proxies:     make(map[string]*proxyOutcome),
+		subscribers: make(map[chan routeEvent]struct{}),
+	}
+}
+
+// recordAttempt 记录一次使用代理的结果，class 为空表示成功
+func (m *trafficMonitor) recordAttempt(proxyURL string, class string) {
+	if proxyURL == "" {
+		return
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	outcome, ok := m.proxies[proxyURL]
+	if !ok {
+		outcome = &proxyOutcome{Proxy: proxyURL}
+		m.proxies[proxyURL] = outcome
+	}
+	if class == "" {
+		outcome.Successes++
+		return
+	}
+	outcome.Failures++
+	outcome.LastError = class
+	outcome.LastFailure = time.Now()
+}
+
+// recordRoute 记录一次连接的最终结果，并推送给订阅方
+func (m *trafficMonitor) recordRoute(event routeEvent) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if len(m.recent) < recentRouteSize {
+		m.recent = append(m.recent, event)
+	} else {
+		m.recent[m.next] = event
+	}
+	m.next = (m.next + 1) % recentRouteSize
+
+	second := event.Time.Unix()
+	bucket := &m.buckets[second%rateWindowSeconds]
+	if bucket.second != second {
+		*bucket = rateBucket{second: second}
+	}
+	bucket.total++
+	if event.Success {
+		bucket.success++
+	}
+
+	for ch := range m.subscribers {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
+
+// subscribe 返回最近的连接记录，并订阅之后结束的连接，返回的函数用于取消订阅
+func (m *trafficMonitor) subscribe() ([]routeEvent, <-chan routeEvent, func()) {
+	ch := make(chan routeEvent, subscriberBuffer)
+
+	m.mu.Lock()
+	m.subscribers[ch] = struct{}{}
+	var recent []routeEvent
+	if len(m.recent) < recentRouteSize {
+		recent = append(recent, m.recent...)
+	} else {
+		recent = append(append(recent, m.recent[m.next:]...), m.recent[:m.next]...)
+	}
+	m.mu.Unlock()
+
+	return recent, ch, func() {
+		m.mu.Lock()
+		delete(m.subscribers, ch)
+		m.mu.Unlock()
+	}
+}
+
+// rateSample 一秒内结束的连接数与成功数
+type rateSample struct {
+	Time    int64 `json:"time"`
+	Total   int   `json:"total"`
+	Success int   `json:"success"`
+}
+
+// rates 返回最近 seconds 秒内每秒的连接数，最早的排在前面，不包括尚未结束的当前这一秒
+func (m *trafficMonitor) rates(seconds int) []rateSample {
+	seconds = min(seconds, rateWindowSeconds-1)
+	now := time.Now().Unix()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	samples := make([]rateSample, 0, seconds)
+	for second := now - int64(seconds); second < now; second++ {
+		sample := rateSample{Time: second}
+		if bucket := m.buckets[second%rateWindowSeconds]; bucket.second == second {
+			sample.Total, sample.Success = bucket.total, bucket.success
+		}
+		samples = append(samples, sample)
+	}
+	return samples
+}
+
+// outcomes 返回全部代理的尝试结果，按代理地址排序
+func (m *trafficMonitor) outcomes() []proxyOutcome {
+	m.mu.Lock()
+	outcomes := make([]proxyOutcome, 0, len(m.proxies))
+	for _, outcome := range m.proxies {
+		outcomes = append(outcomes, *outcome)
+	}
+	m.mu.Unlock()
+
+	sort.Slice(outcomes, func(i, j int) bool { return outcomes[i].Proxy < outcomes[j].Proxy })
+	return outcomes
+}
+
+// topFailing 返回失败次数最多的代理
+func (m *trafficMonitor) topFailing(limit int) []proxyOutcome {
+	m.mu.Lock()
+	outcomes := make([]proxyOutcome, 0, len(m.proxies))
+	for _, outcome := range m.proxies {
+		if outcome.Failures > 0 {
+			outcomes = append(outcomes, *outcome)
+		}
+	}
+	m.mu.Unlock()
+
+	sort.Slice(outcomes, func(i, j int) bool {
+		if outcomes[i].Failures != outcomes[j].Failures {
+			return outcomes[i].Failures > outcomes[j].Failures
+		}
+		return outcomes[i].Proxy < outcomes[j].Proxy
+	})
+	if len(outcomes) > limit {
+		outcomes = outcomes[:limit]
+	}
+	return outcomes
+}
@@ -1,75 +1,129 @@
 package core
 
 import (
-	"log"
-	"net"
+	"context"
+	"fmt"
 	"proxychain/common"
 	"proxychain/database"
 	"proxychain/proxyPool"
 	"proxychain/utils"
-	"sync"
+	"time"
 )
 
-func StartPipeline() {
+// healthChecker 增量检测代理健康状况，定时任务与启动检测共享同一实例
+var healthChecker *proxyPool.Checker
+
+// checkTargetURLs 健康检测依次尝试访问的目标地址
+var checkTargetURLs = []string{"https://www.google.com", "https://www.baidu.com", "http://www.baidu.com", "https://www.yulate.com", "https://www.ip138.com"}
+
+// 批量写入的默认参数
+const (
+	defaultFlushInterval = 1 * time.Second
+	defaultMaxPending    = 500
+)
+
+// StartPipeline 启动代理服务与定时任务，阻塞直到收到退出信号或调用 Shutdown 并退出完成
+// 启动失败时关闭已经启动的部分并返回错误
+func StartPipeline() error {
+	// 输出 --db 覆盖之后生效的配置，敏感配置不会输出
+	serverLog.Info("配置已加载", "path", common.ConfigPath(), "config", fmt.Sprintf("%+v", common.Current().Redacted()))
+
 	// 初始化数据库，检测数据库是否存在
-	dbPath := common.GlobalConfig.Database.Path
-	if !utils.FileExists(dbPath) {
-		log.Printf("数据库文件 %s 不存在，正在创建...\n", dbPath)
+	proxyStorage, err := openStorage()
+	if err != nil {
+		return fmt.Errorf("初始化数据库失败: %w", err)
 	}
 
-	proxyStorage, err := database.NewProxyStorage(dbPath)
-	if err != nil {
-		log.Fatalf("初始化数据库失败: %v", err)
+	// 启用批量写入时，优先级变化与检测历史先在内存中累积
+	writeBehind := common.Current().Database.WriteBehind
+	if writeBehind.Enabled {
+		proxyStorage = database.NewBatchedStorage(proxyStorage,
+			millisecondsOr(writeBehind.FlushInterval, defaultFlushInterval),
+			positiveOr(writeBehind.MaxPending, defaultMaxPending))
 	}
 
-	// 启动定时任务
-	go startScheduledTasks(proxyStorage)
+	// 收到退出信号时停止接受连接，等待活动连接结束后写入剩余的变化并关闭数据库
+	srv := newServer(proxyStorage)
+	proxyServer = srv
+	go shutdownOnSignal(srv)
 
-	// 去除数据库中优先级低于0的ip
-	err = proxyStorage.DeleteLowPriorityProxies()
-	if err != nil {
-		log.Printf("删除优先级低于0的代理失败: %v\n", err)
-	} else {
-		log.Println("成功删除优先级低于0的代理IP。")
+	healthChecker = proxyPool.NewChecker()
+	quarantineChecker = newQuarantineChecker()
+
+	// 读取用户当天已有的用量，并定时写入新的用量
+	if err := usage.load(proxyStorage); err != nil {
+		serverLog.Error("读取用户用量失败", "error", err)
 	}
+	srv.goTask(func() { flushUsage(proxyStorage, srv.stopping) })
+
+	// 启动定时任务
+	srv.goTask(func() { startScheduledTasks(srv.ctx, proxyStorage) })
 
-	// 并行执行耗时任务
-	var wg sync.WaitGroup
+	// 收到 SIGHUP 或配置文件被修改时重新加载配置
+	srv.goTask(func() { watchConfig(proxyStorage, srv.stopping) })
 
-	// 启动并行任务
-	wg.Add(2)
+	// 将数据库中优先级降到下限的ip移入隔离区
+	quarantineLowPriorityProxies(proxyStorage)
 
-	go func() {
-		defer wg.Done()
-		// 检测数据库是否有数据，如果有代理ip则进行检测，根据有效性更新优先级
-		checkAndUpdateProxies(proxyStorage)
-	}()
+	// 检测数据库是否有数据，如果有代理ip则进行检测，根据有效性更新优先级
+	srv.goTask(func() { checkAndUpdateProxies(srv.ctx, proxyStorage) })
 
-	//go func() {
-	//	defer wg.Done()
+	//srv.goTask(func() {
 	//	// 从 fofa 和 hunter 提取新的代理池数据
 	//	proxyPool.GetProxyBase(proxyStorage)
-	//}()
+	//})
 
-	// 立即加载代理，准备服务
-	loadProxies(proxyStorage)
+	// 立即加载代理，准备服务，然后启动本地代理，开放端口允许访问
+	if err := startServing(srv, proxyStorage); err != nil {
+		serverLog.Error("启动失败，开始退出", "error", err)
+		ctx, cancel := context.WithTimeout(context.Background(), secondsOr(common.Current().Server.ShutdownTimeout, defaultShutdownTimeout))
+		defer cancel()
+		if shutdownErr := srv.shutdown(ctx); shutdownErr != nil {
+			serverLog.Error("退出时出现问题", "error", shutdownErr)
+		}
+		return err
+	}
 
-	// 启动本地代理，开放端口允许访问
-	startProxy()
+	// 等待退出完成
+	<-srv.done
+	serverLog.Info("代理服务已退出")
+	return nil
+}
 
-	// 等待所有耗时任务完成
-	wg.Wait()
+// startServing 加载当前使用的代理并启动代理端口与管理接口
+func startServing(srv *server, ps database.Storage) error {
+	if err := loadProxies(ps); err != nil {
+		return err
+	}
+	if err := startProxy(srv); err != nil {
+		return err
+	}
+	return startAdmin(srv, ps)
 }
 
-// checkAndUpdateProxies 检测更新代理有效性
-func checkAndUpdateProxies(ps *database.ProxyStorage) {
+// openStorage 按配置打开代理存储
+func openStorage() (database.Storage, error) {
+	dbType := common.Current().Database.Type
+	dataSource := common.Current().Database.Path
+	if dbType == database.TypePostgres {
+		dataSource = common.Current().Database.DSN
+	} else if dbType != database.TypeMemory && !utils.FileExists(dataSource) {
+		common.Logger(common.LogStorage).Info("数据库文件不存在，正在创建", "path", dataSource)
+	}
+
+	return database.Open(dbType, dataSource)
+}
+
+// checkAndUpdateProxies 检测到期代理的有效性并更新优先级，上一轮检测未结束时跳过，ctx 取消后只应用已完成的结果
+func checkAndUpdateProxies(ctx context.Context, ps database.Storage) {
 	proxies, err := ps.GetActiveProxiesByPriority()
 	if err != nil {
-		log.Fatalf("获取代理失败: %v", err)
+		checkerLog.Error("获取代理失败", "error", err)
+		return
 	}
 
 	if len(proxies) == 0 {
-		log.Println("数据库中没有可用的代理IP。")
+		checkerLog.Warn("数据库中没有可用的代理")
 		return
 	}
 
@@ -79,53 +133,124 @@ func checkAndUpdateProxies(ps *database.ProxyStorage) {
 		proxyURLs = append(proxyURLs, proxy.URL)
 	}
 
-	// 执行并发检测
-	targetURLs := []string{"https://www.google.com", "https://www.baidu.com", "http://www.baidu.com", "https://www.yulate.com", "https://www.ip138.com"}
-	results := proxyPool.CheckProxy(proxyURLs, targetURLs)
+	// 执行增量检测
+	results, ok := healthChecker.CheckDue(ctx, proxyURLs, checkTargetURLs)
+	if !ok {
+		checkerLog.Warn("上一轮代理检测仍在进行，跳过本次检测")
+		return
+	}
+
+	applyCheckResults(ps, results)
+}
 
-	// 根据检测结果更新代理优先级
+// applyCheckResults 根据检测结果按健康检测的权重更新代理优先级，并记录检测历史
+func applyCheckResults(ps database.Storage, results []proxyPool.ProxyCheckResult) {
+	recordHealthChecks("active", results)
+
+	var passed int
+	model := common.CurrentScoreModel()
 	for _, result := range results {
+		ip, port, err := common.ExtractIPAndPort(result.ProxyAddr)
+		if err != nil {
+			checkerLog.Error("解析代理地址失败", "proxy", result.ProxyAddr, "error", err)
+			continue
+		}
+
+		record := database.CheckRecord{
+			CheckedAt: time.Now(),
+			IP:        ip,
+			Port:      port,
+			Source:    database.SourceCheck,
+			Target:    result.SuccessURL,
+			Success:   result.Success,
+			Latency:   result.Latency,
+		}
+
 		if result.Success {
-			log.Printf("代理 %s 可用，成功访问: %s\n", result.ProxyAddr, result.SuccessURL)
-			ip, port, err := common.ExtractIPAndPort(result.ProxyAddr)
+			passed++
+			checkerLog.Debug("代理可用", "proxy", result.ProxyAddr, "url", result.SuccessURL, "latency", result.Latency)
+			err = ps.IncreasePriority(ip, port, model.Weighted(common.Current().Config.PriorityUpNum, false))
 			if err != nil {
-				log.Printf("解析代理地址失败: %v\n", err)
-				continue
-			}
-			err = ps.IncreasePriority(ip, port)
-			if err != nil {
-				log.Printf("增加代理 %s 优先级失败: %v\n", result.ProxyAddr, err)
+				checkerLog.Error("增加代理优先级失败", "proxy", result.ProxyAddr, "error", err)
 			}
 		} else {
-			log.Printf("代理 %s 不可用，降低优先级。\n", result.ProxyAddr)
-			ip, port, err := common.ExtractIPAndPort(result.ProxyAddr)
+			class := common.ClassifyError(result.Error)
+			checkerLog.Debug("代理不可用，降低优先级", "proxy", result.ProxyAddr, "error_class", class, "error", result.Error)
+			record.ErrorClass = string(class)
+			err = ps.DecreasePriority(ip, port, model.Weighted(class.Penalty(), false))
 			if err != nil {
-				log.Printf("解析代理地址失败: %v\n", err)
-				continue
+				checkerLog.Error("降低代理优先级失败", "proxy", result.ProxyAddr, "error", err)
 			}
-			err = ps.DecreasePriority(ip, port)
-			if err != nil {
-				log.Printf("降低代理 %s 优先级失败: %v\n", result.ProxyAddr, err)
+		}
+
+		if result.Probed {
+			if result.Throughput != nil {
+				checkerLog.Info("吞吐量探测完成", "proxy", result.ProxyAddr, "kbps", *result.Throughput/1024)
+			} else {
+				checkerLog.Info("吞吐量探测失败，带宽记为未知", "proxy", result.ProxyAddr)
+			}
+			if err := ps.UpdateThroughput(ip, port, result.Throughput); err != nil {
+				checkerLog.Error("更新代理带宽失败", "proxy", result.ProxyAddr, "error", err)
 			}
 		}
+
+		if result.UDPChecked {
+			if err := ps.UpdateUDPSupport(ip, port, result.UDP); err != nil {
+				checkerLog.Error("更新代理 UDP 能力失败", "proxy", result.ProxyAddr, "error", err)
+			}
+		}
+
+		if err = ps.RecordCheck(record); err != nil {
+			checkerLog.Error("记录检测历史失败", "proxy", result.ProxyAddr, "error", err)
+		}
+	}
+
+	if len(results) > 0 {
+		checkerLog.Info("健康检测完成", "checked", len(results), "passed", passed, "failed", len(results)-passed)
 	}
 }
 
-func startProxy() {
-	listener, err := net.Listen("tcp", common.GlobalConfig.Server.Host+":"+common.GlobalConfig.Server.Port)
-	if err != nil {
-		log.Fatal("启动服务器失败:", err)
+// millisecondsOr 将以毫秒为单位的配置转换为时间间隔，未配置时返回默认值
+func millisecondsOr(ms int, def time.Duration) time.Duration {
+	if ms <= 0 {
+		return def
 	}
-	defer listener.Close()
+	return time.Duration(ms) * time.Millisecond
+}
 
-	log.Println("代理服务器运行在" + common.GlobalConfig.Server.Host + ":" + common.GlobalConfig.Server.Port)
+// secondsOr 将以秒为单位的配置转换为时间间隔，未配置时返回默认值
+func secondsOr(seconds int, def time.Duration) time.Duration {
+	if seconds <= 0 {
+		return def
+	}
+	return time.Duration(seconds) * time.Second
+}
 
-	for {
-		conn, err := listener.Accept()
-		if err != nil {
-			log.Println("接受连接失败:", err)
-			continue
+// positiveOr 当 v 不大于 0 时返回默认值
+func positiveOr(v, def int) int {
+	if v <= 0 {
+		return def
+	}
+	return v
+}
+
+// startProxy 启动本地代理的监听端口
+func startProxy(srv *server) error {
+	cfg := common.Current()
+
+	// 高级代理池的专用端口，连接到该端口的客户端都使用高级代理
+	if cfg.Premium.Enabled && cfg.Premium.Port != "" {
+		address := cfg.Server.Host + ":" + cfg.Premium.Port
+		if err := srv.listen(address, TierPremium); err != nil {
+			return fmt.Errorf("启动高级代理服务器失败: %w", err)
 		}
-		go HandleConnection(conn)
+		serverLog.Info("高级代理服务器已启动", "address", address)
+	}
+
+	address := cfg.Server.Host + ":" + cfg.Server.Port
+	if err := srv.listen(address, TierStandard); err != nil {
+		return fmt.Errorf("启动服务器失败: %w", err)
 	}
+	serverLog.Info("代理服务器已启动", "address", address)
+	return nil
 }
@@ -0,0 +1,126 @@
+package core
+
+import (
+	"context"
+	"proxychain/common"
+	"proxychain/database"
+	"proxychain/proxyPool"
+	"time"
+)
+
+// 隔离区的默认参数，配置缺省时使用
+const (
+	defaultQuarantineRetention = 7 * 24 * time.Hour
+	defaultRecheckInterval     = 1 * time.Hour
+	defaultRestorePriority     = 50
+)
+
+// quarantineChecker 按较长的间隔复检隔离区中的代理
+var quarantineChecker *proxyPool.Checker
+
+// newQuarantineChecker 根据配置创建隔离区检测器
+func newQuarantineChecker() *proxyPool.Checker {
+	interval := time.Duration(common.Current().Quarantine.RecheckInterval) * time.Second
+	if interval <= 0 {
+		interval = defaultRecheckInterval
+	}
+	return proxyPool.NewQuarantineChecker(interval)
+}
+
+// quarantineLowPriorityProxies 将可信度降到下限的代理移入隔离区
+func quarantineLowPriorityProxies(ps database.Storage) {
+	quarantined, err := ps.QuarantineLowPriorityProxies()
+	if err != nil {
+		serverLog.Error("隔离可信度降到下限的代理失败", "error", err)
+		return
+	}
+	if quarantined > 0 {
+		serverLog.Info("已将可信度降到下限的代理移入隔离区", "count", quarantined)
+	}
+}
+
+// recheckQuarantinedProxies 复检隔离区中到期的代理，检测通过的代理恢复使用
+func recheckQuarantinedProxies(ctx context.Context, ps database.Storage) {
+	quarantined, err := ps.GetQuarantinedProxies()
+	if err != nil {
+		checkerLog.Error("获取隔离区代理失败", "error", err)
+		return
+	}
+	if len(quarantined) == 0 {
+		return
+	}
+
+	// 手动封禁的代理不复检
+	var proxyURLs []string
+	for _, proxy := range quarantined {
+		if proxy.Reason != database.ReasonBanned {
+			proxyURLs = append(proxyURLs, proxy.URL)
+		}
+	}
+
+	results, ok := quarantineChecker.CheckDue(ctx, proxyURLs, checkTargetURLs)
+	if !ok {
+		checkerLog.Warn("上一轮隔离区复检仍在进行，跳过本次复检")
+		return
+	}
+
+	restorePriority := common.Current().Quarantine.RestorePriority
+	if restorePriority <= 0 {
+		restorePriority = defaultRestorePriority
+	}
+
+	recordHealthChecks("quarantine", results)
+
+	var restored int
+	for _, result := range results {
+		ip, port, err := common.ExtractIPAndPort(result.ProxyAddr)
+		if err != nil {
+			checkerLog.Error("解析代理地址失败", "proxy", result.ProxyAddr, "error", err)
+			continue
+		}
+
+		record := database.CheckRecord{
+			CheckedAt: time.Now(),
+			IP:        ip,
+			Port:      port,
+			Source:    database.SourceCheck,
+			Target:    result.SuccessURL,
+			Success:   result.Success,
+			Latency:   result.Latency,
+		}
+
+		if result.Success {
+			if _, err := ps.RestoreProxy(ip, port, restorePriority); err != nil {
+				checkerLog.Error("恢复代理失败", "proxy", result.ProxyAddr, "error", err)
+			} else {
+				checkerLog.Info("隔离区代理复检通过，恢复使用", "proxy", result.ProxyAddr)
+				restored++
+			}
+		} else {
+			record.ErrorClass = string(common.ClassifyError(result.Error))
+		}
+
+		if err := ps.RecordCheck(record); err != nil {
+			checkerLog.Error("记录检测历史失败", "proxy", result.ProxyAddr, "error", err)
+		}
+	}
+
+	checkerLog.Info("隔离区复检完成", "checked", len(results), "restored", restored, "quarantined", len(quarantined)-restored)
+}
+
+// purgeQuarantinedProxies 永久删除在隔离区中超过保留时长的代理
+func purgeQuarantinedProxies(ps database.Storage) {
+	retention := time.Duration(common.Current().Quarantine.Retention) * time.Hour
+	if retention <= 0 {
+		retention = defaultQuarantineRetention
+	}
+
+	deleted, err := ps.DeleteQuarantinedProxies(time.Now().Add(-retention))
+	if err != nil {
+		taskLog.Error("删除过期的隔离代理失败", "error", err)
+		return
+	}
+	if deleted > 0 {
+		taskLog.Info("永久删除隔离过久的代理", "retention", retention, "count", deleted)
+	}
+}
@@ -0,0 +1,86 @@
+package core
+
+import (
+	"os"
+	"os/signal"
+	"proxychain/common"
+	"proxychain/database"
+	"syscall"
+	"time"
+)
+
+// configPollInterval 检查配置文件是否被修改的间隔
+const configPollInterval = 5 * time.Second
+
+// watchConfig 收到 SIGHUP 或配置文件被修改时重新加载配置，stop 关闭后返回
+func watchConfig(ps database.Storage, stop <-chan struct{}) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	defer signal.Stop(signals)
+
+	ticker := time.NewTicker(configPollInterval)
+	defer ticker.Stop()
+
+	path := common.ConfigPath()
+	modTime := configModTime(path)
+	for {
+		select {
+		case <-stop:
+			return
+		case <-signals:
+			serverLog.Info("收到 SIGHUP，重新加载配置")
+		case <-ticker.C:
+			// 编辑器保存文件时可能短暂删除文件，读取不到修改时间时等待下一次检查
+			latest := configModTime(path)
+			if latest.IsZero() || latest.Equal(modTime) {
+				continue
+			}
+			serverLog.Info("配置文件已修改，重新加载配置", "path", path)
+		}
+
+		modTime = configModTime(path)
+		reloadConfig(ps)
+	}
+}
+
+// reloadConfig 重新加载配置并输出变更，新配置不合法时继续使用当前配置
+func reloadConfig(ps database.Storage) {
+	previous := common.CurrentScoreModel()
+	changes, ignored, err := common.ReloadConfig()
+	if err != nil {
+		serverLog.Error("重新加载配置失败，继续使用当前配置", "error", err)
+		return
+	}
+
+	for _, field := range ignored {
+		serverLog.Warn("配置项需要重启才能生效，本次修改已忽略", "field", field)
+	}
+	if len(changes) == 0 {
+		serverLog.Info("配置没有需要应用的变更")
+		return
+	}
+	for _, change := range changes {
+		serverLog.Info("配置变更", "change", change)
+	}
+
+	// 评分区间缩小后已有代理的可信度可能超出新的区间，限制在区间内后再重新选择代理
+	if model := common.CurrentScoreModel(); model.Min != previous.Min || model.Max != previous.Max {
+		if _, err := ps.ClampPriorities(); err != nil {
+			serverLog.Error("将可信度限制在新的评分区间内失败", "error", err)
+		}
+	}
+
+	// 按新的配置重新选择当前使用的代理
+	if err := loadProxies(ps); err != nil {
+		serverLog.Error("重新加载代理列表失败", "error", err)
+	}
+}
+
+// configModTime 返回配置文件的修改时间，文件不存在时返回零值
+func configModTime(path string) time.Time {
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
@@ -0,0 +1,48 @@
+package core
+
+import (
+	"os"
+	"path/filepath"
+	"proxychain/common"
+	"proxychain/database"
+	"testing"
+)
+
+// TestReloadConfigClampsPriorities 热加载缩小评分区间后，已有代理的可信度被限制在新的区间内
+func TestReloadConfigClampsPriorities(t *testing.T) {
+	setTestConfig(t, func(cfg *common.Config) {})
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	writeConfig := func(content string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeConfig("score:\n  min: 0\n  max: 200\n  neutral: 100\n")
+	common.LoadConfig(path)
+
+	ms := database.NewMemoryStorage()
+	for ip, delta := range map[string]int{"10.2.0.1": 100, "10.2.0.2": -100, "10.2.0.3": 20} {
+		if err := ms.UpsertProxy(ip, 8080, "http", "中国", "", ""); err != nil {
+			t.Fatal(err)
+		}
+		if err := ms.IncreasePriority(ip, 8080, delta); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	writeConfig("score:\n  min: 20\n  max: 150\n  neutral: 100\n")
+	reloadConfig(ms)
+
+	records, err := ms.ExportProxies()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]int{"10.2.0.1": 150, "10.2.0.2": 20, "10.2.0.3": 120}
+	for _, record := range records {
+		if record.Priority != want[record.IP] {
+			t.Errorf("%s 的优先级为 %d，期望 %d", record.IP, record.Priority, want[record.IP])
+		}
+	}
+}
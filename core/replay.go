@@ -0,0 +1,150 @@
+package core
+
+import (
+	"fmt"
+	"proxychain/common"
+	"proxychain/database"
+	"sort"
+	"time"
+)
+
+// replayState 回放过程中单个代理的评分状态
+type replayState struct {
+	ip        string
+	port      int
+	score     float64
+	lowest    float64
+	last      time.Time
+	events    int
+	successes int
+	floorHits int // 分数降到下限的次数，即实际运行中会被移入隔离区的次数
+}
+
+// RunReplay 使用指定的评分参数回放检测历史，输出每个代理的分数变化，用于比较不同参数的效果
+// 回放使用连续的衰减计算，实际运行时可信度为整数并按固定间隔衰减，结果会略有差异
+func RunReplay(args []string) error {
+	model := common.CurrentScoreModel()
+	retention := common.Current().History.Retention
+	if retention <= 0 {
+		retention = int(defaultHistoryRetention / time.Hour)
+	}
+
+	flags := newFlagSet("replay")
+	flags.IntVar(&model.Min, "min", model.Min, "可信度下限")
+	flags.IntVar(&model.Max, "max", model.Max, "可信度上限")
+	flags.IntVar(&model.Neutral, "neutral", model.Neutral, "中性值")
+	flags.DurationVar(&model.HalfLife, "half-life", model.HalfLife, "衰减半衰期")
+	flags.Float64Var(&model.CheckWeight, "check-weight", model.CheckWeight, "健康检测结果的权重")
+	flags.Float64Var(&model.TrafficWeight, "traffic-weight", model.TrafficWeight, "实际流量结果的权重")
+	hours := flags.Int("hours", retention, "回放最近多少小时的历史")
+	top := flags.Int("top", 20, "输出分数最低的代理数量，0 表示全部")
+	proxyAddr := flags.String("proxy", "", "输出指定代理（ip:port）每一步的分数变化")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if model.Min >= model.Max || model.Neutral <= model.Min || model.Neutral >= model.Max {
+		return fmt.Errorf("评分区间不合法: min=%d neutral=%d max=%d", model.Min, model.Neutral, model.Max)
+	}
+	if model.HalfLife <= 0 {
+		return fmt.Errorf("半衰期必须大于0")
+	}
+
+	ps, err := openStorage()
+	if err != nil {
+		return fmt.Errorf("打开数据库失败: %w", err)
+	}
+	defer ps.Close()
+
+	records, err := ps.GetHistory(time.Now().Add(-time.Duration(*hours) * time.Hour))
+	if err != nil {
+		return fmt.Errorf("读取检测历史失败: %w", err)
+	}
+
+	fmt.Printf("回放 %d 条检测历史，区间 [%d, %d]，中性值 %d，半衰期 %v，检测权重 %.2f，流量权重 %.2f\n",
+		len(records), model.Min, model.Max, model.Neutral, model.HalfLife, model.CheckWeight, model.TrafficWeight)
+
+	states := replayHistory(records, model, *proxyAddr)
+	printReplaySummary(states, model, *top)
+	return nil
+}
+
+// replayHistory 按时间顺序回放检测历史，返回每个代理最终的评分状态
+// trace 不为空时输出该代理每一步的分数
+func replayHistory(records []database.CheckRecord, model common.ScoreModel, trace string) []*replayState {
+	reward := common.Current().Config.PriorityUpNum
+
+	index := make(map[string]*replayState)
+	var states []*replayState
+	for _, record := range records {
+		key := fmt.Sprintf("%s:%d", record.IP, record.Port)
+		state, ok := index[key]
+		if !ok {
+			neutral := float64(model.Neutral)
+			state = &replayState{ip: record.IP, port: record.Port, score: neutral, lowest: neutral, last: record.CheckedAt}
+			index[key] = state
+			states = append(states, state)
+		}
+
+		// 与实际运行一致，客户端错误不影响分数
+		class := common.ErrorClass(record.ErrorClass)
+		if !record.Success && class == common.ErrorClassClient {
+			continue
+		}
+
+		traffic := record.Source == database.SourceTraffic
+		delta := model.Weighted(reward, traffic)
+		if !record.Success {
+			delta = -model.Weighted(class.Penalty(), traffic)
+		}
+
+		state.score = model.Clamp(model.Decay(state.score, record.CheckedAt.Sub(state.last)) + float64(delta))
+		state.last = record.CheckedAt
+		state.events++
+		if record.Success {
+			state.successes++
+		}
+		if state.score < state.lowest {
+			state.lowest = state.score
+		}
+		if state.score <= float64(model.Min) {
+			state.floorHits++
+		}
+
+		if key == trace {
+			result := "成功"
+			if !record.Success {
+				result = "失败 " + record.ErrorClass
+			}
+			fmt.Printf("%s  %-7s  %-24s  %+4d  -> %.1f\n",
+				record.CheckedAt.Local().Format("2006-01-02 15:04:05"), record.Source, result, delta, state.score)
+		}
+	}
+
+	return states
+}
+
+// printReplaySummary 输出回放结果，分数最低的代理排在前面
+func printReplaySummary(states []*replayState, model common.ScoreModel, top int) {
+	sort.Slice(states, func(i, j int) bool {
+		return states[i].score < states[j].score
+	})
+
+	var quarantined int
+	for _, state := range states {
+		if state.floorHits > 0 {
+			quarantined++
+		}
+	}
+	fmt.Printf("共 %d 个代理，其中 %d 个会降到下限 %d 被移入隔离区\n", len(states), quarantined, model.Min)
+
+	fmt.Printf("%-22s %8s %8s %8s %8s %8s\n", "代理", "最终分数", "最低分数", "记录数", "可用率", "降到下限")
+	for i, state := range states {
+		if top > 0 && i >= top {
+			break
+		}
+		uptime := float64(state.successes) * 100 / float64(max(state.events, 1))
+		fmt.Printf("%-22s %8.1f %8.1f %8d %7.1f%% %8d\n",
+			fmt.Sprintf("%s:%d", state.ip, state.port), state.score, state.lowest, state.events, uptime, state.floorHits)
+	}
+}
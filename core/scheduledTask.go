@@ -1,7 +1,7 @@
 package core
 
 import (
-	"log"
+	"context"
 	"proxychain/common"
 	"proxychain/database"
 	"proxychain/proxyPool"
@@ -13,54 +13,191 @@ var (
 	minProxyCount = 50              // 数据库中最少代理数量的阈值
 )
 
-// startScheduledTasks 启动定时任务
-func startScheduledTasks(ps *database.ProxyStorage) {
+const (
+	// decaySteps 每个半衰期内衰减的次数，可信度为整数，过于频繁的衰减会因取整而失真
+	decaySteps = 10
 
-	checkInterval = time.Duration(common.GlobalConfig.Config.TaskTime) * time.Second
-	minProxyCount = common.GlobalConfig.Config.MiniProxyCount
+	defaultHistoryRetention = 7 * 24 * time.Hour // 检测历史默认保留时长
+	defaultUptimeWindow     = 24 * time.Hour     // 可用率默认统计窗口
+	uptimeSummarySize       = 5                  // 每次输出可用率最低的代理数量
+)
+
+// startScheduledTasks 启动定时任务，ctx 取消后中止进行中的检测与获取代理，并跳过本轮剩余的任务
+func startScheduledTasks(ctx context.Context, ps database.Storage) {
+
+	checkInterval = time.Duration(common.Current().Config.TaskTime) * time.Second
 
 	ticker := time.NewTicker(checkInterval)
 	defer ticker.Stop()
 
 	for {
 		select {
+		case <-ctx.Done():
+			return
 		case <-ticker.C:
-			log.Println("执行定时任务...")
+			taskLog.Info("执行定时任务")
+
+			// 每轮读取最新的配置，重新加载配置后无需重启
+			minProxyCount = common.Current().Config.MiniProxyCount
 
 			//loadProxies(ps)
 
-			// 删除可信度 < 0 的代理
-			err := ps.DeleteLowPriorityProxies()
-			if err != nil {
-				log.Printf("定时任务 - 删除可信度低于0的代理失败: %v\n", err)
-			} else {
-				log.Println("定时任务 - 成功删除可信度低于0的代理IP。")
-			}
+			// 可信度向中性值衰减
+			decayPriorities(ps)
+
+			// 将可信度降到下限的代理移入隔离区，并永久删除隔离过久的代理
+			quarantineLowPriorityProxies(ps)
+			purgeQuarantinedProxies(ps)
 
 			// 检查代理可用性并更新优先级
-			checkAndUpdateProxies(ps)
+			checkAndUpdateProxies(ctx, ps)
+
+			// 复检隔离区中的代理，恢复重新可用的代理
+			recheckQuarantinedProxies(ctx, ps)
+			if ctx.Err() != nil {
+				return
+			}
+
+			// 根据可信度与可用率调整高级代理池
+			updateTiers(ps)
 
 			// 检查数据库中的代理数量
 			proxyCount, err := ps.GetProxyCount()
 			if err != nil {
-				log.Printf("定时任务 - 获取代理数量失败: %v\n", err)
+				taskLog.Error("获取代理数量失败", "error", err)
 			} else {
-				log.Printf("定时任务 - 当前代理数量: %d\n", proxyCount)
+				taskLog.Info("当前代理数量", "count", proxyCount)
 				if proxyCount < minProxyCount {
-					log.Println("定时任务 - 代理数量不足，开始获取新的代理...")
-					proxyPool.GetProxyBase(ps)
+					taskLog.Warn("代理数量不足，开始获取新的代理", "count", proxyCount, "min", minProxyCount)
+					proxyPool.GetProxyBase(ctx, ps)
 				}
 			}
 
 			//统计中国和非中国代理的数量
 			chinaCount, nonChinaCount, err := ps.GetCountryStatistics()
 			if err != nil {
-				log.Printf("定时任务 - 获取国家统计信息失败: %v\n", err)
+				taskLog.Error("获取国家统计信息失败", "error", err)
 			} else {
-				log.Printf("定时任务 - 中国代理数量: %d, 非中国代理数量: %d\n", chinaCount, nonChinaCount)
+				taskLog.Info("代理国家统计", "china", chinaCount, "non_china", nonChinaCount)
 			}
 
-			loadProxies(ps)
+			// 统计普通代理与高级代理的数量
+			logTierStatistics(ps, proxyCount)
+
+			// 清理过期的检测历史，并输出可用率最低的代理
+			pruneHistory(ps)
+			logUptimeSummary(ps)
+
+			if err := loadProxies(ps); err != nil {
+				taskLog.Error("重新加载代理列表失败", "error", err)
+			}
+
+			// 定时任务的间隔被修改时重置计时器
+			if interval := time.Duration(common.Current().Config.TaskTime) * time.Second; interval != checkInterval {
+				taskLog.Info("定时任务间隔已调整", "interval", interval)
+				checkInterval = interval
+				ticker.Reset(interval)
+			}
+		}
+	}
+}
+
+// decayPriorities 按评分模型的半衰期将可信度向中性值衰减
+// 上一次衰减的时间保存在数据库中，重启不会重新计时，停机期间经过的时间同样计入衰减
+func decayPriorities(ps database.Storage) {
+	lastDecay, err := ps.GetLastDecay()
+	if err != nil {
+		taskLog.Error("获取上一次衰减可信度的时间失败", "error", err)
+		return
+	}
+
+	// 新数据库与升级前的数据库没有记录，从现在开始计时
+	now := time.Now()
+	if lastDecay.IsZero() {
+		if err := ps.SetLastDecay(now); err != nil {
+			taskLog.Error("记录衰减可信度的时间失败", "error", err)
+		}
+		return
+	}
+
+	model := common.CurrentScoreModel()
+	elapsed := now.Sub(lastDecay)
+	if elapsed < model.HalfLife/decaySteps {
+		return
+	}
+
+	affected, err := ps.DecayPriorities(model.DecayFactor(elapsed))
+	if err != nil {
+		taskLog.Error("衰减代理可信度失败", "error", err)
+		return
+	}
+	if err := ps.SetLastDecay(now); err != nil {
+		taskLog.Error("记录衰减可信度的时间失败", "error", err)
+	}
+	taskLog.Info("代理可信度向中性值衰减", "count", affected, "neutral", model.Neutral)
+}
+
+// pruneHistory 删除超出保留时长的检测历史
+func pruneHistory(ps database.Storage) {
+	retention := time.Duration(common.Current().History.Retention) * time.Hour
+	if retention <= 0 {
+		retention = defaultHistoryRetention
+	}
+
+	deleted, err := ps.PruneHistory(time.Now().Add(-retention))
+	if err != nil {
+		taskLog.Error("清理检测历史失败", "error", err)
+		return
+	}
+	if deleted > 0 {
+		taskLog.Info("清理过期检测历史", "count", deleted)
+	}
+}
+
+// uptimeWindow 返回统计可用率的时间窗口
+func uptimeWindow() time.Duration {
+	window := time.Duration(common.Current().History.UptimeWindow) * time.Hour
+	if window <= 0 {
+		return defaultUptimeWindow
+	}
+	return window
+}
+
+// logTierStatistics 输出普通代理与高级代理的数量，total 为代理总数
+func logTierStatistics(ps database.Storage, total int) {
+	if !common.Current().Premium.Enabled {
+		return
+	}
+
+	premiumCount, err := ps.GetPremiumCount()
+	if err != nil {
+		taskLog.Error("获取高级代理数量失败", "error", err)
+		return
+	}
+	taskLog.Info("代理层级统计", "standard", total-premiumCount, "premium", premiumCount)
+}
+
+// logUptimeSummary 输出统计窗口内可用率最低的几个代理及其失败原因
+func logUptimeSummary(ps database.Storage) {
+	window := uptimeWindow()
+	since := time.Now().Add(-window)
+
+	stats, err := ps.GetUptimeStats(since)
+	if err != nil {
+		taskLog.Error("获取代理可用率失败", "error", err)
+		return
+	}
+
+	for i, stat := range stats {
+		if i >= uptimeSummarySize {
+			break
+		}
+		breakdown, err := ps.GetFailureBreakdown(stat.IP, stat.Port, since)
+		if err != nil {
+			taskLog.Error("获取代理失败原因失败", "ip", stat.IP, "port", stat.Port, "error", err)
+			continue
 		}
+		taskLog.Info("可用率较低的代理", "ip", stat.IP, "port", stat.Port, "window", window,
+			"uptime", stat.Uptime, "successes", stat.Successes, "total", stat.Total, "failures", breakdown)
 	}
 }
@@ -0,0 +1,57 @@
+package core
+
+import (
+	"proxychain/common"
+	"proxychain/database"
+	"testing"
+	"time"
+)
+
+// TestDecayPrioritiesUsesStoredClock 衰减按数据库中记录的上一次衰减时间计算，重启后不会重新计时
+func TestDecayPrioritiesUsesStoredClock(t *testing.T) {
+	setTestConfig(t, func(cfg *common.Config) {
+		cfg.Score.Min, cfg.Score.Max, cfg.Score.Neutral, cfg.Score.HalfLife = 0, 200, 100, 24
+	})
+
+	ms := database.NewMemoryStorage()
+	if err := ms.UpsertProxy("10.3.0.1", 8080, "http", "中国", "", ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := ms.IncreasePriority("10.3.0.1", 8080, 80); err != nil {
+		t.Fatal(err)
+	}
+	priority := func() int {
+		t.Helper()
+		records, err := ms.ExportProxies()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return records[0].Priority
+	}
+
+	// 没有记录时只开始计时，不衰减
+	decayPriorities(ms)
+	started, _ := ms.GetLastDecay()
+	if started.IsZero() || priority() != 180 {
+		t.Fatalf("首次运行后上一次衰减的时间为 %v，可信度为 %d", started, priority())
+	}
+
+	// 不足半衰期的十分之一时不衰减
+	decayPriorities(ms)
+	if priority() != 180 {
+		t.Fatalf("未到衰减间隔时可信度变为 %d", priority())
+	}
+
+	// 上一次衰减在一个半衰期之前，例如停机了一天，偏离中性值的部分减半
+	// 少记一秒，避免测试运行的耗时使衰减略多于一半后向零取整
+	if err := ms.SetLastDecay(time.Now().Add(-24*time.Hour + time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	decayPriorities(ms)
+	if priority() != 140 {
+		t.Errorf("经过一个半衰期后可信度为 %d，期望 140", priority())
+	}
+	if lastDecay, _ := ms.GetLastDecay(); time.Since(lastDecay) > time.Minute {
+		t.Errorf("衰减后没有更新上一次衰减的时间: %v", lastDecay)
+	}
+}
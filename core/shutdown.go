@@ -0,0 +1,293 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"proxychain/common"
+	"proxychain/database"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// 优雅退出的默认参数
+const (
+	defaultShutdownTimeout = 30 * time.Second
+	forceCloseWait         = 5 * time.Second  // 强制关闭连接后等待处理协程写入结果的时间
+	taskShutdownWait       = 30 * time.Second // 连接结束后等待后台任务中止的时间，与连接的期限分开计算
+)
+
+// proxyServer 正在运行的代理服务，Shutdown 通过它退出
+var proxyServer *server
+
+// server 记录代理服务的监听器、活动连接与后台任务，用于优雅退出
+type server struct {
+	storage   database.Storage
+	startedAt time.Time
+
+	mu          sync.Mutex
+	listeners   []net.Listener
+	httpServers []*http.Server // 管理接口等 HTTP 服务
+	conns       map[net.Conn]struct{}
+
+	connWG   sync.WaitGroup     // 活动的客户端连接
+	taskWG   sync.WaitGroup     // 定时任务等后台任务
+	ctx      context.Context    // 开始退出时取消，进行中的检测与获取代理随之中止
+	stop     context.CancelFunc // 取消 ctx
+	stopping <-chan struct{}    // ctx.Done()，开始退出时关闭，后台任务随之结束
+	done     chan struct{}      // 退出完成后关闭
+	once     sync.Once
+	err      error
+}
+
+func newServer(ps database.Storage) *server {
+	ctx, stop := context.WithCancel(context.Background())
+	return &server{
+		storage:   ps,
+		startedAt: time.Now(),
+		conns:     make(map[net.Conn]struct{}),
+		ctx:       ctx,
+		stop:      stop,
+		stopping:  ctx.Done(),
+		done:      make(chan struct{}),
+	}
+}
+
+// serverContext 返回代理服务退出时取消的 context，代理服务没有运行时返回 context.Background()
+func serverContext() context.Context {
+	if srv := proxyServer; srv != nil {
+		return srv.ctx
+	}
+	return context.Background()
+}
+
+// Shutdown 优雅退出代理服务：停止接受新连接，等待活动连接结束，超过 ctx 的期限后强制关闭剩余的连接，
+// 然后等待定时任务结束，写入剩余的变化并关闭数据库
+func Shutdown(ctx context.Context) error {
+	srv := proxyServer
+	if srv == nil {
+		return errors.New("代理服务没有运行")
+	}
+	return srv.shutdown(ctx)
+}
+
+// listen 在 address 上监听并开始接受连接，连接使用 tier 对应的代理池
+func (srv *server) listen(address string, tier Tier) error {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+
+	srv.mu.Lock()
+	srv.listeners = append(srv.listeners, listener)
+	srv.mu.Unlock()
+
+	go srv.serve(listener, tier)
+	return nil
+}
+
+// serveHTTP 在 address 上启动 HTTP 服务，退出时与代理连接一起关闭
+func (srv *server) serveHTTP(address string, handler http.Handler) error {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+
+	httpServer := &http.Server{Handler: handler, ReadHeaderTimeout: 10 * time.Second}
+	srv.mu.Lock()
+	srv.httpServers = append(srv.httpServers, httpServer)
+	srv.mu.Unlock()
+
+	go func() {
+		if err := httpServer.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			serverLog.Error("HTTP 服务退出", "address", address, "error", err)
+		}
+	}()
+	return nil
+}
+
+// activeConns 返回活动的客户端连接数
+func (srv *server) activeConns() int {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	return len(srv.conns)
+}
+
+// serve 在监听器上接受连接，监听器关闭后返回
+func (srv *server) serve(listener net.Listener, tier Tier) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			select {
+			case <-srv.stopping:
+				return
+			default:
+			}
+			listenerLog.Error("接受连接失败", "error", err)
+			continue
+		}
+
+		if !srv.track(conn) {
+			conn.Close()
+			continue
+		}
+		go func() {
+			defer srv.untrack(conn)
+			handleConnection(conn, tier)
+		}()
+	}
+}
+
+// track 记录新的连接，已经开始退出时返回 false
+func (srv *server) track(conn net.Conn) bool {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	select {
+	case <-srv.stopping:
+		return false
+	default:
+	}
+	srv.conns[conn] = struct{}{}
+	srv.connWG.Add(1)
+	return true
+}
+
+func (srv *server) untrack(conn net.Conn) {
+	srv.mu.Lock()
+	delete(srv.conns, conn)
+	srv.mu.Unlock()
+	srv.connWG.Done()
+}
+
+// goTask 在后台运行任务，退出时等待任务结束
+func (srv *server) goTask(task func()) {
+	srv.taskWG.Add(1)
+	go func() {
+		defer srv.taskWG.Done()
+		task()
+	}()
+}
+
+// shutdown 只执行一次退出流程，重复调用时等待第一次调用完成并返回相同的结果
+func (srv *server) shutdown(ctx context.Context) error {
+	srv.once.Do(func() {
+		srv.err = srv.drain(ctx)
+		close(srv.done)
+	})
+	<-srv.done
+	return srv.err
+}
+
+// drain 依次停止接受连接、等待连接与后台任务结束、关闭数据库
+// 后台任务在开始退出时即被通知中止，连接结束后另有 taskShutdownWait 的时间等待它们写入结果
+func (srv *server) drain(ctx context.Context) error {
+	srv.mu.Lock()
+	srv.stop()
+	for _, listener := range srv.listeners {
+		listener.Close()
+	}
+	active := len(srv.conns)
+	httpServers := srv.httpServers
+	srv.mu.Unlock()
+	serverLog.Info("已停止接受新连接，等待活动连接结束", "active", active)
+
+	var errs []error
+	for _, httpServer := range httpServers {
+		if err := httpServer.Shutdown(ctx); err != nil {
+			httpServer.Close()
+		}
+	}
+	if !waitContext(ctx, &srv.connWG) {
+		closed := srv.closeConns()
+		errs = append(errs, fmt.Errorf("等待连接结束超时，强制关闭 %d 个连接", closed))
+
+		// 连接关闭后处理协程很快返回，等待它们写入代理的可信度变化
+		timeout, cancel := context.WithTimeout(context.Background(), forceCloseWait)
+		waitContext(timeout, &srv.connWG)
+		cancel()
+	}
+
+	// 连接的期限可能已经用完，后台任务使用单独的期限
+	taskCtx, cancel := context.WithTimeout(context.Background(), taskShutdownWait)
+	defer cancel()
+	if !waitContext(taskCtx, &srv.taskWG) {
+		// 仍在运行的任务之后的写入会丢失，批量写入时进入不再写入的缓冲区，直接写入时数据库已关闭
+		serverLog.Error("后台任务未在期限内结束，放弃等待并关闭数据库，之后的写入将丢失", "wait", taskShutdownWait)
+		errs = append(errs, errors.New("等待后台任务结束超时"))
+	}
+
+	accessLog.close()
+	if err := usage.flush(srv.storage); err != nil {
+		errs = append(errs, fmt.Errorf("写入用户用量失败: %w", err))
+	}
+
+	serverLog.Info("正在写入剩余的变化并关闭数据库")
+	if err := srv.storage.Close(); err != nil {
+		errs = append(errs, fmt.Errorf("关闭数据库失败: %w", err))
+	}
+	return errors.Join(errs...)
+}
+
+// closeConns 强制关闭所有活动连接，返回关闭的数量
+func (srv *server) closeConns() int {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	for conn := range srv.conns {
+		conn.Close()
+	}
+	return len(srv.conns)
+}
+
+// waitContext 等待 wg 完成，ctx 结束时返回 false
+func waitContext(ctx context.Context, wg *sync.WaitGroup) bool {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// shutdownOnSignal 收到 SIGINT 或 SIGTERM 后优雅退出，再次收到信号时立即退出
+func shutdownOnSignal(srv *server) {
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	var sig os.Signal
+	select {
+	case sig = <-signals:
+	case <-srv.done:
+		return
+	}
+
+	timeout := secondsOr(common.Current().Server.ShutdownTimeout, defaultShutdownTimeout)
+	serverLog.Info("收到信号，开始退出", "signal", sig.String(), "timeout", timeout)
+	go func() {
+		select {
+		case sig := <-signals:
+			serverLog.Warn("再次收到信号，立即退出", "signal", sig.String())
+			os.Exit(1)
+		case <-srv.done:
+		}
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := srv.shutdown(ctx); err != nil {
+		serverLog.Error("退出时出现问题", "error", err)
+	}
+}
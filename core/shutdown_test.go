@@ -0,0 +1,58 @@
+package core
+
+import (
+	"context"
+	"proxychain/database"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// closeRecordingStorage 记录数据库关闭之后是否还有写入
+type closeRecordingStorage struct {
+	database.Storage
+	closed       atomic.Bool
+	lateWrites   atomic.Int32
+	timelyWrites atomic.Int32
+}
+
+func (s *closeRecordingStorage) IncreasePriority(ip string, port int, num int) error {
+	if s.closed.Load() {
+		s.lateWrites.Add(1)
+	} else {
+		s.timelyWrites.Add(1)
+	}
+	return s.Storage.IncreasePriority(ip, port, num)
+}
+
+func (s *closeRecordingStorage) Close() error {
+	s.closed.Store(true)
+	return s.Storage.Close()
+}
+
+// TestShutdownWaitsForTasks 连接的期限用完后，仍然等待收到退出通知的后台任务写入结果再关闭数据库
+func TestShutdownWaitsForTasks(t *testing.T) {
+	storage := &closeRecordingStorage{Storage: database.NewMemoryStorage()}
+	srv := newServer(storage)
+
+	started := make(chan struct{})
+	srv.goTask(func() {
+		close(started)
+		<-srv.ctx.Done()
+		// 模拟正在进行的一轮检测在中止后写入已完成的结果
+		time.Sleep(100 * time.Millisecond)
+		storage.IncreasePriority("10.0.0.1", 8080, 1)
+	})
+	<-started
+
+	// 期限短于任务写入结果的时间
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := srv.shutdown(ctx); err != nil {
+		t.Fatalf("退出失败: %v", err)
+	}
+
+	if storage.lateWrites.Load() != 0 || storage.timelyWrites.Load() != 1 {
+		t.Errorf("关闭前写入 %d 次，关闭后写入 %d 次", storage.timelyWrites.Load(), storage.lateWrites.Load())
+	}
+}
@@ -0,0 +1,290 @@
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"proxychain/socks5"
+	"sync/atomic"
+	"time"
+)
+
+// udpAssociateTimeout 与上游协商 UDP ASSOCIATE 的超时时间
+const udpAssociateTimeout = 10 * time.Second
+
+// handleSOCKS5 处理 SOCKS5 客户端，支持 CONNECT 与 UDP ASSOCIATE，版本号尚未被读取
+func handleSOCKS5(s *session, clientReader *bufio.Reader) {
+	clientConn := s.conn
+
+	if _, err := clientReader.ReadByte(); err != nil {
+		return
+	}
+
+	methods, err := socks5.ReadMethods(clientReader)
+	if err != nil {
+		s.log.Debug("读取SOCKS5问候失败", "error", err)
+		return
+	}
+
+	// 客户端提供用户名时优先使用用户名密码认证，用户名用于选择代理池与统计用量
+	// 配置了用户时必须使用用户名密码认证
+	switch {
+	case bytes.Contains(methods, []byte{socks5.MethodUserPass}):
+		if _, err := clientConn.Write([]byte{socks5.Version5, socks5.MethodUserPass}); err != nil {
+			return
+		}
+		username, password, err := socks5.ReadUserPass(clientReader)
+		if err != nil {
+			s.log.Debug("读取SOCKS5认证信息失败", "error", err)
+			return
+		}
+		if !s.authenticate(username, password) {
+			socks5.WriteUserPassStatus(clientConn, socks5.UserPassFailure)
+			return
+		}
+		if err := socks5.WriteUserPassStatus(clientConn, socks5.UserPassSuccess); err != nil {
+			return
+		}
+		if tierFromUsername(username) == TierPremium {
+			s.tier = TierPremium
+		}
+	case bytes.Contains(methods, []byte{socks5.MethodNoAuth}) && !authRequired():
+		if _, err := clientConn.Write([]byte{socks5.Version5, socks5.MethodNoAuth}); err != nil {
+			return
+		}
+	default:
+		clientConn.Write([]byte{socks5.Version5, socks5.MethodNoAcceptable})
+		return
+	}
+
+	cmd, target, err := socks5.ReadRequest(clientReader)
+	if err != nil {
+		s.log.Debug("读取SOCKS5请求失败", "error", err)
+		socks5.WriteReply(clientConn, socks5.ReplyGeneralFailure, "")
+		return
+	}
+
+	if !s.acquireQuota() {
+		if cmd == socks5.CmdUDPAssociate {
+			s.kind, s.method = "udp", "UDP_ASSOCIATE"
+		} else {
+			s.kind, s.method, s.target = "socks5", "CONNECT", target
+		}
+		socks5.WriteReply(clientConn, socks5.ReplyNotAllowed, "")
+		return
+	}
+
+	switch cmd {
+	case socks5.CmdConnect:
+		handleSOCKS5Connect(s, clientReader, target)
+	case socks5.CmdUDPAssociate:
+		handleSOCKS5UDP(s, clientReader)
+	default:
+		socks5.WriteReply(clientConn, socks5.ReplyCommandNotSupported, "")
+	}
+}
+
+// handleSOCKS5Connect 通过上游代理连接目标地址，失败时更换代理重试
+func handleSOCKS5Connect(s *session, clientReader *bufio.Reader, target string) {
+	clientConn := s.conn
+	s.kind, s.method, s.target = "socks5", "CONNECT", target
+	if !s.acquireTarget() {
+		socks5.WriteReply(clientConn, socks5.ReplyNotAllowed, "")
+		return
+	}
+
+	for attempt := 1; attempt <= maxAttempts; attempt++ {
+		proxyURL := getNextProxy(s.tier, s.log)
+		s.useProxy(proxyURL, attempt)
+		if proxyURL == "" {
+			s.log.Warn("无法获取代理，连接关闭")
+			break
+		}
+
+		ip, port := extractIPAndPort(proxyURL)
+
+		dialer, err := createDialer(proxyURL)
+		if err != nil {
+			s.proxyFailed(ip, port, "", err)
+			continue
+		}
+
+		s.log.Debug("使用代理转发", "kind", s.kind, "attempt", attempt, "proxy", proxyURL, "target", target, "tier", s.tier)
+
+		start := time.Now()
+		serverConn, err := dialer.Dial("tcp", target)
+		if err != nil {
+			s.proxyFailed(ip, port, target, err)
+			continue
+		}
+		latency := time.Since(start)
+
+		if err := socks5.WriteReply(clientConn, socks5.ReplySucceeded, ""); err != nil {
+			serverConn.Close()
+			return
+		}
+		s.status = http.StatusOK
+		closeTunnel := openTunnel(s.kind)
+
+		go io.Copy(serverConn, clientReader)
+		io.Copy(clientConn, serverConn)
+		serverConn.Close()
+		closeTunnel()
+
+		s.proxySucceeded(ip, port, target, latency)
+		return
+	}
+
+	s.log.Warn("所有尝试的代理均失败，连接关闭", "attempts", s.attempts, "target", target)
+
+	socks5.WriteReply(clientConn, socks5.ReplyHostUnreachable, "")
+}
+
+// handleSOCKS5UDP 通过支持 UDP 的上游 SOCKS5 代理中继客户端的 UDP 数据报
+// 客户端与上游使用相同的 UDP 请求头格式，数据报原样转发，任一控制连接断开后关联结束
+// 支持 UDP 的代理数量较少，UDP 关联不区分代理池层级
+func handleSOCKS5UDP(s *session, clientReader *bufio.Reader) {
+	clientConn := s.conn
+	s.kind, s.method = "udp", "UDP_ASSOCIATE"
+
+	assoc, latency, err := associateUpstream(s)
+	if err != nil {
+		s.log.Warn("建立 UDP 关联失败", "error", err)
+		socks5.WriteReply(clientConn, socks5.ReplyGeneralFailure, "")
+		return
+	}
+	defer assoc.Close()
+
+	proxyURL := s.proxy
+	ip, port := extractIPAndPort(proxyURL)
+	s.target = assoc.RelayAddr.String()
+
+	// 面向客户端的中继监听在与控制连接相同的本地地址上
+	localIP := clientConn.LocalAddr().(*net.TCPAddr).IP
+	relay, err := net.ListenUDP("udp", &net.UDPAddr{IP: localIP})
+	if err != nil {
+		s.log.Error("创建 UDP 中继失败", "error", err)
+		socks5.WriteReply(clientConn, socks5.ReplyGeneralFailure, "")
+		return
+	}
+	defer relay.Close()
+
+	upstream, err := net.DialUDP("udp", nil, assoc.RelayAddr)
+	if err != nil {
+		s.proxyFailed(ip, port, assoc.RelayAddr.String(), err)
+		socks5.WriteReply(clientConn, socks5.ReplyGeneralFailure, "")
+		return
+	}
+	defer upstream.Close()
+
+	if err := socks5.WriteReply(clientConn, socks5.ReplySucceeded, relay.LocalAddr().String()); err != nil {
+		return
+	}
+	s.status = http.StatusOK
+	defer openTunnel(s.kind)()
+
+	s.log.Debug("建立 UDP 关联", "proxy", proxyURL, "relay", relay.LocalAddr().String(), "upstream_relay", s.target)
+
+	var clientUDPAddr atomic.Pointer[net.UDPAddr]
+	clientIP := clientConn.RemoteAddr().(*net.TCPAddr).IP
+	go relayClientPackets(relay, upstream, clientIP, &clientUDPAddr)
+	go relayUpstreamPackets(upstream, relay, &clientUDPAddr)
+
+	done := make(chan struct{}, 2)
+	go func() {
+		io.Copy(io.Discard, clientReader)
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(io.Discard, assoc.Control)
+		done <- struct{}{}
+	}()
+	<-done
+
+	s.proxySucceeded(ip, port, assoc.RelayAddr.String(), latency)
+}
+
+// associateUpstream 依次尝试支持 UDP 的上游代理，返回成功建立的关联，使用的代理记录在 s.proxy 中
+func associateUpstream(s *session) (*socks5.UDPAssociation, time.Duration, error) {
+	proxies, err := ps_tmp.GetRandomUDPProxies(maxAttempts)
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(proxies) == 0 {
+		s.useProxy("", 0)
+		return nil, 0, errors.New("没有支持 UDP 的代理")
+	}
+
+	lastErr := errors.New("没有可用的 UDP 代理")
+	for attempt, proxyURL := range proxies {
+		if !upstreams.acquire(proxyURL) {
+			continue
+		}
+		s.useProxy(proxyURL, attempt+1)
+		ip, port := extractIPAndPort(proxyURL)
+		parsedURL, err := url.Parse(proxyURL)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		start := time.Now()
+		assoc, err := socks5.UDPAssociate(parsedURL.Host, udpAssociateTimeout)
+		if err != nil {
+			s.proxyFailed(ip, port, "", err)
+			lastErr = err
+			continue
+		}
+
+		return assoc, time.Since(start), nil
+	}
+
+	return nil, 0, lastErr
+}
+
+// relayClientPackets 将客户端发来的数据报转发给上游中继，只接受来自控制连接同一 IP 的数据报
+func relayClientPackets(relay *net.UDPConn, upstream *net.UDPConn, clientIP net.IP, clientUDPAddr *atomic.Pointer[net.UDPAddr]) {
+	buf := make([]byte, 64*1024)
+	for {
+		n, addr, err := relay.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		if !addr.IP.Equal(clientIP) {
+			continue
+		}
+
+		// 不支持分片，按协议丢弃分片数据报
+		if n < 4 || buf[2] != 0 {
+			continue
+		}
+
+		clientUDPAddr.Store(addr)
+		if _, err := upstream.Write(buf[:n]); err != nil {
+			return
+		}
+	}
+}
+
+// relayUpstreamPackets 将上游中继返回的数据报转发给客户端
+func relayUpstreamPackets(upstream *net.UDPConn, relay *net.UDPConn, clientUDPAddr *atomic.Pointer[net.UDPAddr]) {
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := upstream.Read(buf)
+		if err != nil {
+			return
+		}
+
+		addr := clientUDPAddr.Load()
+		if addr == nil {
+			continue
+		}
+		if _, err := relay.WriteToUDP(buf[:n], addr); err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package core
+
+import (
+	"bytes"
+	"net"
+	"proxychain/database"
+	"proxychain/socks5"
+	"proxychain/socks5/socks5test"
+	"strconv"
+	"testing"
+	"time"
+)
+
+const testTimeout = 2 * time.Second
+
+// startTestListener 在随机端口上接受客户端连接并交给 handleConnection 处理，上游代理从 storage 中选择
+func startTestListener(t *testing.T, storage database.Storage) string {
+	t.Helper()
+
+	previous := ps_tmp
+	ps_tmp = storage
+	t.Cleanup(func() { ps_tmp = previous })
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("监听失败: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go handleConnection(conn, TierStandard)
+		}
+	}()
+	return listener.Addr().String()
+}
+
+// TestSOCKS5UDPRoundTrip 数据报经 客户端 -> 本地中继 -> 上游 SOCKS5 代理 -> 回显服务器 往返
+func TestSOCKS5UDPRoundTrip(t *testing.T) {
+	upstream := socks5test.NewServer(t, true)
+	echo := socks5test.NewEchoServer(t)
+
+	host, portStr, _ := net.SplitHostPort(upstream.Addr)
+	port, _ := strconv.Atoi(portStr)
+	storage := database.NewMemoryStorage()
+	storage.UpsertProxy(host, port, "socks5", "", "", "")
+	storage.UpdateUDPSupport(host, port, true)
+
+	addr := startTestListener(t, storage)
+
+	assoc, err := socks5.UDPAssociate(addr, testTimeout)
+	if err != nil {
+		t.Fatalf("与本地代理建立 UDP 关联失败: %v", err)
+	}
+	defer assoc.Close()
+
+	conn, err := net.DialUDP("udp", nil, assoc.RelayAddr)
+	if err != nil {
+		t.Fatalf("连接本地中继失败: %v", err)
+	}
+	defer conn.Close()
+
+	payload := []byte("proxychain udp round trip")
+	packet, err := socks5.PackUDP(echo.String(), payload)
+	if err != nil {
+		t.Fatalf("封装数据报失败: %v", err)
+	}
+
+	conn.SetDeadline(time.Now().Add(testTimeout))
+	if _, err := conn.Write(packet); err != nil {
+		t.Fatalf("发送数据报失败: %v", err)
+	}
+
+	buf := make([]byte, 2048)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatalf("未收到回显: %v", err)
+	}
+
+	from, _, got, err := socks5.UnpackUDP(buf[:n])
+	if err != nil {
+		t.Fatalf("解析回显失败: %v", err)
+	}
+	if from != echo.String() || !bytes.Equal(got, payload) {
+		t.Errorf("回显来自 %s，内容 %q，期望来自 %s，内容 %q", from, got, echo, payload)
+	}
+}
+
+// TestSOCKS5UDPNoUpstream 没有支持 UDP 的上游代理时拒绝 UDP ASSOCIATE
+func TestSOCKS5UDPNoUpstream(t *testing.T) {
+	addr := startTestListener(t, database.NewMemoryStorage())
+
+	_, err := socks5.UDPAssociate(addr, testTimeout)
+	if err == nil {
+		t.Fatal("没有上游代理时 UDP ASSOCIATE 成功，期望失败")
+	}
+}
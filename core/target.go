@@ -0,0 +1,147 @@
+package core
+
+import (
+	"net"
+	"net/http"
+	"proxychain/common"
+	"sync"
+	"time"
+)
+
+const (
+	errorClassTargetLimited = "target_limited" // 超出目标域名限制被拒绝
+	errorClassShuttingDown  = "shutting_down"  // 排队期间服务开始退出，放弃等待
+)
+
+// targetLimiter 按目标域名规则限制全部客户端的并发连接数与每秒新建的连接数，
+// 无论使用哪个上游代理，匹配同一条规则的连接共享限制
+type targetLimiter struct {
+	mu     sync.Mutex
+	states map[string]*targetState // 规则的 pattern -> 状态
+}
+
+// targetState 一条规则的限流状态，速率使用令牌桶，桶容量为每秒的连接数
+type targetState struct {
+	active   int
+	tokens   float64
+	updated  time.Time     // 上次补充令牌的时间
+	released chan struct{} // 有连接结束时关闭并替换，唤醒排队的连接
+}
+
+var targets = targetLimiter{states: make(map[string]*targetState)}
+
+// matchTarget 返回目标域名匹配的第一条规则
+func matchTarget(host string) (common.TargetLimit, bool) {
+	for _, rule := range common.Current().Targets {
+		if rule.Match(host) {
+			return rule, true
+		}
+	}
+	return common.TargetLimit{}, false
+}
+
+// acquire 按规则占用一个连接，超出限制时最多排队等待 rule.QueueTimeout，返回是否成功以及是否排过队
+// stop 关闭时立即放弃排队，退出时排队的连接不会拖住等待连接结束的过程
+func (l *targetLimiter) acquire(rule common.TargetLimit, stop <-chan struct{}) (ok bool, queued bool) {
+	deadline := time.Now().Add(time.Duration(rule.QueueTimeout) * time.Millisecond)
+	for {
+		now := time.Now()
+
+		l.mu.Lock()
+		state, exists := l.states[rule.Pattern]
+		if !exists {
+			state = &targetState{tokens: max(rule.MaxRPS, 1), updated: now, released: make(chan struct{})}
+			l.states[rule.Pattern] = state
+		}
+		if rule.MaxRPS > 0 {
+			state.tokens = min(state.tokens+now.Sub(state.updated).Seconds()*rule.MaxRPS, max(rule.MaxRPS, 1))
+			state.updated = now
+		}
+
+		connectionsOK := rule.MaxConnections == 0 || state.active < rule.MaxConnections
+		rateOK := rule.MaxRPS == 0 || state.tokens >= 1
+		if connectionsOK && rateOK {
+			if rule.MaxRPS > 0 {
+				state.tokens--
+			}
+			state.active++
+			l.mu.Unlock()
+			return true, queued
+		}
+
+		wait := deadline.Sub(now)
+		if !rateOK {
+			// 令牌补足一个之前不可能成功，期间有连接结束也无需重试
+			wait = min(wait, time.Duration((1-state.tokens)/rule.MaxRPS*float64(time.Second)))
+		}
+		released := state.released
+		l.mu.Unlock()
+
+		if deadline.Sub(now) <= 0 {
+			return false, queued
+		}
+		queued = true
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-released:
+		case <-timer.C:
+		case <-stop:
+			timer.Stop()
+			return false, queued
+		}
+		timer.Stop()
+	}
+}
+
+// release 释放 acquire 占用的连接，并唤醒排队的连接
+func (l *targetLimiter) release(pattern string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if state, ok := l.states[pattern]; ok && state.active > 0 {
+		state.active--
+		close(state.released)
+		state.released = make(chan struct{})
+	}
+}
+
+// acquireTarget 在选择上游代理之前检查目标域名的限制，超出限制且排队超时后记录 429 状态
+func (s *session) acquireTarget() bool {
+	host, _, err := net.SplitHostPort(s.target)
+	if err != nil {
+		host = s.target
+	}
+	rule, ok := matchTarget(host)
+	if !ok {
+		return true
+	}
+
+	start := time.Now()
+	ctx := serverContext()
+	ok, queued := targets.acquire(rule, ctx.Done())
+	switch {
+	case !ok && ctx.Err() != nil:
+		s.status, s.errorClass = http.StatusServiceUnavailable, errorClassShuttingDown
+		s.log.Info("服务正在退出，放弃排队的请求", "target", s.target, "rule", rule.Pattern, "waited", time.Since(start))
+		return false
+	case !ok:
+		s.status, s.errorClass = http.StatusTooManyRequests, errorClassTargetLimited
+		targetLimitedTotal.add(1, rule.Pattern, "rejected")
+		s.log.Info("拒绝超出目标域名限制的请求", "target", s.target, "rule", rule.Pattern, "waited", time.Since(start))
+		return false
+	case queued:
+		targetLimitedTotal.add(1, rule.Pattern, "queued")
+		s.log.Debug("目标域名限制排队后放行", "target", s.target, "rule", rule.Pattern, "waited", time.Since(start))
+	}
+	s.targetRule = rule.Pattern
+	return true
+}
+
+// releaseTarget 释放连接占用的目标域名限制
+func (s *session) releaseTarget() {
+	if s.targetRule != "" {
+		targets.release(s.targetRule)
+		s.targetRule = ""
+	}
+}
@@ -0,0 +1,126 @@
+package core
+
+import (
+	"proxychain/common"
+	"testing"
+	"time"
+)
+
+func newTestTargetLimiter() *targetLimiter {
+	return &targetLimiter{states: make(map[string]*targetState)}
+}
+
+// TestTargetLimiterAcquire 按规则排队与拒绝，被测的 acquire 之前先不排队地占用 occupied 个连接，during 在排队期间执行
+func TestTargetLimiterAcquire(t *testing.T) {
+	tests := []struct {
+		name       string
+		rule       common.TargetLimit
+		occupied   int
+		refilled   time.Duration // 占用之后将上次补充令牌的时间提前
+		during     func(l *targetLimiter, rule common.TargetLimit, stop chan struct{})
+		wantOK     bool
+		wantQueued bool
+		minWait    time.Duration
+		maxWait    time.Duration
+	}{
+		{
+			name:    "未超出限制时直接放行",
+			rule:    common.TargetLimit{Pattern: "a.com", MaxConnections: 2, MaxRPS: 5},
+			wantOK:  true,
+			maxWait: 50 * time.Millisecond,
+		},
+		{
+			name:     "并发已满且不排队时立即拒绝",
+			rule:     common.TargetLimit{Pattern: "a.com", MaxConnections: 1},
+			occupied: 1,
+			maxWait:  50 * time.Millisecond,
+		},
+		{
+			name:     "令牌用完且不排队时立即拒绝",
+			rule:     common.TargetLimit{Pattern: "a.com", MaxRPS: 2},
+			occupied: 2,
+			maxWait:  50 * time.Millisecond,
+		},
+		{
+			name:     "令牌随时间补充",
+			rule:     common.TargetLimit{Pattern: "a.com", MaxRPS: 2},
+			occupied: 2,
+			// 距上次补充 600ms，按每秒 2 个补充了 1.2 个令牌
+			refilled: 600 * time.Millisecond,
+			wantOK:   true,
+			maxWait:  50 * time.Millisecond,
+		},
+		{
+			name:       "令牌不足时只等待补足一个令牌的时间",
+			rule:       common.TargetLimit{Pattern: "a.com", MaxRPS: 10, QueueTimeout: 2000},
+			occupied:   10,
+			wantOK:     true,
+			wantQueued: true,
+			minWait:    80 * time.Millisecond,
+			maxWait:    time.Second,
+		},
+		{
+			name:       "排队时间不足以补足令牌时超时拒绝",
+			rule:       common.TargetLimit{Pattern: "a.com", MaxRPS: 1, QueueTimeout: 50},
+			occupied:   1,
+			wantQueued: true,
+			minWait:    40 * time.Millisecond,
+			maxWait:    500 * time.Millisecond,
+		},
+		{
+			name:     "有连接结束时唤醒排队的连接",
+			rule:     common.TargetLimit{Pattern: "a.com", MaxConnections: 1, QueueTimeout: 5000},
+			occupied: 1,
+			during: func(l *targetLimiter, rule common.TargetLimit, _ chan struct{}) {
+				time.Sleep(30 * time.Millisecond)
+				l.release(rule.Pattern)
+			},
+			wantOK:     true,
+			wantQueued: true,
+			minWait:    20 * time.Millisecond,
+			maxWait:    time.Second,
+		},
+		{
+			name:     "服务退出时放弃排队",
+			rule:     common.TargetLimit{Pattern: "a.com", MaxConnections: 1, QueueTimeout: 5000},
+			occupied: 1,
+			during: func(_ *targetLimiter, _ common.TargetLimit, stop chan struct{}) {
+				time.Sleep(30 * time.Millisecond)
+				close(stop)
+			},
+			wantQueued: true,
+			minWait:    20 * time.Millisecond,
+			maxWait:    time.Second,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			limiter := newTestTargetLimiter()
+			queueless := tt.rule
+			queueless.QueueTimeout = 0
+			for i := 0; i < tt.occupied; i++ {
+				if ok, _ := limiter.acquire(queueless, nil); !ok {
+					t.Fatalf("占用第 %d 个连接时被拒绝", i+1)
+				}
+			}
+			if tt.refilled > 0 {
+				limiter.states[tt.rule.Pattern].updated = time.Now().Add(-tt.refilled)
+			}
+			stop := make(chan struct{})
+			if tt.during != nil {
+				go tt.during(limiter, tt.rule, stop)
+			}
+
+			start := time.Now()
+			ok, queued := limiter.acquire(tt.rule, stop)
+			waited := time.Since(start)
+			if ok != tt.wantOK || queued != tt.wantQueued {
+				t.Errorf("acquire 返回 (%v, %v)，期望 (%v, %v)", ok, queued, tt.wantOK, tt.wantQueued)
+			}
+			if waited < tt.minWait || waited > tt.maxWait {
+				t.Errorf("等待了 %v，期望在 %v 到 %v 之间", waited, tt.minWait, tt.maxWait)
+			}
+		})
+	}
+}
@@ -0,0 +1,160 @@
+package core
+
+import (
+	"encoding/base64"
+	"net/http"
+	"proxychain/common"
+	"proxychain/database"
+	"strings"
+	"time"
+)
+
+// Tier 表示客户端使用的代理池层级
+type Tier string
+
+const (
+	TierStandard Tier = "standard" // 普通代理池，包含全部可用代理
+	TierPremium  Tier = "premium"  // 高级代理池，只包含持续表现良好的代理
+)
+
+// tierHeader 客户端用于请求高级代理池的请求头
+const tierHeader = "X-Proxychain-Tier"
+
+// 高级代理池晋升与降级的默认阈值，配置缺省时使用
+const (
+	defaultPromotePriority = 150
+	defaultPromoteUptime   = 95
+	defaultDemotePriority  = 120
+	defaultDemoteUptime    = 80
+	defaultMinRecords      = 10
+	defaultUserTag         = "premium"
+)
+
+// loadPremiumProxies 从数据库中加载高级代理
+func loadPremiumProxies(ps database.Storage, onlyChina bool, minBandwidth float64) {
+	country := ""
+	if onlyChina {
+		country = "中国"
+	}
+
+	premiumList, err := ps.GetPremiumProxies(10, country, minBandwidth)
+	if err != nil {
+		serverLog.Error("获取高级代理列表失败", "error", err)
+		return
+	}
+	serverLog.Info("更新当前高级代理列表", "proxies", premiumList)
+
+	mu.Lock()
+	GlobePremiumProxyList = premiumList
+	premiumIndex = 0
+	mu.Unlock()
+}
+
+// requestedTier 根据请求头与代理认证的用户名判断客户端请求的代理池层级
+// 两个请求头只用于本地选择代理，判断后从请求中移除，避免转发给目标
+func requestedTier(request *http.Request) Tier {
+	tier := TierStandard
+	if strings.EqualFold(strings.TrimSpace(request.Header.Get(tierHeader)), string(TierPremium)) {
+		tier = TierPremium
+	}
+	if username, _, ok := proxyAuthCredentials(request.Header.Get("Proxy-Authorization")); ok && tierFromUsername(username) == TierPremium {
+		tier = TierPremium
+	}
+
+	request.Header.Del(tierHeader)
+	request.Header.Del("Proxy-Authorization")
+
+	if !common.Current().Premium.Enabled {
+		return TierStandard
+	}
+	return tier
+}
+
+// proxyAuthCredentials 从 Basic 方式的 Proxy-Authorization 中解析用户名与密码
+func proxyAuthCredentials(auth string) (string, string, bool) {
+	encoded, ok := strings.CutPrefix(auth, "Basic ")
+	if !ok {
+		return "", "", false
+	}
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return "", "", false
+	}
+	return strings.Cut(string(decoded), ":")
+}
+
+// tierFromUsername 用户名中以 + 分隔的任一部分等于配置的标记时使用高级代理池，例如 alice+premium
+func tierFromUsername(username string) Tier {
+	if !common.Current().Premium.Enabled {
+		return TierStandard
+	}
+
+	tag := common.Current().Premium.UserTag
+	if tag == "" {
+		tag = defaultUserTag
+	}
+	for _, part := range strings.Split(username, "+") {
+		if strings.EqualFold(part, tag) {
+			return TierPremium
+		}
+	}
+	return TierStandard
+}
+
+// updateTiers 根据可信度与统计窗口内的可用率调整高级代理池
+// 晋升与降级使用不同的阈值，避免代理在两个层级之间反复切换
+func updateTiers(ps database.Storage) {
+	cfg := common.Current().Premium
+	if !cfg.Enabled {
+		return
+	}
+
+	promotePriority := positiveOr(cfg.PromotePriority, defaultPromotePriority)
+	promoteUptime := percentOr(cfg.PromoteUptime, defaultPromoteUptime)
+	demotePriority := positiveOr(cfg.DemotePriority, defaultDemotePriority)
+	demoteUptime := percentOr(cfg.DemoteUptime, defaultDemoteUptime)
+	minRecords := positiveOr(cfg.MinRecords, defaultMinRecords)
+
+	candidates, err := ps.GetTierCandidates(time.Now().Add(-uptimeWindow()))
+	if err != nil {
+		taskLog.Error("获取高级代理候选失败", "error", err)
+		return
+	}
+
+	var promoted, demoted int
+	for _, c := range candidates {
+		var uptime float64
+		if c.Total > 0 {
+			uptime = float64(c.Successes) * 100 / float64(c.Total)
+		}
+
+		switch {
+		case !c.Premium && c.Active && c.Priority >= promotePriority && c.Total >= minRecords && uptime >= promoteUptime:
+			if err := ps.PromoteProxy(c.IP, c.Port, c.Protocol); err != nil {
+				taskLog.Error("晋升代理失败", "ip", c.IP, "port", c.Port, "error", err)
+				continue
+			}
+			taskLog.Info("代理晋升为高级代理", "ip", c.IP, "port", c.Port, "priority", c.Priority, "uptime", uptime)
+			promoted++
+		case c.Premium && (!c.Active || c.Priority < demotePriority || (c.Total >= minRecords && uptime < demoteUptime)):
+			if err := ps.DemoteProxy(c.IP, c.Port, c.Protocol); err != nil {
+				taskLog.Error("降级代理失败", "ip", c.IP, "port", c.Port, "error", err)
+				continue
+			}
+			taskLog.Info("代理降级为普通代理", "ip", c.IP, "port", c.Port, "priority", c.Priority, "uptime", uptime)
+			demoted++
+		}
+	}
+
+	if promoted > 0 || demoted > 0 {
+		taskLog.Info("高级代理池调整完成", "promoted", promoted, "demoted", demoted)
+	}
+}
+
+// percentOr 当百分比不在 (0, 100] 范围内时返回默认值
+func percentOr(v, def float64) float64 {
+	if v <= 0 || v > 100 {
+		return def
+	}
+	return v
+}
@@ -0,0 +1,382 @@
+package core
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/kayon/iploc"
+	"io"
+	"os"
+	"proxychain/common"
+	"proxychain/database"
+	"proxychain/utils"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 导出与导入支持的文件格式
+const (
+	formatJSON = "json"
+	formatCSV  = "csv"
+	formatList = "list" // 每行一个 protocol://ip:port
+)
+
+// csvHeader CSV 格式的列，导入时按列名读取，缺少的列使用默认值
+var csvHeader = []string{
+	"ip", "port", "protocol", "country", "province", "city", "active", "priority", "premium",
+	"last_checked", "bandwidth", "udp", "quarantined_at", "quarantine_reason",
+}
+
+// RunExport 导出数据库中的全部代理，包括隔离区中的代理
+func RunExport(args []string) error {
+	flags := newFlagSet("export")
+	format := flags.String("format", formatJSON, "导出格式: json、csv 或 list")
+	output := flags.String("o", "", "导出到指定文件，缺省时输出到标准输出")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	ps, err := openStorage()
+	if err != nil {
+		return fmt.Errorf("打开数据库失败: %w", err)
+	}
+	defer ps.Close()
+
+	records, err := ps.ExportProxies()
+	if err != nil {
+		return fmt.Errorf("读取代理失败: %w", err)
+	}
+
+	w := io.Writer(os.Stdout)
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		w = file
+	}
+
+	if err := writeRecords(w, *format, records); err != nil {
+		return err
+	}
+	if *output != "" {
+		fmt.Printf("已导出 %d 个代理到 %s\n", len(records), *output)
+	}
+	return nil
+}
+
+// RunImport 从文件导入代理，与已有代理冲突时按合并策略处理
+func RunImport(args []string) error {
+	flags := newFlagSet("import")
+	format := flags.String("format", "", "导入格式: json、csv 或 list，缺省时按文件扩展名判断")
+	strategy := flags.String("strategy", database.MergeHigher,
+		"与已有代理冲突时的合并策略: higher 保留可信度更高的一方，overwrite 覆盖，skip 保留已有的代理")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return errors.New("用法: import [-format json|csv|list] [-strategy higher|overwrite|skip] <文件>")
+	}
+
+	path := flags.Arg(0)
+	if *format == "" {
+		*format = formatFromPath(path)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	records, err := readRecords(file, *format)
+	if err != nil {
+		return fmt.Errorf("解析 %s 失败: %w", path, err)
+	}
+
+	ps, err := openStorage()
+	if err != nil {
+		return fmt.Errorf("打开数据库失败: %w", err)
+	}
+	defer ps.Close()
+
+	imported, err := ps.ImportProxies(records, *strategy)
+	if err != nil {
+		return fmt.Errorf("导入代理失败: %w", err)
+	}
+	fmt.Printf("读取 %d 个代理，写入 %d 个，跳过 %d 个（合并策略 %s）\n",
+		len(records), imported, len(records)-imported, *strategy)
+	return nil
+}
+
+// RunBackup 在不停止代理服务的情况下备份 SQLite 数据库
+func RunBackup(args []string) error {
+	flags := newFlagSet("backup")
+	output := flags.String("o", "", "备份文件路径，缺省时在数据库旁生成带时间戳的文件")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	path := *output
+	if path == "" {
+		path = fmt.Sprintf("%s.%s.bak", common.Current().Database.Path, time.Now().Format("20060102-150405"))
+	}
+	if utils.FileExists(path) {
+		return fmt.Errorf("备份文件 %s 已存在", path)
+	}
+
+	ps, err := openStorage()
+	if err != nil {
+		return fmt.Errorf("打开数据库失败: %w", err)
+	}
+	defer ps.Close()
+
+	backuper, ok := ps.(database.Backuper)
+	if !ok {
+		return database.ErrBackupUnsupported
+	}
+	if err := backuper.Backup(path); err != nil {
+		if errors.Is(err, database.ErrBackupUnsupported) && common.Current().Database.Type == database.TypePostgres {
+			return fmt.Errorf("%w，PostgreSQL 请使用 pg_dump", err)
+		}
+		return fmt.Errorf("备份数据库失败: %w", err)
+	}
+
+	fmt.Printf("已备份数据库到 %s\n", path)
+	return nil
+}
+
+// formatFromPath 根据文件扩展名判断格式，无法判断时按 list 处理
+func formatFromPath(path string) string {
+	switch {
+	case strings.HasSuffix(path, ".json"):
+		return formatJSON
+	case strings.HasSuffix(path, ".csv"):
+		return formatCSV
+	default:
+		return formatList
+	}
+}
+
+// writeRecords 按指定格式写出代理
+func writeRecords(w io.Writer, format string, records []database.ProxyRecord) error {
+	switch format {
+	case formatJSON:
+		if records == nil {
+			records = []database.ProxyRecord{}
+		}
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(records)
+	case formatCSV:
+		writer := csv.NewWriter(w)
+		writer.Write(csvHeader)
+		for _, record := range records {
+			writer.Write([]string{
+				record.IP, strconv.Itoa(record.Port), record.Protocol, record.Country, record.Province, record.City,
+				strconv.FormatBool(record.Active), strconv.Itoa(record.Priority), strconv.FormatBool(record.Premium),
+				formatOptionalTime(record.LastChecked), formatOptionalFloat(record.Bandwidth),
+				formatOptionalBool(record.UDP), formatOptionalTime(record.QuarantinedAt), record.QuarantineReason,
+			})
+		}
+		writer.Flush()
+		return writer.Error()
+	case formatList:
+		buffered := bufio.NewWriter(w)
+		for _, record := range records {
+			fmt.Fprintf(buffered, "%s://%s:%d\n", record.Protocol, record.IP, record.Port)
+		}
+		return buffered.Flush()
+	default:
+		return fmt.Errorf("不支持的格式: %s", format)
+	}
+}
+
+// readRecords 按指定格式读取代理
+func readRecords(r io.Reader, format string) ([]database.ProxyRecord, error) {
+	switch format {
+	case formatJSON:
+		var raw []json.RawMessage
+		if err := json.NewDecoder(r).Decode(&raw); err != nil {
+			return nil, err
+		}
+
+		// 每条记录先填充默认值，只包含地址的记录也可以导入
+		records := make([]database.ProxyRecord, 0, len(raw))
+		for i, data := range raw {
+			record := newImportRecord("", 0, "")
+			if err := json.Unmarshal(data, &record); err != nil {
+				return nil, fmt.Errorf("第 %d 条记录解析失败: %w", i+1, err)
+			}
+			if record.IP == "" || record.Port <= 0 {
+				return nil, fmt.Errorf("第 %d 条记录缺少地址", i+1)
+			}
+			if record.Protocol == "" {
+				record.Protocol = "http"
+			}
+			records = append(records, record)
+		}
+		return records, nil
+	case formatCSV:
+		return readCSVRecords(r)
+	case formatList:
+		return readListRecords(r)
+	default:
+		return nil, fmt.Errorf("不支持的格式: %s", format)
+	}
+}
+
+// readCSVRecords 读取带表头的 CSV，可以只包含部分列
+func readCSVRecords(r io.Reader) ([]database.ProxyRecord, error) {
+	rows, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, nil
+	}
+
+	columns := make(map[string]int)
+	for i, name := range rows[0] {
+		columns[strings.TrimSpace(name)] = i
+	}
+	if _, ok := columns["ip"]; !ok {
+		return nil, errors.New("缺少 ip 列")
+	}
+	if _, ok := columns["port"]; !ok {
+		return nil, errors.New("缺少 port 列")
+	}
+
+	var records []database.ProxyRecord
+	for line, row := range rows[1:] {
+		field := func(name string) string {
+			if i, ok := columns[name]; ok && i < len(row) {
+				return strings.TrimSpace(row[i])
+			}
+			return ""
+		}
+
+		record := newImportRecord(field("ip"), 0, field("protocol"))
+		var err error
+		if record.Port, err = strconv.Atoi(field("port")); err != nil {
+			return nil, fmt.Errorf("第 %d 行端口不合法: %w", line+2, err)
+		}
+		record.Country, record.Province, record.City = field("country"), field("province"), field("city")
+		record.QuarantineReason = field("quarantine_reason")
+		if v := field("active"); v != "" {
+			record.Active, err = strconv.ParseBool(v)
+		}
+		if v := field("priority"); v != "" && err == nil {
+			record.Priority, err = strconv.Atoi(v)
+		}
+		if v := field("premium"); v != "" && err == nil {
+			record.Premium, err = strconv.ParseBool(v)
+		}
+		if err == nil {
+			record.LastChecked, err = parseOptionalTime(field("last_checked"))
+		}
+		if err == nil {
+			record.QuarantinedAt, err = parseOptionalTime(field("quarantined_at"))
+		}
+		if v := field("bandwidth"); v != "" && err == nil {
+			var bandwidth float64
+			bandwidth, err = strconv.ParseFloat(v, 64)
+			record.Bandwidth = &bandwidth
+		}
+		if v := field("udp"); v != "" && err == nil {
+			var udp bool
+			udp, err = strconv.ParseBool(v)
+			record.UDP = &udp
+		}
+		if err != nil {
+			return nil, fmt.Errorf("第 %d 行解析失败: %w", line+2, err)
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+// readListRecords 读取每行一个代理地址的列表，支持 protocol://ip:port 与 ip:port，忽略空行与 # 开头的注释
+// 数据目录中存在纯真 IP 数据库时补全位置信息
+func readListRecords(r io.Reader) ([]database.ProxyRecord, error) {
+	var locator *iploc.Locator
+	if utils.FileExists("data/czutf8.dat") {
+		locator, _ = iploc.Open("data/czutf8.dat")
+	}
+
+	var records []database.ProxyRecord
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		protocol := "http"
+		if scheme, rest, ok := strings.Cut(text, "://"); ok {
+			protocol, text = scheme, rest
+		}
+		ip, port, err := common.ExtractIPAndPort("tcp://" + text)
+		if err != nil || ip == "" {
+			return nil, fmt.Errorf("第 %d 行地址不合法: %s", line, scanner.Text())
+		}
+
+		record := newImportRecord(ip, port, protocol)
+		if locator != nil {
+			detail := locator.Find(ip)
+			record.Country, record.Province, record.City = detail.Country, detail.Province, detail.City
+		}
+		records = append(records, record)
+	}
+	return records, scanner.Err()
+}
+
+// newImportRecord 返回带默认值的代理记录，缺省的可信度为评分模型的中性值
+func newImportRecord(ip string, port int, protocol string) database.ProxyRecord {
+	if protocol == "" {
+		protocol = "http"
+	}
+	return database.ProxyRecord{
+		IP:       ip,
+		Port:     port,
+		Protocol: protocol,
+		Active:   true,
+		Priority: common.CurrentScoreModel().Neutral,
+	}
+}
+
+func formatOptionalTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
+
+func parseOptionalTime(s string) (*time.Time, error) {
+	if s == "" {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+func formatOptionalFloat(f *float64) string {
+	if f == nil {
+		return ""
+	}
+	return strconv.FormatFloat(*f, 'f', -1, 64)
+}
+
+func formatOptionalBool(b *bool) string {
+	if b == nil {
+		return ""
+	}
+	return strconv.FormatBool(*b)
+}
@@ -0,0 +1,163 @@
+package core
+
+import (
+	"net/http"
+	"proxychain/common"
+	"sort"
+	"sync"
+	"time"
+)
+
+// upstreamIdleTTL 没有连接的上游状态在最后一次使用之后保留的时长，超过后清理，也是清理的间隔
+const upstreamIdleTTL = 10 * time.Minute
+
+// upstreamLimiter 限制每个上游代理的并发连接数与每秒新建的连接数，避免压垮免费代理后又错误地扣减其可信度
+type upstreamLimiter struct {
+	mu     sync.Mutex
+	states map[string]*upstreamState // 代理地址 -> 状态
+	swept  time.Time                 // 上次清理空闲状态的时间
+}
+
+// upstreamState 单个上游代理的限流状态，速率使用令牌桶，桶容量为每秒的连接数
+type upstreamState struct {
+	active    int
+	tokens    float64
+	updated   time.Time // 上次补充令牌的时间，未限制速率时不更新
+	lastUsed  time.Time // 最后一次分配或释放连接的时间
+	acquired  int64     // 累计分配的连接数
+	saturated int64     // 因达到上限被跳过的次数
+}
+
+var upstreams = upstreamLimiter{states: make(map[string]*upstreamState)}
+
+// acquire 在代理未达到上限时占用一个连接，返回是否成功，成功后需要调用 release 释放
+func (l *upstreamLimiter) acquire(proxy string) bool {
+	cfg := common.Current().Upstream
+	now := time.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.swept) >= upstreamIdleTTL {
+		l.sweep(now)
+	}
+
+	state, ok := l.states[proxy]
+	if !ok {
+		state = &upstreamState{tokens: max(cfg.MaxRPS, 1), updated: now}
+		l.states[proxy] = state
+	}
+	state.lastUsed = now
+
+	if cfg.MaxRPS > 0 {
+		burst := max(cfg.MaxRPS, 1)
+		state.tokens = min(state.tokens+now.Sub(state.updated).Seconds()*cfg.MaxRPS, burst)
+		state.updated = now
+	}
+	if (cfg.MaxConnections > 0 && state.active >= cfg.MaxConnections) || (cfg.MaxRPS > 0 && state.tokens < 1) {
+		state.saturated++
+		upstreamSaturatedTotal.add(1)
+		return false
+	}
+
+	if cfg.MaxRPS > 0 {
+		state.tokens--
+	}
+	state.active++
+	state.acquired++
+	return true
+}
+
+// release 释放 acquire 占用的连接
+func (l *upstreamLimiter) release(proxy string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if state, ok := l.states[proxy]; ok && state.active > 0 {
+		state.active--
+		state.lastUsed = time.Now()
+	}
+}
+
+// sweep 清理没有连接且超过 upstreamIdleTTL 未使用的状态，调用方需持有 l.mu
+// 代理池轮换后不再使用的代理不会一直占用内存
+func (l *upstreamLimiter) sweep(now time.Time) {
+	for proxy, state := range l.states {
+		if state.active == 0 && now.Sub(state.lastUsed) > upstreamIdleTTL {
+			delete(l.states, proxy)
+		}
+	}
+	l.swept = now
+}
+
+// upstreamStatus 单个上游代理当前的限流状态
+type upstreamStatus struct {
+	Proxy     string  `json:"proxy"`
+	Active    int     `json:"active"`    // 正在使用该代理的连接数
+	Tokens    float64 `json:"tokens"`    // 当前可以立即新建的连接数，未限制速率时为 -1
+	Saturated bool    `json:"saturated"` // 当前是否已达到上限
+	Acquired  int64   `json:"acquired"`
+	Skipped   int64   `json:"skipped"` // 因达到上限被跳过的次数
+}
+
+// snapshot 返回各上游代理的限流状态，正在使用的连接数最多的排在前面
+func (l *upstreamLimiter) snapshot() []upstreamStatus {
+	cfg := common.Current().Upstream
+	now := time.Now()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	statuses := make([]upstreamStatus, 0, len(l.states))
+	for proxy, state := range l.states {
+		tokens := -1.0
+		if cfg.MaxRPS > 0 {
+			tokens = min(state.tokens+now.Sub(state.updated).Seconds()*cfg.MaxRPS, max(cfg.MaxRPS, 1))
+		}
+		statuses = append(statuses, upstreamStatus{
+			Proxy:     proxy,
+			Active:    state.active,
+			Tokens:    tokens,
+			Saturated: (cfg.MaxConnections > 0 && state.active >= cfg.MaxConnections) || (cfg.MaxRPS > 0 && tokens < 1),
+			Acquired:  state.acquired,
+			Skipped:   state.saturated,
+		})
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		if statuses[i].Active != statuses[j].Active {
+			return statuses[i].Active > statuses[j].Active
+		}
+		return statuses[i].Proxy < statuses[j].Proxy
+	})
+	return statuses
+}
+
+// pickProxy 从 index 开始轮询代理列表，跳过已达到上限的代理，调用方需持有 mu
+func pickProxy(list []string, index *int) (string, bool) {
+	for i := 0; i < len(list); i++ {
+		proxy := list[(*index+i)%len(list)]
+		if upstreams.acquire(proxy) {
+			*index = (*index + i + 1) % len(list)
+			return proxy, true
+		}
+	}
+	return "", false
+}
+
+// upstreamLimits 返回上游代理的限制配置与各代理的限流状态
+func (api *adminAPI) upstreamLimits(w http.ResponseWriter, r *http.Request) {
+	cfg := common.Current().Upstream
+	writeJSON(w, http.StatusOK, map[string]any{
+		"max_connections": cfg.MaxConnections,
+		"max_rps":         cfg.MaxRPS,
+		"upstreams":       upstreams.snapshot(),
+	})
+}
+
+// releaseUpstream 释放本次尝试占用的上游连接限额
+func (s *session) releaseUpstream() {
+	if s.holding {
+		upstreams.release(s.proxy)
+		s.holding = false
+	}
+}
@@ -0,0 +1,86 @@
+package core
+
+import (
+	"proxychain/common"
+	"testing"
+	"time"
+)
+
+func newTestUpstreamLimiter() *upstreamLimiter {
+	return &upstreamLimiter{states: make(map[string]*upstreamState), swept: time.Now()}
+}
+
+func TestUpstreamLimiterMaxConnections(t *testing.T) {
+	setTestConfig(t, func(cfg *common.Config) { cfg.Upstream.MaxConnections = 2 })
+	limiter := newTestUpstreamLimiter()
+
+	for i := 0; i < 2; i++ {
+		if !limiter.acquire("http://a:1") {
+			t.Fatalf("第 %d 个连接被拒绝", i+1)
+		}
+	}
+	if limiter.acquire("http://a:1") {
+		t.Fatal("达到并发上限后仍然分配了连接")
+	}
+	if !limiter.acquire("http://b:1") {
+		t.Fatal("其他代理不应受影响")
+	}
+
+	limiter.release("http://a:1")
+	if !limiter.acquire("http://a:1") {
+		t.Fatal("释放后仍然无法分配连接")
+	}
+
+	status := limiter.snapshot()[0]
+	if status.Proxy != "http://a:1" || status.Active != 2 || status.Acquired != 3 || status.Skipped != 1 || !status.Saturated {
+		t.Errorf("状态为 %+v", status)
+	}
+}
+
+func TestUpstreamLimiterMaxRPS(t *testing.T) {
+	setTestConfig(t, func(cfg *common.Config) { cfg.Upstream.MaxRPS = 2 })
+	limiter := newTestUpstreamLimiter()
+
+	for i := 0; i < 2; i++ {
+		if !limiter.acquire("http://a:1") {
+			t.Fatalf("第 %d 个连接被拒绝", i+1)
+		}
+		limiter.release("http://a:1")
+	}
+	if limiter.acquire("http://a:1") {
+		t.Fatal("超过每秒连接数后仍然分配了连接")
+	}
+}
+
+// TestUpstreamLimiterSweep 清理只按最后一次使用的时间判断，未限制速率时也不会清理刚使用过的代理
+func TestUpstreamLimiterSweep(t *testing.T) {
+	setTestConfig(t, func(cfg *common.Config) { cfg.Upstream = common.Current().Upstream })
+	limiter := newTestUpstreamLimiter()
+
+	limiter.acquire("http://recent:1")
+	limiter.release("http://recent:1")
+	limiter.acquire("http://idle:1")
+	limiter.release("http://idle:1")
+	limiter.acquire("http://busy:1")
+
+	// 三个代理都在很久以前创建，idle 与 busy 在很久以前使用过，busy 仍有连接
+	long := time.Now().Add(-2 * upstreamIdleTTL)
+	for _, state := range limiter.states {
+		state.updated = long
+	}
+	limiter.states["http://idle:1"].lastUsed = long
+	limiter.states["http://busy:1"].lastUsed = long
+
+	// 距离上次清理超过 upstreamIdleTTL 时，acquire 会先清理
+	limiter.swept = long
+	limiter.acquire("http://other:1")
+
+	for proxy, want := range map[string]bool{"http://recent:1": true, "http://idle:1": false, "http://busy:1": true} {
+		if _, ok := limiter.states[proxy]; ok != want {
+			t.Errorf("%s 保留: %v，期望 %v", proxy, ok, want)
+		}
+	}
+	if got := limiter.states["http://recent:1"].acquired; got != 1 {
+		t.Errorf("保留的代理累计分配 %d 次，期望 1 次", got)
+	}
+}
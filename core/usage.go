@@ -0,0 +1,423 @@
+package core
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"proxychain/common"
+	"proxychain/database"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// usageFlushInterval 用户用量写入数据库的间隔
+	usageFlushInterval = 30 * time.Second
+	// usageDayLayout 用量按本地日期统计
+	usageDayLayout = "2006-01-02"
+	// authRealm 要求客户端认证时返回的 realm
+	authRealm = "proxychain"
+	// defaultUsageDays 查看用量时默认输出的天数
+	defaultUsageDays = 7
+)
+
+// 连接被拒绝时的错误分类
+const (
+	errorClassUnauthorized  = "unauthorized"
+	errorClassQuotaExceeded = "quota_exceeded"
+)
+
+// 超出用户限额的原因
+var (
+	errRequestQuota    = errors.New("超出每日请求数限额")
+	errBytesQuota      = errors.New("超出每日流量限额")
+	errConnectionQuota = errors.New("超出同时连接数限额")
+)
+
+// usageKey 用量按用户与日期累计
+type usageKey struct {
+	user string
+	day  string
+}
+
+// usageTracker 在内存中累计用户的用量与活动连接数，定时将增量写入数据库
+type usageTracker struct {
+	mu      sync.Mutex
+	totals  map[usageKey]*database.UserUsage // 当天的累计用量，包括已经写入数据库的部分，用于检查限额
+	pending map[usageKey]*database.UserUsage // 尚未写入数据库的增量
+	active  map[string]int                   // 用户 -> 活动连接数
+}
+
+var usage = usageTracker{
+	totals:  make(map[usageKey]*database.UserUsage),
+	pending: make(map[usageKey]*database.UserUsage),
+	active:  make(map[string]int),
+}
+
+// today 返回当前的本地日期
+func today() string {
+	return time.Now().Format(usageDayLayout)
+}
+
+// accountName 返回用于认证与统计用量的用户名，去掉 + 之后的代理池标记，例如 alice+premium 为 alice
+func accountName(username string) string {
+	name, _, _ := strings.Cut(username, "+")
+	return name
+}
+
+// authenticate 校验客户端的用户名密码，返回统计用量的用户名
+// 没有配置用户时不要求认证，也不统计用量，避免任意的用户名写入数据库
+func authenticate(username, password string) (string, bool) {
+	account := accountName(username)
+	users := common.Current().Auth.Users
+	if len(users) == 0 {
+		return "", true
+	}
+
+	user, ok := users[account]
+	if !ok || subtle.ConstantTimeCompare([]byte(password), []byte(user.Password)) != 1 {
+		return "", false
+	}
+	return account, true
+}
+
+// authRequired 是否配置了用户，配置后客户端必须认证
+func authRequired() bool {
+	return len(common.Current().Auth.Users) > 0
+}
+
+// acquire 检查用户的限额，未超出时占用一个连接并立即计入一次请求，连接结束时由 release 释放
+// 请求数在开始时计入，同时打开的连接也受每日请求数限制；流量限额只在连接开始时检查，已经建立的隧道不会被中断
+func (t *usageTracker) acquire(user string) error {
+	if user == "" {
+		return nil
+	}
+	quota := common.Current().Auth.Users[user]
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if total, ok := t.totals[usageKey{user, today()}]; ok {
+		if quota.RequestsPerDay > 0 && total.Requests >= int64(quota.RequestsPerDay) {
+			return errRequestQuota
+		}
+		if quota.BytesPerDay > 0 && total.BytesIn+total.BytesOut >= int64(quota.BytesPerDay)*1024*1024 {
+			return errBytesQuota
+		}
+	}
+	if quota.MaxConnections > 0 && t.active[user] >= quota.MaxConnections {
+		return errConnectionQuota
+	}
+
+	t.active[user]++
+	t.add(user, 1, 0, 0)
+	return nil
+}
+
+// release 释放连接，并将连接的流量计入用户当天的用量
+func (t *usageTracker) release(user string, bytesIn, bytesOut int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.active[user]--; t.active[user] <= 0 {
+		delete(t.active, user)
+	}
+	t.add(user, 0, bytesIn, bytesOut)
+}
+
+// add 将用量同时计入当天的累计值与尚未写入的增量，调用方需持有 mu
+func (t *usageTracker) add(user string, requests, bytesIn, bytesOut int64) {
+	key := usageKey{user, today()}
+	for _, m := range []map[usageKey]*database.UserUsage{t.totals, t.pending} {
+		u, ok := m[key]
+		if !ok {
+			u = &database.UserUsage{User: user, Day: key.day}
+			m[key] = u
+		}
+		u.Requests += requests
+		u.BytesIn += bytesIn
+		u.BytesOut += bytesOut
+	}
+}
+
+// load 启动时从数据库读取当天已有的用量，限额在重启后继续生效
+func (t *usageTracker) load(ps database.Storage) error {
+	day := today()
+	records, err := ps.GetUsage(day)
+	if err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, record := range records {
+		if record.Day != day {
+			continue
+		}
+		u := record
+		t.totals[usageKey{u.User, day}] = &u
+	}
+	return nil
+}
+
+// flush 将尚未写入的增量写入数据库，写入失败时保留增量等待下次写入，并清理前一天的累计用量
+func (t *usageTracker) flush(ps database.Storage) error {
+	t.mu.Lock()
+	pending := t.pending
+	t.pending = make(map[usageKey]*database.UserUsage)
+	day := today()
+	for key := range t.totals {
+		if key.day != day {
+			delete(t.totals, key)
+		}
+	}
+	t.mu.Unlock()
+
+	records := make([]database.UserUsage, 0, len(pending))
+	for _, u := range pending {
+		records = append(records, *u)
+	}
+	if err := ps.AddUsage(records); err != nil {
+		t.mu.Lock()
+		for key, u := range pending {
+			if current, ok := t.pending[key]; ok {
+				u.Requests += current.Requests
+				u.BytesIn += current.BytesIn
+				u.BytesOut += current.BytesOut
+			}
+			t.pending[key] = u
+		}
+		t.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// activeConns 返回各用户的活动连接数
+func (t *usageTracker) activeConns() map[string]int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	active := make(map[string]int, len(t.active))
+	for user, n := range t.active {
+		active[user] = n
+	}
+	return active
+}
+
+// flushUsage 定时将用户用量写入数据库，退出时由 drain 写入剩余的用量
+func flushUsage(ps database.Storage, stop <-chan struct{}) {
+	ticker := time.NewTicker(usageFlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if err := usage.flush(ps); err != nil {
+				serverLog.Error("写入用户用量失败", "error", err)
+			}
+		}
+	}
+}
+
+// authenticate 认证客户端，失败时记录 407 状态
+func (s *session) authenticate(username, password string) bool {
+	s.user = username
+	account, ok := authenticate(username, password)
+	if !ok {
+		s.status, s.errorClass = http.StatusProxyAuthRequired, errorClassUnauthorized
+		s.log.Info("客户端认证失败", "user", username)
+		return false
+	}
+	s.account = account
+	return true
+}
+
+// acquireQuota 检查用户的限额并占用一个连接，超出限额时记录 429 状态，通过后连接结束时计入用量
+func (s *session) acquireQuota() bool {
+	if err := usage.acquire(s.account); err != nil {
+		s.status, s.errorClass = http.StatusTooManyRequests, errorClassQuotaExceeded
+		s.log.Info("拒绝超出限额的请求", "user", s.account, "error", err)
+		return false
+	}
+	s.accounted = s.account != ""
+	return true
+}
+
+// refuseHTTP 向 HTTP 客户端返回拒绝请求的响应，状态码为 407 时要求客户端使用 Basic 认证
+func refuseHTTP(s *session) {
+	message := http.StatusText(s.status) + "\n"
+	response := &http.Response{
+		StatusCode:    s.status,
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        make(http.Header),
+		Body:          io.NopCloser(strings.NewReader(message)),
+		ContentLength: int64(len(message)),
+		Close:         true,
+	}
+	response.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if s.status == http.StatusProxyAuthRequired {
+		response.Header.Set("Proxy-Authenticate", fmt.Sprintf("Basic realm=%q", authRealm))
+	}
+	response.Write(s.conn)
+}
+
+// releaseUsage 释放连接占用的限额并计入流量，请求数已在 acquireQuota 时计入
+func (s *session) releaseUsage() {
+	if !s.accounted {
+		return
+	}
+	usage.release(s.account, s.conn.read.Load(), s.conn.written.Load())
+}
+
+// usageReport 用户用量的汇总，管理接口与 usage 子命令共用
+type usageReport struct {
+	Users []userUsageReport    `json:"users"`
+	Daily []database.UserUsage `json:"daily"`
+}
+
+// userUsageReport 一个用户当天的用量与限额
+type userUsageReport struct {
+	User              string `json:"user"`
+	Configured        bool   `json:"configured"` // 是否是配置文件中的用户，已从配置中删除的用户只有历史用量
+	ActiveConnections int    `json:"active_connections"`
+	Requests          int64  `json:"requests"`
+	BytesIn           int64  `json:"bytes_in"`
+	BytesOut          int64  `json:"bytes_out"`
+	RequestsPerDay    int    `json:"requests_per_day"`
+	BytesPerDay       int64  `json:"bytes_per_day"` // 单位字节
+	MaxConnections    int    `json:"max_connections"`
+}
+
+// buildUsageReport 汇总最近 days 天的用量，active 为各用户的活动连接数
+func buildUsageReport(ps database.Storage, days int, active map[string]int) (usageReport, error) {
+	day := today()
+	since := time.Now().AddDate(0, 0, 1-days).Format(usageDayLayout)
+	daily, err := ps.GetUsage(since)
+	if err != nil {
+		return usageReport{}, err
+	}
+
+	users := make(map[string]*userUsageReport)
+	user := func(name string) *userUsageReport {
+		if _, ok := users[name]; !ok {
+			users[name] = &userUsageReport{User: name}
+		}
+		return users[name]
+	}
+	for name, quota := range common.Current().Auth.Users {
+		u := user(name)
+		u.Configured = true
+		u.RequestsPerDay, u.BytesPerDay, u.MaxConnections = quota.RequestsPerDay, int64(quota.BytesPerDay)*1024*1024, quota.MaxConnections
+	}
+	for name, n := range active {
+		user(name).ActiveConnections = n
+	}
+	for _, record := range daily {
+		if record.Day == day {
+			u := user(record.User)
+			u.Requests, u.BytesIn, u.BytesOut = record.Requests, record.BytesIn, record.BytesOut
+		}
+	}
+
+	report := usageReport{Users: make([]userUsageReport, 0, len(users)), Daily: daily}
+	for _, u := range users {
+		report.Users = append(report.Users, *u)
+	}
+	sort.Slice(report.Users, func(i, j int) bool { return report.Users[i].User < report.Users[j].User })
+	if report.Daily == nil {
+		report.Daily = []database.UserUsage{}
+	}
+	return report, nil
+}
+
+// usage 返回各用户当天的用量、限额与活动连接数，以及最近 days 天（默认 7 天）每天的用量
+func (api *adminAPI) usage(w http.ResponseWriter, r *http.Request) {
+	days, err := optionalInt(r.URL.Query().Get("days"), defaultUsageDays)
+	if err != nil || days <= 0 {
+		writeError(w, http.StatusBadRequest, "days 不合法")
+		return
+	}
+
+	// 先写入内存中尚未写入的用量，保证返回最新的数据
+	if err := usage.flush(api.ps); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	report, err := buildUsageReport(api.ps, days, usage.activeConns())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, report)
+}
+
+// RunUsage 输出各用户当天的用量与限额，以及最近几天每天的用量
+// 运行中的服务每 30 秒写入一次用量，因此可能缺少最近的少量用量
+func RunUsage(args []string) error {
+	flags := newFlagSet("usage")
+	days := flags.Int("days", defaultUsageDays, "输出最近几天每天的用量")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *days <= 0 {
+		return errors.New("-days 必须大于 0")
+	}
+
+	ps, err := openStorage()
+	if err != nil {
+		return fmt.Errorf("打开数据库失败: %w", err)
+	}
+	defer ps.Close()
+
+	report, err := buildUsageReport(ps, *days, nil)
+	if err != nil {
+		return fmt.Errorf("读取用量失败: %w", err)
+	}
+
+	limit := func(value int64, format func(int64) string) string {
+		if value <= 0 {
+			return "不限"
+		}
+		return format(value)
+	}
+	count := func(n int64) string { return strconv.FormatInt(n, 10) }
+
+	fmt.Printf("今天（%s）:\n", today())
+	fmt.Printf("  %-16s %10s %10s %12s %12s %12s\n", "用户", "请求数", "请求限额", "流量", "流量限额", "连接数限额")
+	for _, u := range report.Users {
+		fmt.Printf("  %-16s %10d %10s %12s %12s %12s\n", u.User, u.Requests,
+			limit(int64(u.RequestsPerDay), count), formatBytes(u.BytesIn+u.BytesOut),
+			limit(u.BytesPerDay, formatBytes), limit(int64(u.MaxConnections), count))
+	}
+
+	fmt.Printf("最近 %d 天:\n", *days)
+	fmt.Printf("  %-10s %-16s %10s %12s %12s\n", "日期", "用户", "请求数", "上行", "下行")
+	for _, u := range report.Daily {
+		fmt.Printf("  %-10s %-16s %10d %12s %12s\n", u.Day, u.User, u.Requests, formatBytes(u.BytesIn), formatBytes(u.BytesOut))
+	}
+	return nil
+}
+
+// formatBytes 将字节数格式化为便于阅读的单位
+func formatBytes(n int64) string {
+	switch {
+	case n >= 1<<30:
+		return fmt.Sprintf("%.1f GB", float64(n)/(1<<30))
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1f MB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.1f KB", float64(n)/(1<<10))
+	default:
+		return fmt.Sprintf("%d B", n)
+	}
+}
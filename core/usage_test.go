@@ -0,0 +1,84 @@
+package core
+
+import (
+	"errors"
+	"proxychain/common"
+	"proxychain/database"
+	"testing"
+)
+
+// setTestConfig 修改当前配置，测试结束时恢复原来的配置
+func setTestConfig(t *testing.T, modify func(cfg *common.Config)) {
+	t.Helper()
+
+	previous := *common.Current()
+	cfg := previous
+	modify(&cfg)
+	common.SetConfig(cfg)
+	t.Cleanup(func() { common.SetConfig(previous) })
+}
+
+// setTestUsers 替换配置中的用户
+func setTestUsers(t *testing.T, users map[string]common.User) {
+	t.Helper()
+	setTestConfig(t, func(cfg *common.Config) { cfg.Auth.Users = users })
+}
+
+func newTestUsageTracker() *usageTracker {
+	return &usageTracker{
+		totals:  make(map[usageKey]*database.UserUsage),
+		pending: make(map[usageKey]*database.UserUsage),
+		active:  make(map[string]int),
+	}
+}
+
+// TestUsageRequestQuotaConcurrent 同时打开的连接在结束之前就计入请求数，不能绕过每日请求数限额
+func TestUsageRequestQuotaConcurrent(t *testing.T) {
+	setTestUsers(t, map[string]common.User{"alice": {Password: "secret", RequestsPerDay: 2}})
+	tracker := newTestUsageTracker()
+
+	for i := 0; i < 2; i++ {
+		if err := tracker.acquire("alice"); err != nil {
+			t.Fatalf("第 %d 个连接被拒绝: %v", i+1, err)
+		}
+	}
+	if err := tracker.acquire("alice"); !errors.Is(err, errRequestQuota) {
+		t.Fatalf("第 3 个连接返回 %v，期望 %v", err, errRequestQuota)
+	}
+
+	tracker.release("alice", 100, 200)
+	tracker.release("alice", 1, 2)
+	total := tracker.totals[usageKey{"alice", today()}]
+	if total.Requests != 2 || total.BytesIn != 101 || total.BytesOut != 202 {
+		t.Errorf("用量为 %+v，期望 2 次请求、101 字节上行、202 字节下行", *total)
+	}
+	if n := len(tracker.active); n != 0 {
+		t.Errorf("释放后仍有 %d 个用户的活动连接", n)
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	setTestUsers(t, nil)
+	if account, ok := authenticate("anyone+premium", ""); !ok || account != "" {
+		t.Errorf("没有配置用户时返回 %q, %v，期望不统计用量并放行", account, ok)
+	}
+
+	setTestUsers(t, map[string]common.User{"alice": {Password: "secret"}})
+	tests := []struct {
+		username, password string
+		account            string
+		ok                 bool
+	}{
+		{"alice", "secret", "alice", true},
+		{"alice+premium", "secret", "alice", true},
+		{"alice", "wrong", "", false},
+		{"bob", "secret", "", false},
+		{"", "", "", false},
+	}
+	for _, tt := range tests {
+		account, ok := authenticate(tt.username, tt.password)
+		if account != tt.account || ok != tt.ok {
+			t.Errorf("authenticate(%q, %q) = %q, %v，期望 %q, %v", tt.username, tt.password, account, ok, tt.account, tt.ok)
+		}
+	}
+}
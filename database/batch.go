@@ -0,0 +1,228 @@
+package database
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// maxBufferedRecordsFactor 写入失败时最多保留 maxPending 的多少倍检测历史，超出部分丢弃
+const maxBufferedRecordsFactor = 10
+
+// BatchedStorage 在内存中累积优先级变化与检测历史，按时间间隔或数量阈值批量写入底层存储
+// 其余操作直接转发给底层存储
+type BatchedStorage struct {
+	Storage
+
+	interval   time.Duration
+	maxPending int
+
+	mu      sync.Mutex
+	deltas  map[string]*PriorityDelta // 以 ip:port 为键
+	records []CheckRecord
+
+	flushCh   chan struct{}
+	done      chan struct{}
+	wg        sync.WaitGroup
+	closeOnce sync.Once
+}
+
+// NewBatchedStorage 包装底层存储并启动后台刷新协程
+func NewBatchedStorage(storage Storage, interval time.Duration, maxPending int) *BatchedStorage {
+	bs := &BatchedStorage{
+		Storage:    storage,
+		interval:   interval,
+		maxPending: maxPending,
+		deltas:     make(map[string]*PriorityDelta),
+		flushCh:    make(chan struct{}, 1),
+		done:       make(chan struct{}),
+	}
+
+	bs.wg.Add(1)
+	go bs.loop()
+
+	return bs
+}
+
+// IncreasePriority 累积优先级增加值，稍后批量写入
+func (bs *BatchedStorage) IncreasePriority(ip string, port int, reward int) error {
+	bs.addDelta(ip, port, reward)
+	return nil
+}
+
+// DecreasePriority 累积优先级扣减值，稍后批量写入
+func (bs *BatchedStorage) DecreasePriority(ip string, port int, penalty int) error {
+	bs.addDelta(ip, port, -penalty)
+	return nil
+}
+
+// RecordCheck 缓存检测历史，稍后批量写入
+func (bs *BatchedStorage) RecordCheck(record CheckRecord) error {
+	if record.CheckedAt.IsZero() {
+		record.CheckedAt = time.Now()
+	}
+
+	bs.mu.Lock()
+	bs.records = append(bs.records, record)
+	bs.mu.Unlock()
+
+	bs.triggerIfFull()
+	return nil
+}
+
+// QuarantineLowPriorityProxies 先写入累积的优先级变化，再隔离优先级降到下限的代理
+func (bs *BatchedStorage) QuarantineLowPriorityProxies() (int64, error) {
+	if err := bs.Flush(); err != nil {
+		return 0, err
+	}
+	return bs.Storage.QuarantineLowPriorityProxies()
+}
+
+// RestoreProxy 先写入累积的优先级变化，避免恢复后的可信度被旧的扣减覆盖
+func (bs *BatchedStorage) RestoreProxy(ip string, port int, priority int) (int64, error) {
+	if err := bs.Flush(); err != nil {
+		return 0, err
+	}
+	return bs.Storage.RestoreProxy(ip, port, priority)
+}
+
+// QuarantineProxy 先写入累积的优先级变化，再将代理移入隔离区
+func (bs *BatchedStorage) QuarantineProxy(ip string, port int, reason string) (int64, error) {
+	if err := bs.Flush(); err != nil {
+		return 0, err
+	}
+	return bs.Storage.QuarantineProxy(ip, port, reason)
+}
+
+// ExportProxies 先写入累积的变化，保证导出的可信度是最新的
+func (bs *BatchedStorage) ExportProxies() ([]ProxyRecord, error) {
+	if err := bs.Flush(); err != nil {
+		return nil, err
+	}
+	return bs.Storage.ExportProxies()
+}
+
+// ImportProxies 先写入累积的变化，避免导入的可信度被旧的变化覆盖
+func (bs *BatchedStorage) ImportProxies(records []ProxyRecord, strategy string) (int, error) {
+	if err := bs.Flush(); err != nil {
+		return 0, err
+	}
+	return bs.Storage.ImportProxies(records, strategy)
+}
+
+// Flush 立即将累积的变化写入底层存储，失败时变化会保留到下一次刷新
+func (bs *BatchedStorage) Flush() error {
+	bs.mu.Lock()
+	if len(bs.deltas) == 0 && len(bs.records) == 0 {
+		bs.mu.Unlock()
+		return nil
+	}
+
+	deltas := make([]PriorityDelta, 0, len(bs.deltas))
+	for _, delta := range bs.deltas {
+		deltas = append(deltas, *delta)
+	}
+	records := bs.records
+	bs.deltas = make(map[string]*PriorityDelta)
+	bs.records = nil
+	bs.mu.Unlock()
+
+	err := bs.Storage.ApplyBatch(deltas, records)
+	if err != nil {
+		bs.restore(deltas, records)
+		return fmt.Errorf("批量写入 %d 条优先级变化与 %d 条检测历史失败: %w", len(deltas), len(records), err)
+	}
+
+	return nil
+}
+
+// Close 停止后台刷新，写入剩余的变化后关闭底层存储
+func (bs *BatchedStorage) Close() error {
+	var err error
+	bs.closeOnce.Do(func() {
+		close(bs.done)
+		bs.wg.Wait()
+
+		if flushErr := bs.Flush(); flushErr != nil {
+			storageLog.Error("关闭存储前写入剩余变化失败", "error", flushErr)
+			err = flushErr
+		}
+		if closeErr := bs.Storage.Close(); closeErr != nil {
+			err = closeErr
+		}
+	})
+	return err
+}
+
+// loop 按时间间隔或收到数量阈值通知时刷新
+func (bs *BatchedStorage) loop() {
+	defer bs.wg.Done()
+
+	ticker := time.NewTicker(bs.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-bs.done:
+			return
+		case <-ticker.C:
+		case <-bs.flushCh:
+		}
+
+		if err := bs.Flush(); err != nil {
+			storageLog.Error("批量写入失败", "error", err)
+		}
+	}
+}
+
+// addDelta 将优先级变化合并到同一代理的累积值中
+func (bs *BatchedStorage) addDelta(ip string, port int, delta int) {
+	key := fmt.Sprintf("%s:%d", ip, port)
+
+	bs.mu.Lock()
+	pending, ok := bs.deltas[key]
+	if !ok {
+		pending = &PriorityDelta{IP: ip, Port: port}
+		bs.deltas[key] = pending
+	}
+	pending.Delta += delta
+	pending.LastChecked = time.Now()
+	bs.mu.Unlock()
+
+	bs.triggerIfFull()
+}
+
+// triggerIfFull 累积数量达到阈值时通知后台协程立即刷新
+func (bs *BatchedStorage) triggerIfFull() {
+	bs.mu.Lock()
+	full := len(bs.deltas)+len(bs.records) >= bs.maxPending
+	bs.mu.Unlock()
+
+	if full {
+		select {
+		case bs.flushCh <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// restore 写入失败后将变化放回缓冲区，检测历史超出上限时丢弃最旧的部分
+func (bs *BatchedStorage) restore(deltas []PriorityDelta, records []CheckRecord) {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+
+	for _, delta := range deltas {
+		key := fmt.Sprintf("%s:%d", delta.IP, delta.Port)
+		if pending, ok := bs.deltas[key]; ok {
+			pending.Delta += delta.Delta
+			continue
+		}
+		restored := delta
+		bs.deltas[key] = &restored
+	}
+
+	bs.records = append(records, bs.records...)
+	if limit := bs.maxPending * maxBufferedRecordsFactor; len(bs.records) > limit {
+		bs.records = bs.records[len(bs.records)-limit:]
+	}
+}
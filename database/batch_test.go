@@ -0,0 +1,133 @@
+package database
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3" // 导入 SQLite 驱动
+)
+
+// benchmarkProxies 基准测试中轮流调整优先级的代理数量
+const benchmarkProxies = 100
+
+// newTestSQLite 在临时目录中创建 WAL 模式的 SQLite 数据库并写入 n 个代理
+func newTestSQLite(tb testing.TB, n int) (*ProxyStorage, string) {
+	tb.Helper()
+
+	path := filepath.Join(tb.TempDir(), "proxychain.db")
+	ps, err := NewProxyStorage(path)
+	if err != nil {
+		tb.Fatalf("打开数据库失败: %v", err)
+	}
+
+	var mode string
+	if err := ps.db.QueryRow("PRAGMA journal_mode").Scan(&mode); err != nil {
+		tb.Fatalf("查询日志模式失败: %v", err)
+	}
+	if !strings.EqualFold(mode, "wal") {
+		tb.Fatalf("日志模式为 %q，期望 wal", mode)
+	}
+
+	for i := 0; i < n; i++ {
+		if err := ps.UpsertProxy(testIP(i), 8080, "http", "中国", "", ""); err != nil {
+			tb.Fatalf("写入代理失败: %v", err)
+		}
+	}
+	return ps, path
+}
+
+func testIP(i int) string {
+	return fmt.Sprintf("10.0.%d.%d", i/256, i%256)
+}
+
+// adjustAlternately 交替增加与降低第 i 个代理的优先级
+func adjustAlternately(tb testing.TB, s Storage, i int) {
+	ip := testIP(i % benchmarkProxies)
+	var err error
+	if i%2 == 0 {
+		err = s.IncreasePriority(ip, 8080, 2)
+	} else {
+		err = s.DecreasePriority(ip, 8080, 1)
+	}
+	if err != nil {
+		tb.Fatalf("调整优先级失败: %v", err)
+	}
+}
+
+func BenchmarkPriorityUpdates(b *testing.B) {
+	b.Run("direct", func(b *testing.B) {
+		ps, _ := newTestSQLite(b, benchmarkProxies)
+		defer ps.Close()
+
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			adjustAlternately(b, ps, i)
+		}
+	})
+
+	b.Run("batched", func(b *testing.B) {
+		ps, _ := newTestSQLite(b, benchmarkProxies)
+		bs := NewBatchedStorage(ps, time.Second, 1000)
+		defer bs.Close()
+
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			adjustAlternately(b, bs, i)
+		}
+		// 计入最后一次写入的耗时，与直接写入的结果可比
+		if err := bs.Flush(); err != nil {
+			b.Fatalf("批量写入失败: %v", err)
+		}
+	})
+}
+
+func TestBatchedStorageCloseFlushesPending(t *testing.T) {
+	ps, path := newTestSQLite(t, 2)
+	// 间隔与阈值足够大，保证关闭之前不会自动写入
+	bs := NewBatchedStorage(ps, time.Hour, 1000)
+
+	bs.IncreasePriority(testIP(0), 8080, 5)
+	bs.IncreasePriority(testIP(0), 8080, 5)
+	bs.DecreasePriority(testIP(1), 8080, 30)
+	bs.RecordCheck(CheckRecord{IP: testIP(0), Port: 8080, Source: SourceCheck, Success: true, Latency: 120 * time.Millisecond})
+	bs.RecordCheck(CheckRecord{IP: testIP(1), Port: 8080, Source: SourceTraffic, Target: "example.com:443", ErrorClass: "timeout"})
+
+	if err := bs.Close(); err != nil {
+		t.Fatalf("关闭失败: %v", err)
+	}
+
+	reopened, err := NewProxyStorage(path)
+	if err != nil {
+		t.Fatalf("重新打开数据库失败: %v", err)
+	}
+	defer reopened.Close()
+
+	records, err := reopened.ExportProxies()
+	if err != nil {
+		t.Fatalf("导出代理失败: %v", err)
+	}
+	priorities := make(map[string]int)
+	for _, record := range records {
+		priorities[record.IP] = record.Priority
+	}
+	if got, want := priorities[testIP(0)], 110; got != want {
+		t.Errorf("%s 的优先级为 %d，期望 %d", testIP(0), got, want)
+	}
+	if got, want := priorities[testIP(1)], 70; got != want {
+		t.Errorf("%s 的优先级为 %d，期望 %d", testIP(1), got, want)
+	}
+
+	history, err := reopened.GetHistory(time.Now().Add(-time.Hour))
+	if err != nil {
+		t.Fatalf("读取检测历史失败: %v", err)
+	}
+	if len(history) != 2 {
+		t.Fatalf("检测历史有 %d 条，期望 2 条", len(history))
+	}
+	if history[1].Target != "example.com:443" || history[1].ErrorClass != "timeout" {
+		t.Errorf("检测历史内容不一致: %+v", history[1])
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"database/sql"
 	"fmt"
 	"proxychain/common"
+	"strings"
 	"time"
 )
 
@@ -23,41 +24,48 @@ var (
 			last_checked DATETIME
 		);
 	`
-	insertProxyQuery = `
+	upsertProxyQuery = `
 		INSERT INTO proxies (ip, port, protocol, country, province, city, priority, last_checked)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?);
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (ip, port, protocol) DO UPDATE
+		SET country = excluded.country, province = excluded.province, city = excluded.city,
+			last_checked = excluded.last_checked;
 	`
-	updatePriorityQuery = `
+	// 可信度的变化限制在评分区间内，%s 为各数据库的限制表达式
+	adjustPriorityQuery = `
 		UPDATE proxies
-		SET priority = priority - ?, last_checked = ?
+		SET priority = %s, last_checked = ?
 		WHERE ip = ? AND port = ?;
 	`
-	increasePriorityQuery = `
+	// 偏离中性值的部分按比例衰减，向零取整保证分数最终回到中性值
+	// 评分区间之前的数据库中可信度没有上限，打开数据库与修改评分区间时限制在区间内，%s 为各数据库的限制表达式
+	clampPrioritiesQuery = `
 		UPDATE proxies
-		SET priority = priority + ?, last_checked = ?
-		WHERE ip = ? AND port = ?;
+		SET priority = %s
+		WHERE priority < ? OR priority > ?;
+	`
+	decayPrioritiesQuery = `
+		UPDATE proxies
+		SET priority = %s
+		WHERE is_active AND priority != ?;
 	`
 	getActiveProxiesQuery = `
 		SELECT ip, port, protocol, country, province, city
 		FROM proxies
-		WHERE is_active = 1
+		WHERE is_active
 		ORDER BY priority DESC;
 	`
-	deleteLowPriorityProxiesQuery = `
-		DELETE FROM proxies
-		WHERE priority < 0;
-	`
 	getProxyCountQuery = `
-		SELECT COUNT(*) FROM proxies;
+		SELECT COUNT(*) FROM proxies WHERE is_active;
 	`
-	proxyExistsQuery = `
-		SELECT COUNT(*)
-		FROM proxies
+	updateThroughputQuery = `
+		UPDATE proxies
+		SET bandwidth = ?
 		WHERE ip = ? AND port = ?;
 	`
-	updateProxyQuery = `
+	updateUDPSupportQuery = `
 		UPDATE proxies
-		SET country = ?, province = ?, city = ?, last_checked = ?
+		SET udp = ?
 		WHERE ip = ? AND port = ?;
 	`
 
@@ -77,39 +85,98 @@ var (
 	`
 )
 
-// NewProxyStorage 初始化数据库并创建表
+// NewProxyStorage 打开 SQLite 数据库并将表结构迁移到最新版本
+// 数据库使用 WAL 模式，读操作不会被写操作阻塞
 func NewProxyStorage(dbPath string) (*ProxyStorage, error) {
-	db, err := sql.Open("sqlite3", dbPath)
+	return openSQLStorage(sqliteDialect, sqliteDSN(dbPath))
+}
+
+// sqliteDSN 为数据库路径追加连接参数：启用 WAL 并在数据库繁忙时等待而不是立即报错
+func sqliteDSN(dbPath string) string {
+	separator := "?"
+	if strings.Contains(dbPath, "?") {
+		separator = "&"
+	}
+	return dbPath + separator + "_journal_mode=WAL&_busy_timeout=5000"
+}
+
+// openSQLStorage 使用指定的方言打开数据库并执行迁移
+func openSQLStorage(d *dialect, dataSource string) (*ProxyStorage, error) {
+	db, err := sql.Open(d.driver, dataSource)
 	if err != nil {
 		return nil, err
 	}
 
-	if _, err = db.Exec(createTableQuery); err != nil {
+	if err = migrate(db, d); err != nil {
+		db.Close()
 		return nil, err
 	}
 
-	if _, err = db.Exec(createHighPriorityProxyTableQuery); err != nil {
-		return nil, err
+	ps := &ProxyStorage{db: db, dialect: d}
+	if _, err := ps.ClampPriorities(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("将可信度限制在评分区间内失败: %w", err)
+	}
+	return ps, nil
+}
+
+// ClampPriorities 将超出评分区间的可信度限制在区间内，返回受影响的代理数量
+// 旧版本的数据库与修改了 score.min、score.max 的数据库中可能存在区间外的值，不处理时会一直排在最前或最后
+func (ps *ProxyStorage) ClampPriorities() (int64, error) {
+	model := common.CurrentScoreModel()
+	query := fmt.Sprintf(clampPrioritiesQuery, ps.dialect.clamp("priority"))
+	result, err := ps.exec(query, model.Min, model.Max, model.Min, model.Max)
+	if err != nil {
+		return 0, err
 	}
 
-	return &ProxyStorage{db: db}, nil
+	clamped, err := result.RowsAffected()
+	if clamped > 0 {
+		storageLog.Info("已将超出评分区间的可信度限制在区间内", "proxies", clamped, "min", model.Min, "max", model.Max)
+	}
+	return clamped, err
 }
 
-// InsertProxy 插入新的代理信息，并设置默认优先级
-func (ps *ProxyStorage) InsertProxy(ip string, port int, protocol, country, province, city string) error {
-	_, err := ps.db.Exec(insertProxyQuery, ip, port, protocol, country, province, city, 100, time.Now())
+// UpsertProxy 插入新的代理并将优先级设为评分模型的中性值，代理已存在时只更新位置信息
+func (ps *ProxyStorage) UpsertProxy(ip string, port int, protocol, country, province, city string) error {
+	model := common.CurrentScoreModel()
+	_, err := ps.exec(upsertProxyQuery, ip, port, protocol, country, province, city, model.Neutral, time.Now())
 	return err
 }
 
-// DecreasePriority 降低代理的优先级
-func (ps *ProxyStorage) DecreasePriority(ip string, port int) error {
-	_, err := ps.db.Exec(updatePriorityQuery, common.GlobalConfig.Config.PriorityDownNum, time.Now(), ip, port)
+// DecreasePriority 按指定的扣减值降低代理的优先级，不低于评分下限
+func (ps *ProxyStorage) DecreasePriority(ip string, port int, penalty int) error {
+	return ps.adjustPriority(ip, port, -penalty, time.Now())
+}
+
+// adjustPriority 调整代理的优先级并限制在评分区间内
+func (ps *ProxyStorage) adjustPriority(ip string, port int, delta int, lastChecked time.Time) error {
+	model := common.CurrentScoreModel()
+	_, err := ps.exec(ps.adjustPriorityQuery(), delta, model.Min, model.Max, lastChecked, ip, port)
 	return err
 }
 
+// adjustPriorityQuery 返回当前数据库的可信度调整语句
+func (ps *ProxyStorage) adjustPriorityQuery() string {
+	return fmt.Sprintf(adjustPriorityQuery, ps.dialect.clamp("priority + ?"))
+}
+
+// DecayPriorities 将所有可用代理偏离中性值的部分乘以 factor，返回受影响的代理数量
+func (ps *ProxyStorage) DecayPriorities(factor float64) (int64, error) {
+	model := common.CurrentScoreModel()
+	expr := "? + " + ps.dialect.trunc("(priority - ?) * CAST(? AS DOUBLE PRECISION)")
+	query := fmt.Sprintf(decayPrioritiesQuery, ps.dialect.clamp(expr))
+
+	result, err := ps.exec(query, model.Neutral, model.Neutral, factor, model.Min, model.Max, model.Neutral)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
 // GetActiveProxiesByPriority 按优先级获取所有可用的代理
 func (ps *ProxyStorage) GetActiveProxiesByPriority() ([]common.ProxyBase, error) {
-	rows, err := ps.db.Query(getActiveProxiesQuery)
+	rows, err := ps.query(getActiveProxiesQuery)
 	if err != nil {
 		return nil, err
 	}
@@ -129,22 +196,16 @@ func (ps *ProxyStorage) GetActiveProxiesByPriority() ([]common.ProxyBase, error)
 	return proxies, nil
 }
 
-// DeleteLowPriorityProxies 删除优先级低于0的代理
-func (ps *ProxyStorage) DeleteLowPriorityProxies() error {
-	_, err := ps.db.Exec(deleteLowPriorityProxiesQuery)
-	return err
-}
-
-// GetRandomProxies 随机从数据库中取出指定数量的代理
-func (ps *ProxyStorage) GetRandomProxies(limit int) ([]string, error) {
+// GetRandomProxies 随机从数据库中取出指定数量的代理，带宽低于 minBandwidth（字节每秒）的代理会被过滤，未测速的代理不受影响
+func (ps *ProxyStorage) GetRandomProxies(limit int, minBandwidth float64) ([]string, error) {
 	query := `
 		SELECT ip, port, protocol
 		FROM proxies
-		WHERE is_active = 1
+		WHERE is_active AND (bandwidth IS NULL OR bandwidth >= ?)
 		ORDER BY RANDOM()
 		LIMIT ?;
 	`
-	rows, err := ps.db.Query(query, limit)
+	rows, err := ps.query(query, minBandwidth, limit)
 	if err != nil {
 		return nil, err
 	}
@@ -165,16 +226,16 @@ func (ps *ProxyStorage) GetRandomProxies(limit int) ([]string, error) {
 	return proxies, nil
 }
 
-// GetActiveProxiesByPriorityLimit 获取按优先级排序的代理，最多获取指定数量
-func (ps *ProxyStorage) GetActiveProxiesByPriorityLimit(limit int) ([]string, error) {
+// GetActiveProxiesByPriorityLimit 获取按优先级排序的代理，最多获取指定数量，并过滤带宽不足的代理
+func (ps *ProxyStorage) GetActiveProxiesByPriorityLimit(limit int, minBandwidth float64) ([]string, error) {
 	query := `
 		SELECT ip, port, protocol
 		FROM proxies
-		WHERE is_active = 1
+		WHERE is_active AND (bandwidth IS NULL OR bandwidth >= ?)
 		ORDER BY priority DESC
 		LIMIT ?;
 	`
-	rows, err := ps.db.Query(query, limit)
+	rows, err := ps.query(query, minBandwidth, limit)
 	if err != nil {
 		return nil, err
 	}
@@ -195,47 +256,118 @@ func (ps *ProxyStorage) GetActiveProxiesByPriorityLimit(limit int) ([]string, er
 	return proxies, nil
 }
 
-// IncreasePriority 增加代理的优先级
-func (ps *ProxyStorage) IncreasePriority(ip string, port int) error {
-	_, err := ps.db.Exec(increasePriorityQuery, common.GlobalConfig.Config.PriorityUpNum, time.Now(), ip, port)
+// IncreasePriority 按指定的增加值提高代理的优先级，不超过评分上限
+func (ps *ProxyStorage) IncreasePriority(ip string, port int, reward int) error {
+	return ps.adjustPriority(ip, port, reward, time.Now())
+}
+
+// UpdateThroughput 更新代理测得的带宽，单位字节每秒，nil 时记为 NULL 表示未知
+func (ps *ProxyStorage) UpdateThroughput(ip string, port int, bandwidth *float64) error {
+	_, err := ps.exec(updateThroughputQuery, bandwidth, ip, port)
 	return err
 }
 
-// GetProxyCount 获取数据库中代理的数量
-func (ps *ProxyStorage) GetProxyCount() (int, error) {
-	var count int
-	err := ps.db.QueryRow(getProxyCountQuery).Scan(&count)
-	return count, err
+// UpdateUDPSupport 更新代理是否支持 UDP ASSOCIATE
+func (ps *ProxyStorage) UpdateUDPSupport(ip string, port int, supported bool) error {
+	_, err := ps.exec(updateUDPSupportQuery, supported, ip, port)
+	return err
 }
 
-// ProxyExists 检查数据库中是否存在指定的代理
-func (ps *ProxyStorage) ProxyExists(ip string, port int) (bool, error) {
-	var count int
-	err := ps.db.QueryRow(proxyExistsQuery, ip, port).Scan(&count)
+// GetRandomUDPProxies 随机获取检测确认支持 UDP 的 SOCKS5 代理
+func (ps *ProxyStorage) GetRandomUDPProxies(limit int) ([]string, error) {
+	query := `
+		SELECT ip, port, protocol
+		FROM proxies
+		WHERE is_active AND protocol = 'socks5' AND udp
+		ORDER BY RANDOM()
+		LIMIT ?;
+	`
+	rows, err := ps.query(query, limit)
 	if err != nil {
-		return false, err
+		return nil, err
 	}
-	return count > 0, nil
+	defer rows.Close()
+
+	var proxies []string
+	for rows.Next() {
+		var ip, protocol string
+		var port int
+		err := rows.Scan(&ip, &port, &protocol)
+		if err != nil {
+			return nil, err
+		}
+		fullURL := fmt.Sprintf("%s://%s:%d", protocol, ip, port)
+		proxies = append(proxies, fullURL)
+	}
+
+	return proxies, nil
 }
 
-// UpdateProxy 更新现有代理的信息
-func (ps *ProxyStorage) UpdateProxy(ip string, port int, country, province, city string) error {
-	_, err := ps.db.Exec(updateProxyQuery, country, province, city, time.Now(), ip, port)
-	return err
+// ApplyBatch 在一个事务中批量应用优先级变化并写入检测历史
+func (ps *ProxyStorage) ApplyBatch(deltas []PriorityDelta, records []CheckRecord) error {
+	tx, err := ps.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if len(deltas) > 0 {
+		stmt, err := tx.Prepare(ps.dialect.rebind(ps.adjustPriorityQuery()))
+		if err != nil {
+			return err
+		}
+		defer stmt.Close()
+
+		model := common.CurrentScoreModel()
+		for _, delta := range deltas {
+			if _, err := stmt.Exec(delta.Delta, model.Min, model.Max, delta.LastChecked, delta.IP, delta.Port); err != nil {
+				return err
+			}
+		}
+	}
+
+	if len(records) > 0 {
+		stmt, err := tx.Prepare(ps.dialect.rebind(insertHistoryQuery))
+		if err != nil {
+			return err
+		}
+		defer stmt.Close()
+
+		for _, record := range records {
+			if _, err := stmt.Exec(historyArgs(record)...); err != nil {
+				return err
+			}
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Close 关闭数据库连接
+func (ps *ProxyStorage) Close() error {
+	return ps.db.Close()
+}
+
+// GetProxyCount 获取数据库中可用代理的数量，不包含隔离区中的代理
+func (ps *ProxyStorage) GetProxyCount() (int, error) {
+	var count int
+	err := ps.queryRow(getProxyCountQuery).Scan(&count)
+	return count, err
 }
 
-// GetCountryStatistics 获取数据库中 country 为 "中国" 和其他国家的代理数量
+// GetCountryStatistics 获取数据库中 country 为 "中国" 和其他国家的可用代理数量
 func (ps *ProxyStorage) GetCountryStatistics() (int, int, error) {
 	query := `
 		SELECT 
-			SUM(CASE WHEN country = '中国' THEN 1 ELSE 0 END) AS china_count,
-			SUM(CASE WHEN country != '中国' OR country IS NULL THEN 1 ELSE 0 END) AS non_china_count
-		FROM proxies;
+			COALESCE(SUM(CASE WHEN country = '中国' THEN 1 ELSE 0 END), 0) AS china_count,
+			COALESCE(SUM(CASE WHEN country != '中国' OR country IS NULL THEN 1 ELSE 0 END), 0) AS non_china_count
+		FROM proxies
+		WHERE is_active;
 	`
 
 	var chinaCount int
 	var nonChinaCount int
-	err := ps.db.QueryRow(query).Scan(&chinaCount, &nonChinaCount)
+	err := ps.queryRow(query).Scan(&chinaCount, &nonChinaCount)
 	if err != nil {
 		return 0, 0, err
 	}
@@ -243,16 +375,54 @@ func (ps *ProxyStorage) GetCountryStatistics() (int, int, error) {
 	return chinaCount, nonChinaCount, nil
 }
 
-// GetRandomProxiesFromCountry 随机获取指定国家的代理
-func (ps *ProxyStorage) GetRandomProxiesFromCountry(limit int, country string) ([]string, error) {
+// PoolCount 一组国家、协议、可用状态与层级都相同的代理数量
+type PoolCount struct {
+	Country  string // 未知时为空
+	Protocol string
+	Active   bool
+	Premium  bool
+	Count    int
+}
+
+// getPoolCountsQuery 只返回分组后的数量，监控抓取与控制台刷新时无需读取整张代理表
+const getPoolCountsQuery = `
+	SELECT COALESCE(p.country, '') AS country, p.protocol, p.is_active,
+		CASE WHEN h.id IS NULL THEN 0 ELSE 1 END AS premium, COUNT(*)
+	FROM proxies p
+	LEFT JOIN high_proiority_proxies h
+		ON h.ip = p.ip AND h.port = p.port AND h.protocol = p.protocol
+	GROUP BY COALESCE(p.country, ''), p.protocol, p.is_active, CASE WHEN h.id IS NULL THEN 0 ELSE 1 END;
+`
+
+// GetPoolCounts 按国家、协议、是否可用与是否属于高级代理池分组统计代理数量
+func (ps *ProxyStorage) GetPoolCounts() ([]PoolCount, error) {
+	rows, err := ps.query(getPoolCountsQuery)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var counts []PoolCount
+	for rows.Next() {
+		var count PoolCount
+		if err := rows.Scan(&count.Country, &count.Protocol, &count.Active, &count.Premium, &count.Count); err != nil {
+			return nil, err
+		}
+		counts = append(counts, count)
+	}
+	return counts, rows.Err()
+}
+
+// GetRandomProxiesFromCountry 随机获取指定国家的代理，并过滤带宽不足的代理
+func (ps *ProxyStorage) GetRandomProxiesFromCountry(limit int, country string, minBandwidth float64) ([]string, error) {
 	query := `
 		SELECT ip, port, protocol
 		FROM proxies
-		WHERE is_active = 1 AND country = ?
+		WHERE is_active AND country = ? AND (bandwidth IS NULL OR bandwidth >= ?)
 		ORDER BY RANDOM()
 		LIMIT ?;
 	`
-	rows, err := ps.db.Query(query, country, limit)
+	rows, err := ps.query(query, country, minBandwidth, limit)
 	if err != nil {
 		return nil, err
 	}
@@ -273,16 +443,16 @@ func (ps *ProxyStorage) GetRandomProxiesFromCountry(limit int, country string) (
 	return proxies, nil
 }
 
-// GetActiveProxiesByPriorityFromCountry 获取指定国家的按优先级排序的代理
-func (ps *ProxyStorage) GetActiveProxiesByPriorityFromCountry(limit int, country string) ([]string, error) {
+// GetActiveProxiesByPriorityFromCountry 获取指定国家的按优先级排序的代理，并过滤带宽不足的代理
+func (ps *ProxyStorage) GetActiveProxiesByPriorityFromCountry(limit int, country string, minBandwidth float64) ([]string, error) {
 	query := `
 		SELECT ip, port, protocol
 		FROM proxies
-		WHERE is_active = 1 AND country = ?
+		WHERE is_active AND country = ? AND (bandwidth IS NULL OR bandwidth >= ?)
 		ORDER BY priority DESC
 		LIMIT ?;
 	`
-	rows, err := ps.db.Query(query, country, limit)
+	rows, err := ps.query(query, country, minBandwidth, limit)
 	if err != nil {
 		return nil, err
 	}
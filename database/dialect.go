@@ -0,0 +1,104 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// dialect 描述不同 SQL 数据库之间的差异，查询语句统一使用 ? 作为占位符
+type dialect struct {
+	name   string
+	driver string
+
+	// numberedPlaceholders 为 true 时将 ? 改写为 $1、$2 形式的占位符
+	numberedPlaceholders bool
+
+	// lockMigrations 在迁移事务中获取锁，避免多个实例同时迁移，为 nil 时不加锁
+	lockMigrations func(tx *sql.Tx) error
+
+	// 多参数取最大值、最小值的函数名，以及向零取整的表达式格式
+	greatest    string
+	least       string
+	truncFormat string
+}
+
+var (
+	sqliteDialect = &dialect{
+		name:        TypeSQLite,
+		driver:      "sqlite3",
+		greatest:    "MAX",
+		least:       "MIN",
+		truncFormat: "CAST(%s AS INTEGER)",
+	}
+
+	postgresDialect = &dialect{
+		name:                 TypePostgres,
+		driver:               "postgres",
+		numberedPlaceholders: true,
+		lockMigrations: func(tx *sql.Tx) error {
+			_, err := tx.Exec("SELECT pg_advisory_xact_lock($1)", migrationLockID)
+			return err
+		},
+		greatest:    "GREATEST",
+		least:       "LEAST",
+		truncFormat: "TRUNC(%s)",
+	}
+)
+
+// migrationLockID PostgreSQL 迁移使用的咨询锁编号
+const migrationLockID = 7283401
+
+// clamp 返回将表达式限制在两个占位符（下限、上限）之间的 SQL 片段
+func (d *dialect) clamp(expr string) string {
+	return fmt.Sprintf("%s(%s(%s, ?), ?)", d.least, d.greatest, expr)
+}
+
+// trunc 返回将表达式向零取整的 SQL 片段
+func (d *dialect) trunc(expr string) string {
+	return fmt.Sprintf(d.truncFormat, expr)
+}
+
+// rebind 将查询中的 ? 占位符改写为当前数据库使用的形式，字符串字面量中的 ? 不受影响
+func (d *dialect) rebind(query string) string {
+	if !d.numberedPlaceholders || !strings.Contains(query, "?") {
+		return query
+	}
+
+	var b strings.Builder
+	b.Grow(len(query) + 8)
+
+	n := 0
+	inString := false
+	for _, r := range query {
+		switch {
+		case r == '\'':
+			inString = !inString
+			b.WriteRune(r)
+		case r == '?' && !inString:
+			n++
+			b.WriteByte('$')
+			b.WriteString(strconv.Itoa(n))
+		default:
+			b.WriteRune(r)
+		}
+	}
+
+	return b.String()
+}
+
+// exec 在改写占位符后执行语句
+func (ps *ProxyStorage) exec(query string, args ...any) (sql.Result, error) {
+	return ps.db.Exec(ps.dialect.rebind(query), args...)
+}
+
+// query 在改写占位符后执行查询
+func (ps *ProxyStorage) query(query string, args ...any) (*sql.Rows, error) {
+	return ps.db.Query(ps.dialect.rebind(query), args...)
+}
+
+// queryRow 在改写占位符后执行单行查询
+func (ps *ProxyStorage) queryRow(query string, args ...any) *sql.Row {
+	return ps.db.QueryRow(ps.dialect.rebind(query), args...)
+}
@@ -0,0 +1,164 @@
+package database
+
+import (
+	"time"
+)
+
+// 检测历史相关的 SQL 语句
+var (
+	createHistoryTableQuery = `
+		CREATE TABLE IF NOT EXISTS check_history (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			checked_at DATETIME NOT NULL,
+			ip TEXT NOT NULL,
+			port INTEGER NOT NULL,
+			source TEXT NOT NULL,
+			target TEXT,
+			success BOOLEAN NOT NULL,
+			latency_ms INTEGER NOT NULL DEFAULT 0,
+			error_class TEXT
+		);
+	`
+	createHistoryIndexQuery = `
+		CREATE INDEX IF NOT EXISTS idx_check_history_proxy
+		ON check_history (ip, port, checked_at);
+	`
+	insertHistoryQuery = `
+		INSERT INTO check_history (checked_at, ip, port, source, target, success, latency_ms, error_class)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?);
+	`
+	getHistoryQuery = `
+		SELECT checked_at, ip, port, source, target, success, latency_ms, error_class
+		FROM check_history
+		WHERE checked_at >= ?
+		ORDER BY checked_at ASC, id ASC;
+	`
+	pruneHistoryQuery = `
+		DELETE FROM check_history
+		WHERE checked_at < ?;
+	`
+	uptimeStatsQuery = `
+		SELECT ip, port,
+			COUNT(*) AS total,
+			SUM(CASE WHEN success THEN 1 ELSE 0 END) AS successes,
+			AVG(CASE WHEN success THEN latency_ms END) AS avg_latency
+		FROM check_history
+		WHERE checked_at >= ?
+		GROUP BY ip, port
+		ORDER BY CAST(SUM(CASE WHEN success THEN 1 ELSE 0 END) AS REAL) / COUNT(*) ASC, COUNT(*) DESC;
+	`
+	failureBreakdownQuery = `
+		SELECT error_class, COUNT(*)
+		FROM check_history
+		WHERE ip = ? AND port = ? AND checked_at >= ? AND NOT success
+		GROUP BY error_class
+		ORDER BY COUNT(*) DESC;
+	`
+)
+
+// RecordCheck 记录一次健康检测或实际流量的结果
+func (ps *ProxyStorage) RecordCheck(record CheckRecord) error {
+	_, err := ps.exec(insertHistoryQuery, historyArgs(record)...)
+	return err
+}
+
+// historyArgs 返回插入检测历史所需的参数
+func historyArgs(record CheckRecord) []any {
+	checkedAt := record.CheckedAt
+	if checkedAt.IsZero() {
+		checkedAt = time.Now()
+	}
+
+	// 统一使用 UTC 存储，保证时间字符串可以直接比较
+	return []any{checkedAt.UTC(), record.IP, record.Port, record.Source,
+		record.Target, record.Success, record.Latency.Milliseconds(), record.ErrorClass}
+}
+
+// GetHistory 获取指定时间之后的检测历史，按时间先后排序
+func (ps *ProxyStorage) GetHistory(since time.Time) ([]CheckRecord, error) {
+	rows, err := ps.query(getHistoryQuery, since.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var records []CheckRecord
+	for rows.Next() {
+		var record CheckRecord
+		var target, errorClass *string
+		var latencyMs int64
+		err := rows.Scan(&record.CheckedAt, &record.IP, &record.Port, &record.Source, &target,
+			&record.Success, &latencyMs, &errorClass)
+		if err != nil {
+			return nil, err
+		}
+		record.Target = stringOrEmpty(target)
+		record.ErrorClass = stringOrEmpty(errorClass)
+		record.Latency = time.Duration(latencyMs) * time.Millisecond
+		records = append(records, record)
+	}
+
+	return records, rows.Err()
+}
+
+// PruneHistory 删除早于指定时间的检测历史，返回删除的条数
+func (ps *ProxyStorage) PruneHistory(before time.Time) (int64, error) {
+	result, err := ps.exec(pruneHistoryQuery, before.UTC())
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// GetUptimeStats 统计指定时间之后每个代理的可用率，可用率最低的排在前面
+func (ps *ProxyStorage) GetUptimeStats(since time.Time) ([]ProxyUptime, error) {
+	rows, err := ps.query(uptimeStatsQuery, since.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var stats []ProxyUptime
+	for rows.Next() {
+		var stat ProxyUptime
+		var avgLatency *float64
+		err := rows.Scan(&stat.IP, &stat.Port, &stat.Total, &stat.Successes, &avgLatency)
+		if err != nil {
+			return nil, err
+		}
+		if stat.Total > 0 {
+			stat.Uptime = float64(stat.Successes) * 100 / float64(stat.Total)
+		}
+		if avgLatency != nil {
+			stat.AvgLatency = time.Duration(*avgLatency * float64(time.Millisecond))
+		}
+		stats = append(stats, stat)
+	}
+
+	return stats, rows.Err()
+}
+
+// GetFailureBreakdown 统计指定代理在指定时间之后各类失败原因的次数
+func (ps *ProxyStorage) GetFailureBreakdown(ip string, port int, since time.Time) (map[string]int, error) {
+	rows, err := ps.query(failureBreakdownQuery, ip, port, since.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	breakdown := make(map[string]int)
+	for rows.Next() {
+		var errorClass *string
+		var count int
+		if err := rows.Scan(&errorClass, &count); err != nil {
+			return nil, err
+		}
+		class := "unknown"
+		if errorClass != nil && *errorClass != "" {
+			class = *errorClass
+		}
+		breakdown[class] += count
+	}
+
+	return breakdown, rows.Err()
+}
@@ -0,0 +1,745 @@
+package database
+
+import (
+	"fmt"
+	"math/rand"
+	"proxychain/common"
+	"sort"
+	"sync"
+	"time"
+)
+
+// memoryProxy 内存存储中的一条代理记录
+type memoryProxy struct {
+	id          int
+	base        common.ProxyBase
+	isActive    bool
+	priority    int
+	lastChecked time.Time
+	bandwidth   *float64 // 未测速时为 nil
+	udp         *bool    // 未检测时为 nil
+	premium     bool     // 是否在高级代理池中
+
+	quarantinedAt    time.Time // 进入隔离区的时间，未隔离时为零值
+	quarantineReason string
+}
+
+// MemoryStorage 纯内存的代理存储，适用于测试与临时运行，进程退出后数据丢失
+type MemoryStorage struct {
+	mu      sync.Mutex
+	nextID  int
+	proxies map[string]*memoryProxy // 以 ip:port:protocol 为键
+	history []CheckRecord
+	usage   map[[2]string]*UserUsage // 以用户名与日期为键
+
+	lastDecay time.Time
+}
+
+// NewMemoryStorage 创建空的内存存储
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{proxies: make(map[string]*memoryProxy)}
+}
+
+// proxyKey 与 SQLite 的唯一约束保持一致
+func proxyKey(ip string, port int, protocol string) string {
+	return fmt.Sprintf("%s:%d:%s", ip, port, protocol)
+}
+
+// UpsertProxy 插入新的代理并设置默认优先级，代理已存在时只更新位置信息
+func (ms *MemoryStorage) UpsertProxy(ip string, port int, protocol, country, province, city string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	key := proxyKey(ip, port, protocol)
+	if p, ok := ms.proxies[key]; ok {
+		p.base.Country, p.base.Province, p.base.City = country, province, city
+		p.lastChecked = time.Now()
+		return nil
+	}
+
+	ms.nextID++
+	ms.proxies[key] = &memoryProxy{
+		id: ms.nextID,
+		base: common.ProxyBase{
+			URL:      fmt.Sprintf("%s://%s:%d", protocol, ip, port),
+			IP:       ip,
+			Port:     port,
+			Protocol: protocol,
+			Country:  country,
+			Province: province,
+			City:     city,
+		},
+		isActive:    true,
+		priority:    common.CurrentScoreModel().Neutral,
+		lastChecked: time.Now(),
+	}
+	return nil
+}
+
+// DecreasePriority 按指定的扣减值降低代理的优先级，不低于评分下限
+func (ms *MemoryStorage) DecreasePriority(ip string, port int, penalty int) error {
+	return ms.update(ip, port, func(p *memoryProxy) {
+		p.priority = clampPriority(p.priority - penalty)
+		p.lastChecked = time.Now()
+	})
+}
+
+// IncreasePriority 按指定的增加值提高代理的优先级，不超过评分上限
+func (ms *MemoryStorage) IncreasePriority(ip string, port int, reward int) error {
+	return ms.update(ip, port, func(p *memoryProxy) {
+		p.priority = clampPriority(p.priority + reward)
+		p.lastChecked = time.Now()
+	})
+}
+
+// DecayPriorities 将所有可用代理偏离中性值的部分乘以 factor，返回受影响的代理数量
+func (ms *MemoryStorage) DecayPriorities(factor float64) (int64, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	neutral := common.CurrentScoreModel().Neutral
+	var affected int64
+	for _, p := range ms.proxies {
+		if !p.isActive || p.priority == neutral {
+			continue
+		}
+		p.priority = clampPriority(neutral + int(float64(p.priority-neutral)*factor))
+		affected++
+	}
+	return affected, nil
+}
+
+// ClampPriorities 将超出当前评分区间的可信度限制在区间内，返回受影响的代理数量
+func (ms *MemoryStorage) ClampPriorities() (int64, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	var clamped int64
+	for _, p := range ms.proxies {
+		if priority := clampPriority(p.priority); priority != p.priority {
+			p.priority = priority
+			clamped++
+		}
+	}
+	return clamped, nil
+}
+
+// GetLastDecay 获取上一次衰减可信度的时间，从未衰减过时返回零值
+func (ms *MemoryStorage) GetLastDecay() (time.Time, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	return ms.lastDecay, nil
+}
+
+// SetLastDecay 记录衰减可信度的时间
+func (ms *MemoryStorage) SetLastDecay(at time.Time) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+	ms.lastDecay = at
+	return nil
+}
+
+// clampPriority 将可信度限制在评分区间内
+func clampPriority(priority int) int {
+	return int(common.CurrentScoreModel().Clamp(float64(priority)))
+}
+
+// QuarantineLowPriorityProxies 将优先级降到评分下限的代理移入隔离区，返回隔离的数量
+func (ms *MemoryStorage) QuarantineLowPriorityProxies() (int64, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	var quarantined int64
+	now := time.Now()
+	minPriority := common.CurrentScoreModel().Min
+	for _, p := range ms.proxies {
+		if p.priority <= minPriority && p.isActive {
+			p.isActive = false
+			p.quarantinedAt = now
+			p.quarantineReason = ms.lastFailure(p.base.IP, p.base.Port)
+			quarantined++
+		}
+	}
+	return quarantined, nil
+}
+
+// GetQuarantinedProxies 获取隔离区中的代理，隔离最早的排在前面
+func (ms *MemoryStorage) GetQuarantinedProxies() ([]QuarantinedProxy, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	var proxies []QuarantinedProxy
+	for _, p := range ms.filter(func(p *memoryProxy) bool { return !p.isActive && !p.quarantinedAt.IsZero() }) {
+		proxies = append(proxies, QuarantinedProxy{
+			ProxyBase:     p.base,
+			QuarantinedAt: p.quarantinedAt,
+			Reason:        p.quarantineReason,
+		})
+	}
+
+	sort.Slice(proxies, func(i, j int) bool {
+		return proxies[i].QuarantinedAt.Before(proxies[j].QuarantinedAt)
+	})
+	return proxies, nil
+}
+
+// RestoreProxy 将恢复可用的代理移出隔离区，并将可信度重置为 priority，返回恢复的数量
+func (ms *MemoryStorage) RestoreProxy(ip string, port int, priority int) (int64, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	var restored int64
+	now := time.Now()
+	for _, p := range ms.proxies {
+		if p.base.IP == ip && p.base.Port == port && !p.isActive {
+			p.isActive = true
+			p.priority = priority
+			p.lastChecked = now
+			p.quarantinedAt = time.Time{}
+			p.quarantineReason = ""
+			restored++
+		}
+	}
+	return restored, nil
+}
+
+// DeleteQuarantinedProxies 永久删除隔离时间早于 before 的代理，返回删除的数量
+func (ms *MemoryStorage) DeleteQuarantinedProxies(before time.Time) (int64, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	var deleted int64
+	for key, p := range ms.proxies {
+		if !p.isActive && !p.quarantinedAt.IsZero() && p.quarantinedAt.Before(before) && p.quarantineReason != ReasonBanned {
+			delete(ms.proxies, key)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+// QuarantineProxy 将指定代理移入隔离区并记录原因，返回受影响的代理数量
+func (ms *MemoryStorage) QuarantineProxy(ip string, port int, reason string) (int64, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	var affected int64
+	now := time.Now()
+	for _, p := range ms.proxies {
+		if p.base.IP == ip && p.base.Port == port {
+			p.isActive = false
+			p.quarantinedAt = now
+			p.quarantineReason = reason
+			affected++
+		}
+	}
+	return affected, nil
+}
+
+// DeleteProxy 永久删除指定代理，返回删除的数量
+func (ms *MemoryStorage) DeleteProxy(ip string, port int) (int64, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	var deleted int64
+	for key, p := range ms.proxies {
+		if p.base.IP == ip && p.base.Port == port {
+			delete(ms.proxies, key)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+// lastFailure 返回代理最近一次失败的错误分类，调用方需持有锁
+func (ms *MemoryStorage) lastFailure(ip string, port int) string {
+	reason := ReasonLowPriority
+	var latest time.Time
+	for _, record := range ms.history {
+		if record.IP != ip || record.Port != port || record.Success || record.ErrorClass == "" {
+			continue
+		}
+		if !record.CheckedAt.Before(latest) {
+			latest = record.CheckedAt
+			reason = record.ErrorClass
+		}
+	}
+	return reason
+}
+
+// GetActiveProxiesByPriority 按优先级获取所有可用的代理
+func (ms *MemoryStorage) GetActiveProxiesByPriority() ([]common.ProxyBase, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	var proxies []common.ProxyBase
+	for _, p := range ms.byPriority(ms.filter(func(p *memoryProxy) bool { return p.isActive })) {
+		proxies = append(proxies, p.base)
+	}
+	return proxies, nil
+}
+
+// GetRandomProxies 随机取出指定数量的代理，过滤带宽不足的代理
+func (ms *MemoryStorage) GetRandomProxies(limit int, minBandwidth float64) ([]string, error) {
+	return ms.random(limit, ms.selectable("", minBandwidth)), nil
+}
+
+// GetActiveProxiesByPriorityLimit 获取按优先级排序的代理，最多获取指定数量
+func (ms *MemoryStorage) GetActiveProxiesByPriorityLimit(limit int, minBandwidth float64) ([]string, error) {
+	return ms.ordered(limit, ms.selectable("", minBandwidth)), nil
+}
+
+// GetRandomProxiesFromCountry 随机获取指定国家的代理
+func (ms *MemoryStorage) GetRandomProxiesFromCountry(limit int, country string, minBandwidth float64) ([]string, error) {
+	return ms.random(limit, ms.selectable(country, minBandwidth)), nil
+}
+
+// GetActiveProxiesByPriorityFromCountry 获取指定国家的按优先级排序的代理
+func (ms *MemoryStorage) GetActiveProxiesByPriorityFromCountry(limit int, country string, minBandwidth float64) ([]string, error) {
+	return ms.ordered(limit, ms.selectable(country, minBandwidth)), nil
+}
+
+// UpdateThroughput 更新代理测得的带宽，单位字节每秒，nil 表示未知
+func (ms *MemoryStorage) UpdateThroughput(ip string, port int, bandwidth *float64) error {
+	return ms.update(ip, port, func(p *memoryProxy) {
+		p.bandwidth = bandwidth
+	})
+}
+
+// UpdateUDPSupport 更新代理是否支持 UDP ASSOCIATE
+func (ms *MemoryStorage) UpdateUDPSupport(ip string, port int, supported bool) error {
+	return ms.update(ip, port, func(p *memoryProxy) {
+		p.udp = &supported
+	})
+}
+
+// GetRandomUDPProxies 随机获取检测确认支持 UDP 的 SOCKS5 代理
+func (ms *MemoryStorage) GetRandomUDPProxies(limit int) ([]string, error) {
+	ms.mu.Lock()
+	candidates := ms.filter(func(p *memoryProxy) bool {
+		return p.isActive && p.base.Protocol == "socks5" && p.udp != nil && *p.udp
+	})
+	ms.mu.Unlock()
+
+	return ms.random(limit, candidates), nil
+}
+
+// GetProxyCount 获取可用代理的数量，不包含隔离区中的代理
+func (ms *MemoryStorage) GetProxyCount() (int, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	return len(ms.filter(func(p *memoryProxy) bool { return p.isActive })), nil
+}
+
+// GetCountryStatistics 获取中国与其他国家的代理数量
+func (ms *MemoryStorage) GetCountryStatistics() (int, int, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	var chinaCount, nonChinaCount int
+	for _, p := range ms.filter(func(p *memoryProxy) bool { return p.isActive }) {
+		if p.base.Country == "中国" {
+			chinaCount++
+		} else {
+			nonChinaCount++
+		}
+	}
+	return chinaCount, nonChinaCount, nil
+}
+
+// GetPoolCounts 按国家、协议、是否可用与是否属于高级代理池分组统计代理数量
+func (ms *MemoryStorage) GetPoolCounts() ([]PoolCount, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	groups := make(map[PoolCount]int)
+	for _, p := range ms.proxies {
+		groups[PoolCount{Country: p.base.Country, Protocol: p.base.Protocol, Active: p.isActive, Premium: p.premium}]++
+	}
+
+	counts := make([]PoolCount, 0, len(groups))
+	for group, count := range groups {
+		group.Count = count
+		counts = append(counts, group)
+	}
+	return counts, nil
+}
+
+// GetTierCandidates 返回所有代理的优先级、所属层级以及指定时间之后的检测统计
+func (ms *MemoryStorage) GetTierCandidates(since time.Time) ([]TierCandidate, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	type counter struct{ total, successes int }
+	counts := make(map[string]*counter)
+	for _, record := range ms.history {
+		if record.CheckedAt.Before(since) {
+			continue
+		}
+		key := fmt.Sprintf("%s:%d", record.IP, record.Port)
+		c, ok := counts[key]
+		if !ok {
+			c = &counter{}
+			counts[key] = c
+		}
+		c.total++
+		if record.Success {
+			c.successes++
+		}
+	}
+
+	candidates := make([]TierCandidate, 0, len(ms.proxies))
+	for _, p := range ms.proxies {
+		candidate := TierCandidate{
+			IP:       p.base.IP,
+			Port:     p.base.Port,
+			Protocol: p.base.Protocol,
+			Priority: p.priority,
+			Active:   p.isActive,
+			Premium:  p.premium,
+		}
+		if c, ok := counts[fmt.Sprintf("%s:%d", p.base.IP, p.base.Port)]; ok {
+			candidate.Total, candidate.Successes = c.total, c.successes
+		}
+		candidates = append(candidates, candidate)
+	}
+	return candidates, nil
+}
+
+// PromoteProxy 将代理加入高级代理池
+func (ms *MemoryStorage) PromoteProxy(ip string, port int, protocol string) error {
+	return ms.setPremium(ip, port, protocol, true)
+}
+
+// DemoteProxy 将代理移出高级代理池
+func (ms *MemoryStorage) DemoteProxy(ip string, port int, protocol string) error {
+	return ms.setPremium(ip, port, protocol, false)
+}
+
+// GetPremiumProxies 获取按优先级排序的高级代理，country 为空时不限制国家
+func (ms *MemoryStorage) GetPremiumProxies(limit int, country string, minBandwidth float64) ([]string, error) {
+	ms.mu.Lock()
+	candidates := ms.filter(func(p *memoryProxy) bool {
+		if !p.premium || !p.isActive || (country != "" && p.base.Country != country) {
+			return false
+		}
+		return p.bandwidth == nil || *p.bandwidth >= minBandwidth
+	})
+	ms.mu.Unlock()
+
+	return ms.ordered(limit, candidates), nil
+}
+
+// GetPremiumCount 获取高级代理池中可用代理的数量
+func (ms *MemoryStorage) GetPremiumCount() (int, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	return len(ms.filter(func(p *memoryProxy) bool { return p.isActive && p.premium })), nil
+}
+
+// setPremium 修改代理所属的层级
+func (ms *MemoryStorage) setPremium(ip string, port int, protocol string, premium bool) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if p, ok := ms.proxies[proxyKey(ip, port, protocol)]; ok {
+		p.premium = premium
+	}
+	return nil
+}
+
+// ExportProxies 导出全部代理，包括隔离区中的代理，按可信度从高到低排序
+func (ms *MemoryStorage) ExportProxies() ([]ProxyRecord, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	proxies := ms.byPriority(ms.filter(func(p *memoryProxy) bool { return true }))
+	records := make([]ProxyRecord, 0, len(proxies))
+	for _, p := range proxies {
+		record := ProxyRecord{
+			IP:               p.base.IP,
+			Port:             p.base.Port,
+			Protocol:         p.base.Protocol,
+			Country:          p.base.Country,
+			Province:         p.base.Province,
+			City:             p.base.City,
+			Active:           p.isActive,
+			Priority:         p.priority,
+			Premium:          p.premium,
+			Bandwidth:        p.bandwidth,
+			UDP:              p.udp,
+			QuarantineReason: p.quarantineReason,
+		}
+		if !p.lastChecked.IsZero() {
+			lastChecked := p.lastChecked
+			record.LastChecked = &lastChecked
+		}
+		if !p.quarantinedAt.IsZero() {
+			quarantinedAt := p.quarantinedAt
+			record.QuarantinedAt = &quarantinedAt
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+// ImportProxies 导入代理，与已有代理冲突时按合并策略处理，返回写入的代理数量
+func (ms *MemoryStorage) ImportProxies(records []ProxyRecord, strategy string) (int, error) {
+	if strategy != MergeHigher && strategy != MergeOverwrite && strategy != MergeSkip {
+		return 0, fmt.Errorf("不支持的合并策略: %s", strategy)
+	}
+
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	var imported int
+	for _, record := range records {
+		key := proxyKey(record.IP, record.Port, record.Protocol)
+		priority := clampPriority(record.Priority)
+		p, ok := ms.proxies[key]
+		if ok && (strategy == MergeSkip || (strategy == MergeHigher && priority <= p.priority)) {
+			continue
+		}
+		if !ok {
+			ms.nextID++
+			p = &memoryProxy{id: ms.nextID}
+			ms.proxies[key] = p
+		}
+
+		p.base = common.ProxyBase{
+			URL:      fmt.Sprintf("%s://%s:%d", record.Protocol, record.IP, record.Port),
+			IP:       record.IP,
+			Port:     record.Port,
+			Protocol: record.Protocol,
+			Country:  record.Country,
+			Province: record.Province,
+			City:     record.City,
+		}
+		p.isActive, p.priority, p.premium = record.Active, priority, record.Premium
+		p.bandwidth, p.udp = record.Bandwidth, record.UDP
+		p.lastChecked, p.quarantinedAt = timeOrZero(record.LastChecked), timeOrZero(record.QuarantinedAt)
+		p.quarantineReason = record.QuarantineReason
+		imported++
+	}
+	return imported, nil
+}
+
+// timeOrZero 将可选的时间转换为时间值，缺省时为零值
+func timeOrZero(t *time.Time) time.Time {
+	if t == nil {
+		return time.Time{}
+	}
+	return *t
+}
+
+// RecordCheck 记录一次健康检测或实际流量的结果
+func (ms *MemoryStorage) RecordCheck(record CheckRecord) error {
+	if record.CheckedAt.IsZero() {
+		record.CheckedAt = time.Now()
+	}
+
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	ms.history = append(ms.history, record)
+	return nil
+}
+
+// GetHistory 获取指定时间之后的检测历史，按时间先后排序
+func (ms *MemoryStorage) GetHistory(since time.Time) ([]CheckRecord, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	var records []CheckRecord
+	for _, record := range ms.history {
+		if !record.CheckedAt.Before(since) {
+			records = append(records, record)
+		}
+	}
+
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].CheckedAt.Before(records[j].CheckedAt)
+	})
+	return records, nil
+}
+
+// PruneHistory 删除早于指定时间的检测历史，返回删除的条数
+func (ms *MemoryStorage) PruneHistory(before time.Time) (int64, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	kept := ms.history[:0]
+	for _, record := range ms.history {
+		if !record.CheckedAt.Before(before) {
+			kept = append(kept, record)
+		}
+	}
+	deleted := int64(len(ms.history) - len(kept))
+	ms.history = kept
+	return deleted, nil
+}
+
+// GetUptimeStats 统计指定时间之后每个代理的可用率，可用率最低的排在前面
+func (ms *MemoryStorage) GetUptimeStats(since time.Time) ([]ProxyUptime, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	type accumulator struct {
+		stat         ProxyUptime
+		totalLatency time.Duration
+	}
+
+	index := make(map[string]*accumulator)
+	var order []*accumulator
+	for _, record := range ms.history {
+		if record.CheckedAt.Before(since) {
+			continue
+		}
+		key := fmt.Sprintf("%s:%d", record.IP, record.Port)
+		acc, ok := index[key]
+		if !ok {
+			acc = &accumulator{stat: ProxyUptime{IP: record.IP, Port: record.Port}}
+			index[key] = acc
+			order = append(order, acc)
+		}
+		acc.stat.Total++
+		if record.Success {
+			acc.stat.Successes++
+			acc.totalLatency += record.Latency
+		}
+	}
+
+	stats := make([]ProxyUptime, 0, len(order))
+	for _, acc := range order {
+		stat := acc.stat
+		stat.Uptime = float64(stat.Successes) * 100 / float64(stat.Total)
+		if stat.Successes > 0 {
+			stat.AvgLatency = acc.totalLatency / time.Duration(stat.Successes)
+		}
+		stats = append(stats, stat)
+	}
+
+	sort.SliceStable(stats, func(i, j int) bool {
+		if stats[i].Uptime != stats[j].Uptime {
+			return stats[i].Uptime < stats[j].Uptime
+		}
+		return stats[i].Total > stats[j].Total
+	})
+	return stats, nil
+}
+
+// GetFailureBreakdown 统计指定代理在指定时间之后各类失败原因的次数
+func (ms *MemoryStorage) GetFailureBreakdown(ip string, port int, since time.Time) (map[string]int, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	breakdown := make(map[string]int)
+	for _, record := range ms.history {
+		if record.IP != ip || record.Port != port || record.Success || record.CheckedAt.Before(since) {
+			continue
+		}
+		class := record.ErrorClass
+		if class == "" {
+			class = "unknown"
+		}
+		breakdown[class]++
+	}
+	return breakdown, nil
+}
+
+// ApplyBatch 批量应用优先级变化并写入检测历史
+func (ms *MemoryStorage) ApplyBatch(deltas []PriorityDelta, records []CheckRecord) error {
+	for _, delta := range deltas {
+		ms.update(delta.IP, delta.Port, func(p *memoryProxy) {
+			p.priority = clampPriority(p.priority + delta.Delta)
+			p.lastChecked = delta.LastChecked
+		})
+	}
+	for _, record := range records {
+		ms.RecordCheck(record)
+	}
+	return nil
+}
+
+// Close 内存存储无需释放资源
+func (ms *MemoryStorage) Close() error {
+	return nil
+}
+
+// update 对匹配 ip 与端口的所有代理执行修改，与 SQL 实现的匹配规则一致
+func (ms *MemoryStorage) update(ip string, port int, fn func(p *memoryProxy)) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	for _, p := range ms.proxies {
+		if p.base.IP == ip && p.base.Port == port {
+			fn(p)
+		}
+	}
+	return nil
+}
+
+// filter 返回满足条件的代理，调用方需持有锁
+func (ms *MemoryStorage) filter(match func(p *memoryProxy) bool) []*memoryProxy {
+	var result []*memoryProxy
+	for _, p := range ms.proxies {
+		if match(p) {
+			result = append(result, p)
+		}
+	}
+	return result
+}
+
+// selectable 返回可用于转发的代理，country 为空时不限制国家
+func (ms *MemoryStorage) selectable(country string, minBandwidth float64) []*memoryProxy {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	return ms.filter(func(p *memoryProxy) bool {
+		if !p.isActive || (country != "" && p.base.Country != country) {
+			return false
+		}
+		return p.bandwidth == nil || *p.bandwidth >= minBandwidth
+	})
+}
+
+// byPriority 按优先级从高到低排序，优先级相同时按插入顺序
+func (ms *MemoryStorage) byPriority(proxies []*memoryProxy) []*memoryProxy {
+	sort.Slice(proxies, func(i, j int) bool {
+		if proxies[i].priority != proxies[j].priority {
+			return proxies[i].priority > proxies[j].priority
+		}
+		return proxies[i].id < proxies[j].id
+	})
+	return proxies
+}
+
+// ordered 返回优先级最高的 limit 个代理地址
+func (ms *MemoryStorage) ordered(limit int, proxies []*memoryProxy) []string {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	return urls(ms.byPriority(proxies), limit)
+}
+
+// random 随机返回 limit 个代理地址
+func (ms *MemoryStorage) random(limit int, proxies []*memoryProxy) []string {
+	rand.Shuffle(len(proxies), func(i, j int) {
+		proxies[i], proxies[j] = proxies[j], proxies[i]
+	})
+	return urls(proxies, limit)
+}
+
+// urls 将代理记录转换为地址列表，最多返回 limit 个
+func urls(proxies []*memoryProxy, limit int) []string {
+	var result []string
+	for _, p := range proxies {
+		if len(result) >= limit {
+			break
+		}
+		result = append(result, p.base.URL)
+	}
+	return result
+}
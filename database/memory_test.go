@@ -0,0 +1,177 @@
+package database
+
+import (
+	"slices"
+	"testing"
+)
+
+// newTestMemoryStorage 创建包含不同国家、优先级与带宽的代理，10.2.0.5 在隔离区中
+//
+//	10.2.0.1 http   中国 160 带宽 5000
+//	10.2.0.2 socks5 美国 120 带宽 500
+//	10.2.0.3 http   中国 100 未测速
+//	10.2.0.4 http   中国 60  带宽 2000
+//	10.2.0.5 http   日本 隔离
+func newTestMemoryStorage(t *testing.T) *MemoryStorage {
+	t.Helper()
+
+	ms := NewMemoryStorage()
+	fixtures := []struct {
+		ip        string
+		protocol  string
+		country   string
+		delta     int
+		bandwidth float64
+	}{
+		{"10.2.0.1", "http", "中国", 60, 5000},
+		{"10.2.0.2", "socks5", "美国", 20, 500},
+		{"10.2.0.3", "http", "中国", 0, 0},
+		{"10.2.0.4", "http", "中国", -40, 2000},
+		{"10.2.0.5", "http", "日本", 80, 9000},
+	}
+	for _, f := range fixtures {
+		ms.UpsertProxy(f.ip, 8080, f.protocol, f.country, "", "")
+		ms.IncreasePriority(f.ip, 8080, f.delta)
+		if f.bandwidth > 0 {
+			ms.UpdateThroughput(f.ip, 8080, &f.bandwidth)
+		}
+	}
+	if _, err := ms.QuarantineProxy("10.2.0.5", 8080, "banned"); err != nil {
+		t.Fatal(err)
+	}
+	return ms
+}
+
+const (
+	proxyA = "http://10.2.0.1:8080"
+	proxyB = "socks5://10.2.0.2:8080"
+	proxyC = "http://10.2.0.3:8080"
+	proxyD = "http://10.2.0.4:8080"
+)
+
+func TestMemoryStorageByPriority(t *testing.T) {
+	ms := newTestMemoryStorage(t)
+
+	tests := []struct {
+		name         string
+		limit        int
+		country      string
+		minBandwidth float64
+		want         []string
+	}{
+		{"全部", 10, "", 0, []string{proxyA, proxyB, proxyC, proxyD}},
+		{"数量限制", 2, "", 0, []string{proxyA, proxyB}},
+		{"数量为 0", 0, "", 0, nil},
+		{"带宽过滤保留未测速的代理", 10, "", 1000, []string{proxyA, proxyC, proxyD}},
+		{"国家", 10, "中国", 0, []string{proxyA, proxyC, proxyD}},
+		{"国家与带宽", 10, "中国", 3000, []string{proxyA, proxyC}},
+		{"国家与数量限制", 1, "美国", 0, []string{proxyB}},
+		{"隔离区中的代理不参与选择", 10, "日本", 0, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			var err error
+			if tt.country == "" {
+				got, err = ms.GetActiveProxiesByPriorityLimit(tt.limit, tt.minBandwidth)
+			} else {
+				got, err = ms.GetActiveProxiesByPriorityFromCountry(tt.limit, tt.country, tt.minBandwidth)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("得到 %v，期望 %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMemoryStorageRandom(t *testing.T) {
+	ms := newTestMemoryStorage(t)
+
+	tests := []struct {
+		name         string
+		limit        int
+		country      string
+		minBandwidth float64
+		candidates   []string // 可能被选中的代理
+		wantLen      int
+	}{
+		{"全部", 10, "", 0, []string{proxyA, proxyB, proxyC, proxyD}, 4},
+		{"数量限制", 2, "", 0, []string{proxyA, proxyB, proxyC, proxyD}, 2},
+		{"带宽过滤", 10, "", 1000, []string{proxyA, proxyC, proxyD}, 3},
+		{"国家", 10, "中国", 0, []string{proxyA, proxyC, proxyD}, 3},
+		{"国家与带宽", 10, "中国", 3000, []string{proxyA, proxyC}, 2},
+		{"没有匹配的国家", 10, "德国", 0, nil, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// 随机选择多次，每次都只能选中候选代理且不重复
+			for i := 0; i < 20; i++ {
+				var got []string
+				var err error
+				if tt.country == "" {
+					got, err = ms.GetRandomProxies(tt.limit, tt.minBandwidth)
+				} else {
+					got, err = ms.GetRandomProxiesFromCountry(tt.limit, tt.country, tt.minBandwidth)
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(got) != tt.wantLen {
+					t.Fatalf("得到 %d 个代理 %v，期望 %d 个", len(got), got, tt.wantLen)
+				}
+				seen := make(map[string]bool)
+				for _, proxy := range got {
+					if !slices.Contains(tt.candidates, proxy) || seen[proxy] {
+						t.Fatalf("得到 %v，只能从 %v 中选择且不重复", got, tt.candidates)
+					}
+					seen[proxy] = true
+				}
+			}
+		})
+	}
+}
+
+func TestMemoryStoragePriorityClamp(t *testing.T) {
+	// 默认评分区间为 [0, 200]，新代理的可信度为 100
+	tests := []struct {
+		name   string
+		deltas []int // 正数调用 IncreasePriority，负数调用 DecreasePriority
+		want   int
+	}{
+		{"增加", []int{50}, 150},
+		{"增加到上限", []int{500}, 200},
+		{"恰好到上限", []int{100}, 200},
+		{"降低", []int{-30}, 70},
+		{"降低到下限", []int{-500}, 0},
+		{"到上限后再降低", []int{500, -20}, 180},
+		{"到下限后再增加", []int{-500, 20}, 20},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ms := NewMemoryStorage()
+			ms.UpsertProxy("10.3.0.1", 80, "http", "", "", "")
+			for _, delta := range tt.deltas {
+				var err error
+				if delta >= 0 {
+					err = ms.IncreasePriority("10.3.0.1", 80, delta)
+				} else {
+					err = ms.DecreasePriority("10.3.0.1", 80, -delta)
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			records, err := ms.ExportProxies()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := records[0].Priority; got != tt.want {
+				t.Errorf("优先级为 %d，期望 %d", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,49 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// metaLastDecay 上一次衰减可信度的时间，重启后按实际经过的时间继续衰减
+const metaLastDecay = "last_decay"
+
+// 元数据相关的 SQL 语句，SQLite 与 PostgreSQL 通用
+// 时间以 RFC 3339 字符串保存，避免两种数据库时间类型的差异
+var (
+	createMetaTableQuery = `
+		CREATE TABLE IF NOT EXISTS meta (
+			name TEXT PRIMARY KEY,
+			value TEXT NOT NULL
+		);
+	`
+	getMetaQuery = `
+		SELECT value FROM meta WHERE name = ?;
+	`
+	setMetaQuery = `
+		INSERT INTO meta (name, value)
+		VALUES (?, ?)
+		ON CONFLICT (name) DO UPDATE
+		SET value = excluded.value;
+	`
+)
+
+// GetLastDecay 获取上一次衰减可信度的时间，从未衰减过时返回零值
+func (ps *ProxyStorage) GetLastDecay() (time.Time, error) {
+	var value string
+	err := ps.queryRow(getMetaQuery, metaLastDecay).Scan(&value)
+	if errors.Is(err, sql.ErrNoRows) {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Parse(time.RFC3339Nano, value)
+}
+
+// SetLastDecay 记录衰减可信度的时间
+func (ps *ProxyStorage) SetLastDecay(at time.Time) error {
+	_, err := ps.exec(setMetaQuery, metaLastDecay, at.UTC().Format(time.RFC3339Nano))
+	return err
+}
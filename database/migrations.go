@@ -0,0 +1,245 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// migration 表示一次数据库结构变更，version 必须递增，各数据库使用相同的版本号
+// 早期版本的 SQLite 数据库没有记录版本号，因此 SQLite 的迁移都需要能在旧结构上重复执行
+type migration struct {
+	version  int
+	name     string
+	sqlite   func(tx *sql.Tx) error
+	postgres func(tx *sql.Tx) error
+}
+
+var (
+	createMigrationsTableQuery = `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version INTEGER PRIMARY KEY,
+			name TEXT NOT NULL,
+			applied_at TIMESTAMP NOT NULL
+		);
+	`
+	currentVersionQuery = `
+		SELECT COALESCE(MAX(version), 0) FROM schema_migrations;
+	`
+	insertMigrationQuery = `
+		INSERT INTO schema_migrations (version, name, applied_at)
+		VALUES (?, ?, ?);
+	`
+	// 保留每组 (ip, port, protocol) 中优先级最高的一条记录
+	deduplicateProxiesQuery = `
+		DELETE FROM proxies
+		WHERE id NOT IN (
+			SELECT id FROM (
+				SELECT id, ROW_NUMBER() OVER (
+					PARTITION BY ip, port, protocol
+					ORDER BY priority DESC, id ASC
+				) AS rn
+				FROM proxies
+			)
+			WHERE rn = 1
+		);
+	`
+	createProxyUniqueIndexQuery = `
+		CREATE UNIQUE INDEX IF NOT EXISTS idx_proxies_address
+		ON proxies (ip, port, protocol);
+	`
+)
+
+// migrations 全部数据库迁移，按版本号顺序执行
+var migrations = []migration{
+	{
+		version:  1,
+		name:     "创建代理表",
+		sqlite:   execStatements(createTableQuery, createHighPriorityProxyTableQuery),
+		postgres: execStatements(pgCreateTableQuery, pgCreateHighPriorityProxyTableQuery),
+	},
+	{
+		version:  2,
+		name:     "创建检测历史表",
+		sqlite:   execStatements(createHistoryTableQuery, createHistoryIndexQuery),
+		postgres: execStatements(pgCreateHistoryTableQuery, createHistoryIndexQuery),
+	},
+	{
+		version: 3,
+		name:    "代理表增加带宽与 UDP 字段",
+		sqlite: func(tx *sql.Tx) error {
+			if err := ensureColumn(tx, "proxies", "bandwidth", "REAL"); err != nil {
+				return err
+			}
+			return ensureColumn(tx, "proxies", "udp", "BOOLEAN")
+		},
+		postgres: execStatements(
+			`ALTER TABLE proxies ADD COLUMN IF NOT EXISTS bandwidth DOUBLE PRECISION;`,
+			`ALTER TABLE proxies ADD COLUMN IF NOT EXISTS udp BOOLEAN;`,
+		),
+	},
+	{
+		version:  4,
+		name:     "代理地址唯一约束",
+		sqlite:   execStatements(deduplicateProxiesQuery, createProxyUniqueIndexQuery),
+		postgres: execStatements(createProxyUniqueIndexQuery),
+	},
+	{
+		version:  5,
+		name:     "高级代理唯一约束",
+		sqlite:   execStatements(deduplicateHighPriorityProxiesQuery, createHighPriorityUniqueIndexQuery),
+		postgres: execStatements(deduplicateHighPriorityProxiesQuery, createHighPriorityUniqueIndexQuery),
+	},
+	{
+		version: 6,
+		name:    "代理隔离区",
+		sqlite: func(tx *sql.Tx) error {
+			if err := ensureColumn(tx, "proxies", "quarantined_at", "DATETIME"); err != nil {
+				return err
+			}
+			return ensureColumn(tx, "proxies", "quarantine_reason", "TEXT")
+		},
+		postgres: execStatements(
+			`ALTER TABLE proxies ADD COLUMN IF NOT EXISTS quarantined_at TIMESTAMPTZ;`,
+			`ALTER TABLE proxies ADD COLUMN IF NOT EXISTS quarantine_reason TEXT;`,
+		),
+	},
+	{
+		version:  7,
+		name:     "用户用量表",
+		sqlite:   execStatements(createUsageTableQuery),
+		postgres: execStatements(createUsageTableQuery),
+	},
+	{
+		version:  8,
+		name:     "元数据表",
+		sqlite:   execStatements(createMetaTableQuery),
+		postgres: execStatements(createMetaTableQuery),
+	},
+}
+
+// migrate 将数据库升级到最新版本，每个迁移在独立的事务中执行
+func migrate(db *sql.DB, d *dialect) error {
+	if err := createMigrationsTable(db, d); err != nil {
+		return fmt.Errorf("创建迁移版本表失败: %w", err)
+	}
+
+	for _, m := range migrations {
+		applied, err := applyMigration(db, d, m)
+		if err != nil {
+			return fmt.Errorf("执行数据库迁移 %d (%s) 失败: %w", m.version, m.name, err)
+		}
+		if applied {
+			storageLog.Info("数据库已迁移", "version", m.version, "name", m.name)
+		}
+	}
+
+	return nil
+}
+
+// createMigrationsTable 在加锁的事务中创建版本表
+// PostgreSQL 的 CREATE TABLE IF NOT EXISTS 不是并发安全的，多个实例同时建表时会违反 pg_type 的唯一约束
+func createMigrationsTable(db *sql.DB, d *dialect) error {
+	tx, err := beginMigration(db, d)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(createMigrationsTableQuery); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// beginMigration 开始迁移事务并获取迁移锁，锁在事务结束时释放
+func beginMigration(db *sql.DB, d *dialect) (*sql.Tx, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	if d.lockMigrations != nil {
+		if err := d.lockMigrations(tx); err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+	}
+	return tx, nil
+}
+
+// applyMigration 在事务中执行单个迁移并记录版本号，已执行过的迁移返回 false
+// 版本号在事务内加锁后读取，多个实例同时启动时只有一个会执行迁移
+func applyMigration(db *sql.DB, d *dialect, m migration) (bool, error) {
+	tx, err := beginMigration(db, d)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	var current int
+	if err := tx.QueryRow(currentVersionQuery).Scan(&current); err != nil {
+		return false, err
+	}
+	if m.version <= current {
+		return false, nil
+	}
+
+	apply := m.sqlite
+	if d == postgresDialect {
+		apply = m.postgres
+	}
+	if err := apply(tx); err != nil {
+		return false, err
+	}
+
+	if _, err := tx.Exec(d.rebind(insertMigrationQuery), m.version, m.name, time.Now()); err != nil {
+		return false, err
+	}
+
+	return true, tx.Commit()
+}
+
+// execStatements 返回依次执行多条 SQL 语句的迁移函数
+func execStatements(statements ...string) func(tx *sql.Tx) error {
+	return func(tx *sql.Tx) error {
+		for _, statement := range statements {
+			if _, err := tx.Exec(statement); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// ensureColumn 检查 SQLite 表中是否存在指定字段，不存在则添加
+func ensureColumn(tx *sql.Tx, table, column, definition string) error {
+	rows, err := tx.Query(fmt.Sprintf("PRAGMA table_info(%s);", table))
+	if err != nil {
+		return err
+	}
+
+	exists := false
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, columnType string
+		var defaultValue sql.NullString
+		if err := rows.Scan(&cid, &name, &columnType, &notNull, &defaultValue, &pk); err != nil {
+			rows.Close()
+			return err
+		}
+		if name == column {
+			exists = true
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	if exists {
+		return nil
+	}
+
+	_, err = tx.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s;", table, column, definition))
+	return err
+}
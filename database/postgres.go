@@ -0,0 +1,56 @@
+package database
+
+import (
+	_ "github.com/lib/pq" // 导入 PostgreSQL 驱动
+)
+
+// PostgreSQL 的建表语句，字段与 SQLite 保持一致，只替换类型
+var (
+	pgCreateTableQuery = `
+		CREATE TABLE IF NOT EXISTS proxies (
+			id BIGSERIAL PRIMARY KEY,
+			ip TEXT NOT NULL,
+			port INTEGER NOT NULL,
+			protocol TEXT NOT NULL,
+			country TEXT,
+			province TEXT,
+			city TEXT,
+			is_active BOOLEAN NOT NULL DEFAULT TRUE,
+			priority INTEGER NOT NULL DEFAULT 100,
+			last_checked TIMESTAMPTZ
+		);
+	`
+	pgCreateHighPriorityProxyTableQuery = `
+		CREATE TABLE IF NOT EXISTS high_proiority_proxies (
+			id BIGSERIAL PRIMARY KEY,
+			ip TEXT NOT NULL,
+			port INTEGER NOT NULL,
+			protocol TEXT NOT NULL,
+			country TEXT,
+			province TEXT,
+			city TEXT,
+			is_active BOOLEAN NOT NULL DEFAULT TRUE,
+			priority INTEGER NOT NULL DEFAULT 100,
+			last_checked TIMESTAMPTZ
+		);
+	`
+	pgCreateHistoryTableQuery = `
+		CREATE TABLE IF NOT EXISTS check_history (
+			id BIGSERIAL PRIMARY KEY,
+			checked_at TIMESTAMPTZ NOT NULL,
+			ip TEXT NOT NULL,
+			port INTEGER NOT NULL,
+			source TEXT NOT NULL,
+			target TEXT,
+			success BOOLEAN NOT NULL,
+			latency_ms BIGINT NOT NULL DEFAULT 0,
+			error_class TEXT
+		);
+	`
+)
+
+// NewPostgresStorage 连接 PostgreSQL 并将表结构迁移到最新版本，适合多个实例共享同一个代理池
+// 优先级的增减都在单条 UPDATE 语句中完成，多个实例并发修改时不会互相覆盖
+func NewPostgresStorage(dsn string) (*ProxyStorage, error) {
+	return openSQLStorage(postgresDialect, dsn)
+}
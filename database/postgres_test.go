@@ -0,0 +1,70 @@
+package database
+
+import (
+	"database/sql"
+	"os"
+	"sync"
+	"testing"
+)
+
+// postgresDSNEnv 测试使用的 PostgreSQL 连接串，未设置时跳过测试
+// make test-postgres 与 CI 会启动临时的数据库并设置该变量
+// 测试会删除并重建代理相关的表，只能指向专门用于测试的数据库
+const postgresDSNEnv = "PROXYCHAIN_TEST_POSTGRES_DSN"
+
+// openTestPostgres 清空测试数据库中的表，再由多个实例同时执行迁移
+func openTestPostgres(t *testing.T) *ProxyStorage {
+	t.Helper()
+
+	dsn := os.Getenv(postgresDSNEnv)
+	if dsn == "" {
+		t.Skipf("未设置 %s，跳过 PostgreSQL 测试，可以使用 make test-postgres 运行", postgresDSNEnv)
+	}
+
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = db.Exec(`DROP TABLE IF EXISTS proxies, high_proiority_proxies, check_history, user_usage, meta, schema_migrations CASCADE;`)
+	db.Close()
+	if err != nil {
+		t.Fatalf("清空测试数据库失败: %v", err)
+	}
+
+	// 模拟多个实例同时启动，版本表与各个迁移都只能执行一次
+	const instances = 4
+	storages := make([]*ProxyStorage, instances)
+	errs := make([]error, instances)
+	var wg sync.WaitGroup
+	for i := range storages {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			storages[i], errs[i] = NewPostgresStorage(dsn)
+		}()
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			t.Fatalf("第 %d 个实例迁移失败: %v", i+1, err)
+		}
+	}
+	for _, ps := range storages[1:] {
+		ps.Close()
+	}
+	t.Cleanup(func() { storages[0].Close() })
+
+	var version, count int
+	if err := storages[0].db.QueryRow(`SELECT MAX(version), COUNT(*) FROM schema_migrations;`).Scan(&version, &count); err != nil {
+		t.Fatal(err)
+	}
+	if latest := migrations[len(migrations)-1].version; version != latest || count != len(migrations) {
+		t.Fatalf("迁移版本为 %d，共 %d 条记录，期望 %d 与 %d", version, count, latest, len(migrations))
+	}
+	return storages[0]
+}
+
+func TestPostgresStorageRoundTrip(t *testing.T) {
+	testStorageRoundTrip(t, openTestPostgres(t))
+}
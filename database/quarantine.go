@@ -0,0 +1,146 @@
+package database
+
+import (
+	"fmt"
+	"proxychain/common"
+	"time"
+)
+
+// 隔离原因，除此之外隔离原因为最近一次失败的错误分类
+const (
+	ReasonLowPriority = "low_priority" // 代理可信度降到下限且没有记录到具体的失败原因
+	ReasonBanned      = "banned"       // 手动封禁，不会被复检恢复，也不会因超过保留时长而删除
+)
+
+// 隔离区相关的 SQL 语句，隔离的代理 is_active 为假，并记录隔离时间与原因
+var (
+	// 隔离原因取最近一次失败的错误分类
+	quarantineLowPriorityQuery = `
+		UPDATE proxies
+		SET is_active = FALSE, quarantined_at = ?,
+			quarantine_reason = COALESCE((
+				SELECT h.error_class FROM check_history h
+				WHERE h.ip = proxies.ip AND h.port = proxies.port AND NOT h.success
+					AND h.error_class IS NOT NULL AND h.error_class != ''
+				ORDER BY h.checked_at DESC
+				LIMIT 1
+			), ?)
+		WHERE priority <= ? AND is_active;
+	`
+	getQuarantinedProxiesQuery = `
+		SELECT ip, port, protocol, country, province, city, quarantined_at, quarantine_reason
+		FROM proxies
+		WHERE NOT is_active AND quarantined_at IS NOT NULL
+		ORDER BY quarantined_at ASC;
+	`
+	restoreProxyQuery = `
+		UPDATE proxies
+		SET is_active = TRUE, priority = ?, last_checked = ?, quarantined_at = NULL, quarantine_reason = NULL
+		WHERE ip = ? AND port = ? AND NOT is_active;
+	`
+	deleteQuarantinedProxiesQuery = `
+		DELETE FROM proxies
+		WHERE NOT is_active AND quarantined_at < ? AND COALESCE(quarantine_reason, '') != ?;
+	`
+	quarantineProxyQuery = `
+		UPDATE proxies
+		SET is_active = FALSE, quarantined_at = ?, quarantine_reason = ?
+		WHERE ip = ? AND port = ?;
+	`
+	deleteProxyQuery = `
+		DELETE FROM proxies
+		WHERE ip = ? AND port = ?;
+	`
+)
+
+// QuarantineLowPriorityProxies 将优先级降到评分下限的代理移入隔离区，返回隔离的数量
+func (ps *ProxyStorage) QuarantineLowPriorityProxies() (int64, error) {
+	model := common.CurrentScoreModel()
+	result, err := ps.exec(quarantineLowPriorityQuery, time.Now().UTC(), ReasonLowPriority, model.Min)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// GetQuarantinedProxies 获取隔离区中的代理，隔离最早的排在前面
+func (ps *ProxyStorage) GetQuarantinedProxies() ([]QuarantinedProxy, error) {
+	rows, err := ps.query(getQuarantinedProxiesQuery)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var proxies []QuarantinedProxy
+	for rows.Next() {
+		var proxy QuarantinedProxy
+		var country, province, city, reason *string
+		err := rows.Scan(&proxy.IP, &proxy.Port, &proxy.Protocol, &country, &province, &city,
+			&proxy.QuarantinedAt, &reason)
+		if err != nil {
+			return nil, err
+		}
+		proxy.Country, proxy.Province, proxy.City = stringOrEmpty(country), stringOrEmpty(province), stringOrEmpty(city)
+		proxy.Reason = stringOrEmpty(reason)
+		proxy.URL = fmt.Sprintf("%s://%s:%d", proxy.Protocol, proxy.IP, proxy.Port)
+		proxies = append(proxies, proxy)
+	}
+
+	return proxies, rows.Err()
+}
+
+// RestoreProxy 将恢复可用的代理移出隔离区，并将可信度重置为 priority，返回恢复的数量
+func (ps *ProxyStorage) RestoreProxy(ip string, port int, priority int) (int64, error) {
+	result, err := ps.exec(restoreProxyQuery, priority, time.Now(), ip, port)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// DeleteQuarantinedProxies 永久删除隔离时间早于 before 的代理，并将其移出高级代理池，返回删除的数量，封禁的代理不会被删除
+func (ps *ProxyStorage) DeleteQuarantinedProxies(before time.Time) (int64, error) {
+	result, err := ps.exec(deleteQuarantinedProxiesQuery, before.UTC(), ReasonBanned)
+	if err != nil {
+		return 0, err
+	}
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = ps.exec(deleteOrphanPremiumProxiesQuery)
+	return deleted, err
+}
+
+// QuarantineProxy 将指定代理移入隔离区并记录原因，返回受影响的代理数量
+func (ps *ProxyStorage) QuarantineProxy(ip string, port int, reason string) (int64, error) {
+	result, err := ps.exec(quarantineProxyQuery, time.Now().UTC(), reason, ip, port)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+// DeleteProxy 永久删除指定代理，并将其移出高级代理池，返回删除的数量
+func (ps *ProxyStorage) DeleteProxy(ip string, port int) (int64, error) {
+	result, err := ps.exec(deleteProxyQuery, ip, port)
+	if err != nil {
+		return 0, err
+	}
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = ps.exec(deleteOrphanPremiumProxiesQuery)
+	return deleted, err
+}
+
+// stringOrEmpty 将可能为 NULL 的字符串字段转换为字符串
+func stringOrEmpty(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
@@ -0,0 +1,139 @@
+package database
+
+import (
+	"fmt"
+	"proxychain/common"
+	"time"
+)
+
+// storageLog 数据库与批量写入的日志
+var storageLog = common.Logger(common.LogStorage)
+
+// Storage 代理存储需要实现的全部操作，core 与 proxyPool 只依赖该接口
+type Storage interface {
+	// UpsertProxy 插入新的代理并将优先级设为评分模型的中性值，代理已存在时只更新位置信息
+	UpsertProxy(ip string, port int, protocol, country, province, city string) error
+	// DecreasePriority 按指定的扣减值降低代理的优先级，不低于评分下限
+	DecreasePriority(ip string, port int, penalty int) error
+	// IncreasePriority 按指定的增加值提高代理的优先级，不超过评分上限
+	IncreasePriority(ip string, port int, reward int) error
+	// DecayPriorities 将所有可用代理偏离中性值的部分乘以 factor，返回受影响的代理数量
+	DecayPriorities(factor float64) (int64, error)
+	// ClampPriorities 将超出当前评分区间的可信度限制在区间内，返回受影响的代理数量
+	ClampPriorities() (int64, error)
+	// GetLastDecay 获取上一次衰减可信度的时间，从未衰减过时返回零值
+	GetLastDecay() (time.Time, error)
+	// SetLastDecay 记录衰减可信度的时间
+	SetLastDecay(at time.Time) error
+
+	// QuarantineLowPriorityProxies 将优先级降到评分下限的代理移入隔离区，返回隔离的数量
+	QuarantineLowPriorityProxies() (int64, error)
+	// GetQuarantinedProxies 获取隔离区中的代理，隔离最早的排在前面
+	GetQuarantinedProxies() ([]QuarantinedProxy, error)
+	// RestoreProxy 将恢复可用的代理移出隔离区，并将可信度重置为 priority，返回恢复的数量
+	RestoreProxy(ip string, port int, priority int) (int64, error)
+	// DeleteQuarantinedProxies 永久删除隔离时间早于 before 的代理，返回删除的数量，封禁的代理不会被删除
+	DeleteQuarantinedProxies(before time.Time) (int64, error)
+	// QuarantineProxy 将指定代理移入隔离区并记录原因，返回受影响的代理数量
+	QuarantineProxy(ip string, port int, reason string) (int64, error)
+	// DeleteProxy 永久删除指定代理，返回删除的数量
+	DeleteProxy(ip string, port int) (int64, error)
+
+	// GetActiveProxiesByPriority 按优先级获取所有可用的代理
+	GetActiveProxiesByPriority() ([]common.ProxyBase, error)
+	// GetRandomProxies 随机取出指定数量的代理，过滤带宽低于 minBandwidth（字节每秒）的代理
+	GetRandomProxies(limit int, minBandwidth float64) ([]string, error)
+	// GetActiveProxiesByPriorityLimit 获取按优先级排序的代理，最多获取指定数量
+	GetActiveProxiesByPriorityLimit(limit int, minBandwidth float64) ([]string, error)
+	// GetRandomProxiesFromCountry 随机获取指定国家的代理
+	GetRandomProxiesFromCountry(limit int, country string, minBandwidth float64) ([]string, error)
+	// GetActiveProxiesByPriorityFromCountry 获取指定国家的按优先级排序的代理
+	GetActiveProxiesByPriorityFromCountry(limit int, country string, minBandwidth float64) ([]string, error)
+
+	// UpdateThroughput 更新代理测得的带宽，单位字节每秒，nil 表示未知，选择代理时不按带宽过滤
+	UpdateThroughput(ip string, port int, bandwidth *float64) error
+	// UpdateUDPSupport 更新代理是否支持 UDP ASSOCIATE
+	UpdateUDPSupport(ip string, port int, supported bool) error
+	// GetRandomUDPProxies 随机获取检测确认支持 UDP 的 SOCKS5 代理
+	GetRandomUDPProxies(limit int) ([]string, error)
+
+	// GetProxyCount 获取可用代理的数量，不包含隔离区中的代理
+	GetProxyCount() (int, error)
+	// GetCountryStatistics 获取中国与其他国家的代理数量
+	GetCountryStatistics() (int, int, error)
+	// GetPoolCounts 按国家、协议、是否可用与是否属于高级代理池分组统计代理数量，包括隔离区中的代理
+	GetPoolCounts() ([]PoolCount, error)
+
+	// GetTierCandidates 返回所有代理的优先级、所属层级以及指定时间之后的检测统计
+	GetTierCandidates(since time.Time) ([]TierCandidate, error)
+	// PromoteProxy 将代理加入高级代理池
+	PromoteProxy(ip string, port int, protocol string) error
+	// DemoteProxy 将代理移出高级代理池
+	DemoteProxy(ip string, port int, protocol string) error
+	// GetPremiumProxies 获取按优先级排序的高级代理，country 为空时不限制国家
+	GetPremiumProxies(limit int, country string, minBandwidth float64) ([]string, error)
+	// GetPremiumCount 获取高级代理池中可用代理的数量
+	GetPremiumCount() (int, error)
+
+	// RecordCheck 记录一次健康检测或实际流量的结果
+	RecordCheck(record CheckRecord) error
+	// GetHistory 获取指定时间之后的检测历史，按时间先后排序
+	GetHistory(since time.Time) ([]CheckRecord, error)
+	// PruneHistory 删除早于指定时间的检测历史，返回删除的条数
+	PruneHistory(before time.Time) (int64, error)
+	// GetUptimeStats 统计指定时间之后每个代理的可用率，可用率最低的排在前面
+	GetUptimeStats(since time.Time) ([]ProxyUptime, error)
+	// GetFailureBreakdown 统计指定代理在指定时间之后各类失败原因的次数
+	GetFailureBreakdown(ip string, port int, since time.Time) (map[string]int, error)
+
+	// AddUsage 将用量累加到对应用户与日期的记录上
+	AddUsage(usage []UserUsage) error
+	// GetUsage 获取 since 当天及之后的用户用量，日期格式为 2006-01-02，日期较新的排在前面
+	GetUsage(since string) ([]UserUsage, error)
+
+	// ExportProxies 导出全部代理，包括隔离区中的代理，按可信度从高到低排序
+	ExportProxies() ([]ProxyRecord, error)
+	// ImportProxies 导入代理，与已有代理冲突时按合并策略处理，返回写入的代理数量
+	ImportProxies(records []ProxyRecord, strategy string) (int, error)
+
+	// ApplyBatch 在一个事务中批量应用优先级变化并写入检测历史
+	ApplyBatch(deltas []PriorityDelta, records []CheckRecord) error
+
+	// Close 关闭存储，释放底层资源
+	Close() error
+}
+
+// 支持的存储类型
+const (
+	TypeSQLite   = "sqlite"
+	TypeMemory   = "memory"
+	TypePostgres = "postgres"
+)
+
+// Backuper 支持在线备份的存储，备份期间不影响正在运行的服务
+type Backuper interface {
+	// Backup 将数据库完整备份到 path
+	Backup(path string) error
+}
+
+// Open 根据存储类型打开代理存储，类型为空时使用 SQLite
+// dataSource 对 SQLite 而言是数据库文件路径，对 PostgreSQL 而言是连接串
+func Open(storageType, dataSource string) (Storage, error) {
+	switch storageType {
+	case TypeSQLite, "":
+		return NewProxyStorage(dataSource)
+	case TypePostgres:
+		return NewPostgresStorage(dataSource)
+	case TypeMemory:
+		return NewMemoryStorage(), nil
+	default:
+		return nil, fmt.Errorf("不支持的数据库类型: %s", storageType)
+	}
+}
+
+// 确保各实现满足 Storage 接口
+var (
+	_ Storage = (*ProxyStorage)(nil)
+	_ Storage = (*MemoryStorage)(nil)
+	_ Storage = (*BatchedStorage)(nil)
+)
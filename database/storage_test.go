@@ -0,0 +1,211 @@
+package database
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+// testStorageRoundTrip 对 Storage 的主要操作写入后再读出，检查各数据库的实现行为一致
+func testStorageRoundTrip(t *testing.T, s Storage) {
+	t.Helper()
+
+	must := func(err error) {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	must(s.UpsertProxy("10.1.0.1", 8080, "http", "中国", "北京", "北京"))
+	must(s.UpsertProxy("10.1.0.2", 1080, "socks5", "美国", "", ""))
+	must(s.UpsertProxy("10.1.0.3", 3128, "http", "中国", "上海", "上海"))
+	// 重复写入只更新位置信息
+	must(s.UpsertProxy("10.1.0.1", 8080, "http", "中国", "广东", "深圳"))
+
+	must(s.IncreasePriority("10.1.0.1", 8080, 50))
+	must(s.DecreasePriority("10.1.0.3", 3128, 30))
+	bandwidth, slow := 2048.0, 100.0
+	must(s.UpdateThroughput("10.1.0.2", 1080, &bandwidth))
+	must(s.UpdateUDPSupport("10.1.0.2", 1080, true))
+
+	proxies, err := s.GetActiveProxiesByPriorityLimit(10, 0)
+	must(err)
+	want := []string{"http://10.1.0.1:8080", "socks5://10.1.0.2:1080", "http://10.1.0.3:3128"}
+	if !slices.Equal(proxies, want) {
+		t.Errorf("按优先级排序为 %v，期望 %v", proxies, want)
+	}
+
+	// 带宽未知的代理不按最低带宽过滤
+	must(s.UpdateThroughput("10.1.0.3", 3128, &slow))
+	must(s.UpdateThroughput("10.1.0.1", 8080, &slow))
+	must(s.UpdateThroughput("10.1.0.1", 8080, nil))
+	proxies, err = s.GetActiveProxiesByPriorityLimit(10, 1024)
+	must(err)
+	if want := want[:2]; !slices.Equal(proxies, want) {
+		t.Errorf("最低带宽 1024 时为 %v，期望 %v", proxies, want)
+	}
+
+	udp, err := s.GetRandomUDPProxies(10)
+	must(err)
+	if !slices.Equal(udp, []string{"socks5://10.1.0.2:1080"}) {
+		t.Errorf("支持 UDP 的代理为 %v", udp)
+	}
+
+	china, other, err := s.GetCountryStatistics()
+	must(err)
+	if china != 2 || other != 1 {
+		t.Errorf("国家统计为 中国 %d、其他 %d，期望 2、1", china, other)
+	}
+
+	// 可信度限制在评分区间内
+	must(s.IncreasePriority("10.1.0.1", 8080, 10000))
+	must(s.DecreasePriority("10.1.0.3", 3128, 10000))
+	must(s.ApplyBatch([]PriorityDelta{{IP: "10.1.0.2", Port: 1080, Delta: 7, LastChecked: time.Now()}},
+		[]CheckRecord{{IP: "10.1.0.2", Port: 1080, Source: SourceCheck, Success: true, Latency: 80 * time.Millisecond}}))
+	must(s.RecordCheck(CheckRecord{IP: "10.1.0.3", Port: 3128, Source: SourceTraffic, Target: "example.com:443", ErrorClass: "timeout"}))
+
+	records, err := s.ExportProxies()
+	must(err)
+	priorities := make(map[string]int)
+	for _, record := range records {
+		priorities[record.IP] = record.Priority
+		if record.IP == "10.1.0.1" && record.City != "深圳" {
+			t.Errorf("重复写入后城市为 %q，期望 深圳", record.City)
+		}
+	}
+	for ip, priority := range map[string]int{"10.1.0.1": 200, "10.1.0.2": 107, "10.1.0.3": 0} {
+		if priorities[ip] != priority {
+			t.Errorf("%s 的优先级为 %d，期望 %d", ip, priorities[ip], priority)
+		}
+	}
+
+	history, err := s.GetHistory(time.Now().Add(-time.Hour))
+	must(err)
+	if len(history) != 2 || history[0].Latency != 80*time.Millisecond || history[1].ErrorClass != "timeout" {
+		t.Errorf("检测历史为 %+v", history)
+	}
+
+	quarantined, err := s.QuarantineLowPriorityProxies()
+	must(err)
+	if quarantined != 1 {
+		t.Errorf("隔离了 %d 个代理，期望 1 个", quarantined)
+	}
+	inQuarantine, err := s.GetQuarantinedProxies()
+	must(err)
+	if len(inQuarantine) != 1 || inQuarantine[0].IP != "10.1.0.3" {
+		t.Fatalf("隔离区为 %+v", inQuarantine)
+	}
+	count, err := s.GetProxyCount()
+	must(err)
+	if count != 2 {
+		t.Errorf("可用代理为 %d 个，期望 2 个", count)
+	}
+
+	must(s.PromoteProxy("10.1.0.2", 1080, "socks5"))
+	counts, err := s.GetPoolCounts()
+	must(err)
+	groups := make(map[PoolCount]bool)
+	for _, count := range counts {
+		groups[count] = true
+	}
+	wantGroups := []PoolCount{
+		{Country: "中国", Protocol: "http", Active: true, Count: 1},
+		{Country: "美国", Protocol: "socks5", Active: true, Premium: true, Count: 1},
+		{Country: "中国", Protocol: "http", Count: 1},
+	}
+	for _, group := range wantGroups {
+		if !groups[group] {
+			t.Errorf("分组统计 %+v 中没有 %+v", counts, group)
+		}
+	}
+	if len(counts) != len(wantGroups) {
+		t.Errorf("分组统计为 %+v，期望 %d 组", counts, len(wantGroups))
+	}
+	restored, err := s.RestoreProxy("10.1.0.3", 3128, 50)
+	must(err)
+	if restored != 1 {
+		t.Errorf("恢复了 %d 个代理，期望 1 个", restored)
+	}
+	// 不在隔离区中的代理不会被恢复
+	restored, err = s.RestoreProxy("10.1.0.3", 3128, 50)
+	must(err)
+	if restored != 0 {
+		t.Errorf("重复恢复了 %d 个代理，期望 0 个", restored)
+	}
+	count, err = s.GetProxyCount()
+	must(err)
+	if count != 3 {
+		t.Errorf("恢复后可用代理为 %d 个，期望 3 个", count)
+	}
+
+	must(s.AddUsage([]UserUsage{{User: "alice", Day: "2024-01-02", Requests: 1, BytesIn: 10, BytesOut: 20}}))
+	must(s.AddUsage([]UserUsage{
+		{User: "alice", Day: "2024-01-02", Requests: 2, BytesIn: 1, BytesOut: 2},
+		{User: "bob", Day: "2024-01-01", Requests: 5},
+	}))
+	usage, err := s.GetUsage("2024-01-02")
+	must(err)
+	if len(usage) != 1 || usage[0] != (UserUsage{User: "alice", Day: "2024-01-02", Requests: 3, BytesIn: 11, BytesOut: 22}) {
+		t.Errorf("用量为 %+v", usage)
+	}
+
+	lastDecay, err := s.GetLastDecay()
+	must(err)
+	if !lastDecay.IsZero() {
+		t.Errorf("从未衰减时上一次衰减的时间为 %v", lastDecay)
+	}
+	decayedAt := time.Date(2024, 1, 2, 3, 4, 5, 6000, time.Local)
+	must(s.SetLastDecay(decayedAt.Add(-time.Hour)))
+	must(s.SetLastDecay(decayedAt))
+	lastDecay, err = s.GetLastDecay()
+	must(err)
+	if !lastDecay.Equal(decayedAt) {
+		t.Errorf("上一次衰减的时间为 %v，期望 %v", lastDecay, decayedAt)
+	}
+
+	deleted, err := s.DeleteProxy("10.1.0.2", 1080)
+	must(err)
+	if deleted != 1 {
+		t.Errorf("删除了 %d 个代理，期望 1 个", deleted)
+	}
+}
+
+func TestSQLiteStorageRoundTrip(t *testing.T) {
+	ps, _ := newTestSQLite(t, 0)
+	defer ps.Close()
+
+	testStorageRoundTrip(t, ps)
+}
+
+func TestMemoryStorageRoundTrip(t *testing.T) {
+	testStorageRoundTrip(t, NewMemoryStorage())
+}
+
+// TestSQLiteClampsLegacyPriorities 旧版本数据库中超出评分区间的可信度在打开时被限制在区间内
+func TestSQLiteClampsLegacyPriorities(t *testing.T) {
+	ps, path := newTestSQLite(t, 3)
+	for ip, priority := range map[string]int{testIP(0): 1460, testIP(1): -20, testIP(2): 150} {
+		if _, err := ps.db.Exec(`UPDATE proxies SET priority = ? WHERE ip = ?;`, priority, ip); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ps.Close()
+
+	reopened, err := NewProxyStorage(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+
+	records, err := reopened.ExportProxies()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]int{testIP(0): 200, testIP(1): 0, testIP(2): 150}
+	for _, record := range records {
+		if record.Priority != want[record.IP] {
+			t.Errorf("%s 的优先级为 %d，期望 %d", record.IP, record.Priority, want[record.IP])
+		}
+	}
+}
@@ -1,7 +1,77 @@
 package database
 
-import "database/sql"
+import (
+	"database/sql"
+	"proxychain/common"
+	"time"
+)
 
+// ProxyStorage 基于 SQL 数据库的代理存储，目前支持 SQLite 与 PostgreSQL
 type ProxyStorage struct {
-	db *sql.DB
+	db      *sql.DB
+	dialect *dialect
+}
+
+// 检测历史的来源
+const (
+	SourceCheck   = "check"   // 定时健康检测
+	SourceTraffic = "traffic" // 实际转发的流量
+)
+
+// CheckRecord 表示一条检测历史记录
+type CheckRecord struct {
+	CheckedAt  time.Time
+	IP         string
+	Port       int
+	Source     string // 记录来源，SourceCheck 或 SourceTraffic
+	Target     string // 访问的目标地址
+	Success    bool
+	Latency    time.Duration // 成功时的响应耗时
+	ErrorClass string        // 失败时的错误分类
+}
+
+// ProxyUptime 表示单个代理在统计窗口内的可用率
+type ProxyUptime struct {
+	IP         string
+	Port       int
+	Total      int           // 记录总数
+	Successes  int           // 成功次数
+	Uptime     float64       // 可用率百分比
+	AvgLatency time.Duration // 成功请求的平均耗时
+}
+
+// PriorityDelta 表示一个代理累积的优先级变化，用于批量写入
+type PriorityDelta struct {
+	IP          string
+	Port        int
+	Delta       int       // 正数为增加，负数为扣减
+	LastChecked time.Time // 最近一次变化的时间
+}
+
+// TierCandidate 表示评估高级代理池晋升与降级所需的代理信息
+type TierCandidate struct {
+	IP        string
+	Port      int
+	Protocol  string
+	Priority  int
+	Active    bool
+	Premium   bool // 当前是否在高级代理池中
+	Total     int  // 统计窗口内的检测与流量记录数
+	Successes int  // 其中成功的次数
+}
+
+// UserUsage 表示一个用户在一天内的用量
+type UserUsage struct {
+	User     string `json:"user"`
+	Day      string `json:"day"` // 本地日期，格式为 2006-01-02
+	Requests int64  `json:"requests"`
+	BytesIn  int64  `json:"bytes_in"`  // 从客户端读取的字节数
+	BytesOut int64  `json:"bytes_out"` // 写给客户端的字节数
+}
+
+// QuarantinedProxy 表示隔离区中的一个代理
+type QuarantinedProxy struct {
+	common.ProxyBase
+	QuarantinedAt time.Time // 进入隔离区的时间
+	Reason        string    // 隔离原因，通常为最近一次失败的错误分类
 }
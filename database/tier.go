@@ -0,0 +1,137 @@
+package database
+
+import (
+	"fmt"
+	"time"
+)
+
+// 高级代理池相关的 SQL 语句，high_proiority_proxies 表中的代理即为高级代理
+var (
+	deduplicateHighPriorityProxiesQuery = `
+		DELETE FROM high_proiority_proxies
+		WHERE id NOT IN (
+			SELECT MIN(id) FROM high_proiority_proxies
+			GROUP BY ip, port, protocol
+		);
+	`
+	createHighPriorityUniqueIndexQuery = `
+		CREATE UNIQUE INDEX IF NOT EXISTS idx_high_priority_address
+		ON high_proiority_proxies (ip, port, protocol);
+	`
+	tierCandidatesQuery = `
+		SELECT p.ip, p.port, p.protocol, p.priority, p.is_active,
+			CASE WHEN h.id IS NULL THEN 0 ELSE 1 END AS premium,
+			COALESCE(c.total, 0), COALESCE(c.successes, 0)
+		FROM proxies p
+		LEFT JOIN high_proiority_proxies h
+			ON h.ip = p.ip AND h.port = p.port AND h.protocol = p.protocol
+		LEFT JOIN (
+			SELECT ip, port,
+				COUNT(*) AS total,
+				SUM(CASE WHEN success THEN 1 ELSE 0 END) AS successes
+			FROM check_history
+			WHERE checked_at >= ?
+			GROUP BY ip, port
+		) c ON c.ip = p.ip AND c.port = p.port;
+	`
+	// last_checked 记录代理晋升的时间，优先级等信息以 proxies 表为准
+	promoteProxyQuery = `
+		INSERT INTO high_proiority_proxies (ip, port, protocol, country, province, city, priority, last_checked)
+		SELECT ip, port, protocol, country, province, city, priority, ?
+		FROM proxies
+		WHERE ip = ? AND port = ? AND protocol = ?
+		ON CONFLICT (ip, port, protocol) DO NOTHING;
+	`
+	demoteProxyQuery = `
+		DELETE FROM high_proiority_proxies
+		WHERE ip = ? AND port = ? AND protocol = ?;
+	`
+	deleteOrphanPremiumProxiesQuery = `
+		DELETE FROM high_proiority_proxies
+		WHERE NOT EXISTS (
+			SELECT 1 FROM proxies p
+			WHERE p.ip = high_proiority_proxies.ip AND p.port = high_proiority_proxies.port
+				AND p.protocol = high_proiority_proxies.protocol
+		);
+	`
+	getPremiumProxiesQuery = `
+		SELECT p.ip, p.port, p.protocol
+		FROM high_proiority_proxies h
+		JOIN proxies p ON p.ip = h.ip AND p.port = h.port AND p.protocol = h.protocol
+		WHERE p.is_active AND (CAST(? AS TEXT) = '' OR p.country = ?) AND (p.bandwidth IS NULL OR p.bandwidth >= ?)
+		ORDER BY p.priority DESC
+		LIMIT ?;
+	`
+	getPremiumCountQuery = `
+		SELECT COUNT(*)
+		FROM high_proiority_proxies h
+		JOIN proxies p ON p.ip = h.ip AND p.port = h.port AND p.protocol = h.protocol
+		WHERE p.is_active;
+	`
+)
+
+// GetTierCandidates 返回所有代理的优先级、所属层级以及指定时间之后的检测统计，用于评估晋升与降级
+func (ps *ProxyStorage) GetTierCandidates(since time.Time) ([]TierCandidate, error) {
+	rows, err := ps.query(tierCandidatesQuery, since.UTC())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var candidates []TierCandidate
+	for rows.Next() {
+		var candidate TierCandidate
+		var premium int
+		err := rows.Scan(&candidate.IP, &candidate.Port, &candidate.Protocol, &candidate.Priority,
+			&candidate.Active, &premium, &candidate.Total, &candidate.Successes)
+		if err != nil {
+			return nil, err
+		}
+		candidate.Premium = premium == 1
+		candidates = append(candidates, candidate)
+	}
+
+	return candidates, rows.Err()
+}
+
+// PromoteProxy 将代理加入高级代理池，已在池中时不做任何操作
+func (ps *ProxyStorage) PromoteProxy(ip string, port int, protocol string) error {
+	_, err := ps.exec(promoteProxyQuery, time.Now(), ip, port, protocol)
+	return err
+}
+
+// DemoteProxy 将代理移出高级代理池
+func (ps *ProxyStorage) DemoteProxy(ip string, port int, protocol string) error {
+	_, err := ps.exec(demoteProxyQuery, ip, port, protocol)
+	return err
+}
+
+// GetPremiumProxies 获取按优先级排序的高级代理，country 为空时不限制国家，并过滤带宽不足的代理
+func (ps *ProxyStorage) GetPremiumProxies(limit int, country string, minBandwidth float64) ([]string, error) {
+	rows, err := ps.query(getPremiumProxiesQuery, country, country, minBandwidth, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var proxies []string
+	for rows.Next() {
+		var ip, protocol string
+		var port int
+		err := rows.Scan(&ip, &port, &protocol)
+		if err != nil {
+			return nil, err
+		}
+		fullURL := fmt.Sprintf("%s://%s:%d", protocol, ip, port)
+		proxies = append(proxies, fullURL)
+	}
+
+	return proxies, rows.Err()
+}
+
+// GetPremiumCount 获取高级代理池中可用代理的数量
+func (ps *ProxyStorage) GetPremiumCount() (int, error) {
+	var count int
+	err := ps.queryRow(getPremiumCountQuery).Scan(&count)
+	return count, err
+}
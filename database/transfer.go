@@ -0,0 +1,186 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// 导入代理时与已有代理冲突的合并策略
+const (
+	MergeHigher    = "higher"    // 保留可信度更高的一方
+	MergeOverwrite = "overwrite" // 使用导入的数据覆盖
+	MergeSkip      = "skip"      // 保留已有的数据
+)
+
+// ErrBackupUnsupported 当前存储类型不支持在线备份
+var ErrBackupUnsupported = errors.New("当前数据库类型不支持在线备份")
+
+// ProxyRecord 表示一条完整的代理记录，用于导出与导入
+type ProxyRecord struct {
+	IP               string     `json:"ip"`
+	Port             int        `json:"port"`
+	Protocol         string     `json:"protocol"`
+	Country          string     `json:"country"`
+	Province         string     `json:"province"`
+	City             string     `json:"city"`
+	Active           bool       `json:"active"`
+	Priority         int        `json:"priority"`
+	Premium          bool       `json:"premium"`
+	LastChecked      *time.Time `json:"last_checked,omitempty"`
+	Bandwidth        *float64   `json:"bandwidth,omitempty"` // 字节每秒
+	UDP              *bool      `json:"udp,omitempty"`
+	QuarantinedAt    *time.Time `json:"quarantined_at,omitempty"`
+	QuarantineReason string     `json:"quarantine_reason,omitempty"`
+}
+
+// 导出与导入相关的 SQL 语句
+var (
+	exportProxiesQuery = `
+		SELECT p.ip, p.port, p.protocol, p.country, p.province, p.city, p.is_active, p.priority,
+			CASE WHEN h.id IS NULL THEN 0 ELSE 1 END AS premium,
+			p.last_checked, p.bandwidth, p.udp, p.quarantined_at, p.quarantine_reason
+		FROM proxies p
+		LEFT JOIN high_proiority_proxies h
+			ON h.ip = p.ip AND h.port = p.port AND h.protocol = p.protocol
+		ORDER BY p.priority DESC, p.id ASC;
+	`
+	// %s 为冲突时的处理方式，由合并策略决定
+	importProxyQuery = `
+		INSERT INTO proxies (ip, port, protocol, country, province, city, is_active, priority,
+			last_checked, bandwidth, udp, quarantined_at, quarantine_reason)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (ip, port, protocol) DO %s;
+	`
+	importOverwriteClause = `UPDATE
+		SET country = excluded.country, province = excluded.province, city = excluded.city,
+			is_active = excluded.is_active, priority = excluded.priority, last_checked = excluded.last_checked,
+			bandwidth = excluded.bandwidth, udp = excluded.udp,
+			quarantined_at = excluded.quarantined_at, quarantine_reason = excluded.quarantine_reason`
+	backupQuery = `VACUUM INTO ?;`
+)
+
+// ExportProxies 导出全部代理，包括隔离区中的代理，按可信度从高到低排序
+func (ps *ProxyStorage) ExportProxies() ([]ProxyRecord, error) {
+	rows, err := ps.query(exportProxiesQuery)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var records []ProxyRecord
+	for rows.Next() {
+		var record ProxyRecord
+		var country, province, city, reason *string
+		var premium int
+		var lastChecked, quarantinedAt sql.NullTime
+		err := rows.Scan(&record.IP, &record.Port, &record.Protocol, &country, &province, &city,
+			&record.Active, &record.Priority, &premium, &lastChecked, &record.Bandwidth, &record.UDP,
+			&quarantinedAt, &reason)
+		if err != nil {
+			return nil, err
+		}
+		record.Country, record.Province, record.City = stringOrEmpty(country), stringOrEmpty(province), stringOrEmpty(city)
+		record.QuarantineReason = stringOrEmpty(reason)
+		record.Premium = premium == 1
+		if lastChecked.Valid {
+			record.LastChecked = &lastChecked.Time
+		}
+		if quarantinedAt.Valid {
+			record.QuarantinedAt = &quarantinedAt.Time
+		}
+		records = append(records, record)
+	}
+
+	return records, rows.Err()
+}
+
+// ImportProxies 在一个事务中导入代理，与已有代理冲突时按 strategy 合并，返回写入的代理数量
+// 导入文件可能来自评分区间不同的实例或被手工修改，可信度先限制在当前的评分区间内再写入与比较
+func (ps *ProxyStorage) ImportProxies(records []ProxyRecord, strategy string) (int, error) {
+	var conflict string
+	switch strategy {
+	case MergeHigher:
+		conflict = importOverwriteClause + " WHERE excluded.priority > proxies.priority"
+	case MergeOverwrite:
+		conflict = importOverwriteClause
+	case MergeSkip:
+		conflict = "NOTHING"
+	default:
+		return 0, fmt.Errorf("不支持的合并策略: %s", strategy)
+	}
+
+	tx, err := ps.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	statements := make(map[string]*sql.Stmt)
+	for _, query := range []string{fmt.Sprintf(importProxyQuery, conflict), promoteProxyQuery, demoteProxyQuery} {
+		stmt, err := tx.Prepare(ps.dialect.rebind(query))
+		if err != nil {
+			return 0, err
+		}
+		defer stmt.Close()
+		statements[query] = stmt
+	}
+	importStmt := statements[fmt.Sprintf(importProxyQuery, conflict)]
+
+	var imported int
+	now := time.Now()
+	for _, record := range records {
+		result, err := importStmt.Exec(record.IP, record.Port, record.Protocol, record.Country, record.Province,
+			record.City, record.Active, clampPriority(record.Priority), timeOrNil(record.LastChecked), record.Bandwidth,
+			record.UDP, timeOrNil(record.QuarantinedAt), nullIfEmpty(record.QuarantineReason))
+		if err != nil {
+			return 0, fmt.Errorf("导入代理 %s:%d 失败: %w", record.IP, record.Port, err)
+		}
+		affected, err := result.RowsAffected()
+		if err != nil {
+			return 0, err
+		}
+		if affected == 0 {
+			continue
+		}
+
+		// 写入成功的代理同步其所属的层级
+		if record.Premium {
+			_, err = statements[promoteProxyQuery].Exec(now, record.IP, record.Port, record.Protocol)
+		} else {
+			_, err = statements[demoteProxyQuery].Exec(record.IP, record.Port, record.Protocol)
+		}
+		if err != nil {
+			return 0, err
+		}
+		imported++
+	}
+
+	return imported, tx.Commit()
+}
+
+// Backup 在不停止服务的情况下将 SQLite 数据库备份到 path，PostgreSQL 请使用 pg_dump
+func (ps *ProxyStorage) Backup(path string) error {
+	if ps.dialect != sqliteDialect {
+		return ErrBackupUnsupported
+	}
+	_, err := ps.exec(backupQuery, path)
+	return err
+}
+
+// timeOrNil 将可选的时间转换为数据库参数，时间统一使用 UTC 存储
+func timeOrNil(t *time.Time) any {
+	if t == nil {
+		return nil
+	}
+	return t.UTC()
+}
+
+// nullIfEmpty 空字符串写入为 NULL
+func nullIfEmpty(s string) any {
+	if s == "" {
+		return nil
+	}
+	return s
+}
@@ -0,0 +1,75 @@
+package database
+
+import "testing"
+
+// TestImportProxiesClampsPriorities 导入的可信度先限制在评分区间 [0, 200] 内，再按合并策略与已有代理比较
+func TestImportProxiesClampsPriorities(t *testing.T) {
+	records := []ProxyRecord{
+		{IP: "10.2.0.1", Port: 80, Protocol: "http", Active: true, Priority: 1460},
+		{IP: "10.2.0.2", Port: 80, Protocol: "http", Active: true, Priority: -20},
+		{IP: "10.2.0.3", Port: 80, Protocol: "http", Active: true, Priority: 120},
+		{IP: "10.2.0.4", Port: 80, Protocol: "http", Active: true, Priority: 500},
+	}
+
+	tests := []struct {
+		strategy string
+		imported int
+		want     map[string]int
+	}{
+		{MergeHigher, 2, map[string]int{"10.2.0.1": 200, "10.2.0.2": 0, "10.2.0.3": 150, "10.2.0.4": 200}},
+		{MergeOverwrite, 4, map[string]int{"10.2.0.1": 200, "10.2.0.2": 0, "10.2.0.3": 120, "10.2.0.4": 200}},
+		{MergeSkip, 1, map[string]int{"10.2.0.1": 100, "10.2.0.2": 0, "10.2.0.3": 150, "10.2.0.4": 200}},
+	}
+
+	backends := map[string]func(t *testing.T) Storage{
+		"sqlite": func(t *testing.T) Storage {
+			ps, _ := newTestSQLite(t, 0)
+			return ps
+		},
+		"memory": func(t *testing.T) Storage { return NewMemoryStorage() },
+	}
+
+	for backend, open := range backends {
+		for _, tt := range tests {
+			t.Run(backend+"/"+tt.strategy, func(t *testing.T) {
+				s := open(t)
+				defer s.Close()
+
+				// 已有代理的可信度分别为 100、150 与上限 200
+				for _, ip := range []string{"10.2.0.1", "10.2.0.3", "10.2.0.4"} {
+					if err := s.UpsertProxy(ip, 80, "http", "", "", ""); err != nil {
+						t.Fatal(err)
+					}
+				}
+				if err := s.IncreasePriority("10.2.0.3", 80, 50); err != nil {
+					t.Fatal(err)
+				}
+				if err := s.IncreasePriority("10.2.0.4", 80, 150); err != nil {
+					t.Fatal(err)
+				}
+
+				imported, err := s.ImportProxies(records, tt.strategy)
+				if err != nil {
+					t.Fatalf("导入失败: %v", err)
+				}
+				if imported != tt.imported {
+					t.Errorf("写入 %d 个代理，期望 %d 个", imported, tt.imported)
+				}
+
+				exported, err := s.ExportProxies()
+				if err != nil {
+					t.Fatal(err)
+				}
+				got := make(map[string]int)
+				for _, record := range exported {
+					got[record.IP] = record.Priority
+				}
+				for ip, want := range tt.want {
+					if got[ip] != want {
+						t.Errorf("%s 的可信度为 %d，期望 %d", ip, got[ip], want)
+					}
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package database
+
+import (
+	"sort"
+)
+
+// 用户用量相关的 SQL 语句，SQLite 与 PostgreSQL 通用
+var (
+	createUsageTableQuery = `
+		CREATE TABLE IF NOT EXISTS user_usage (
+			username TEXT NOT NULL,
+			day TEXT NOT NULL,
+			requests BIGINT NOT NULL DEFAULT 0,
+			bytes_in BIGINT NOT NULL DEFAULT 0,
+			bytes_out BIGINT NOT NULL DEFAULT 0,
+			PRIMARY KEY (username, day)
+		);
+	`
+	addUsageQuery = `
+		INSERT INTO user_usage (username, day, requests, bytes_in, bytes_out)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (username, day) DO UPDATE
+		SET requests = user_usage.requests + excluded.requests,
+			bytes_in = user_usage.bytes_in + excluded.bytes_in,
+			bytes_out = user_usage.bytes_out + excluded.bytes_out;
+	`
+	getUsageQuery = `
+		SELECT username, day, requests, bytes_in, bytes_out
+		FROM user_usage
+		WHERE day >= ?
+		ORDER BY day DESC, username ASC;
+	`
+)
+
+// AddUsage 在一个事务中将用量累加到对应用户与日期的记录上
+func (ps *ProxyStorage) AddUsage(usage []UserUsage) error {
+	if len(usage) == 0 {
+		return nil
+	}
+
+	tx, err := ps.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(ps.dialect.rebind(addUsageQuery))
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, u := range usage {
+		if _, err := stmt.Exec(u.User, u.Day, u.Requests, u.BytesIn, u.BytesOut); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// GetUsage 获取 since 当天及之后的用户用量，日期较新的排在前面
+func (ps *ProxyStorage) GetUsage(since string) ([]UserUsage, error) {
+	rows, err := ps.query(getUsageQuery, since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var usage []UserUsage
+	for rows.Next() {
+		var u UserUsage
+		if err := rows.Scan(&u.User, &u.Day, &u.Requests, &u.BytesIn, &u.BytesOut); err != nil {
+			return nil, err
+		}
+		usage = append(usage, u)
+	}
+	return usage, rows.Err()
+}
+
+// AddUsage 将用量累加到对应用户与日期的记录上
+func (ms *MemoryStorage) AddUsage(usage []UserUsage) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if ms.usage == nil {
+		ms.usage = make(map[[2]string]*UserUsage)
+	}
+	for _, u := range usage {
+		key := [2]string{u.User, u.Day}
+		total, ok := ms.usage[key]
+		if !ok {
+			total = &UserUsage{User: u.User, Day: u.Day}
+			ms.usage[key] = total
+		}
+		total.Requests += u.Requests
+		total.BytesIn += u.BytesIn
+		total.BytesOut += u.BytesOut
+	}
+	return nil
+}
+
+// GetUsage 获取 since 当天及之后的用户用量，日期较新的排在前面
+func (ms *MemoryStorage) GetUsage(since string) ([]UserUsage, error) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	var usage []UserUsage
+	for _, u := range ms.usage {
+		if u.Day >= since {
+			usage = append(usage, *u)
+		}
+	}
+	sort.Slice(usage, func(i, j int) bool {
+		if usage[i].Day != usage[j].Day {
+			return usage[i].Day > usage[j].Day
+		}
+		return usage[i].User < usage[j].User
+	})
+	return usage, nil
+}
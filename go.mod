@@ -3,14 +3,11 @@ module proxychain
 go 1.22
 
 require (
+	github.com/kayon/iploc v0.0.0-20200312105652-bda3e968a794
+	github.com/lib/pq v1.10.9
 	github.com/mattn/go-sqlite3 v1.14.22
 	golang.org/x/net v0.28.0
 	gopkg.in/yaml.v2 v2.4.0
 )
 
-require (
-	github.com/google/btree v1.1.3 // indirect
-	github.com/kayon/iploc v0.0.0-20200312105652-bda3e968a794 // indirect
-	github.com/spf13/pflag v1.0.5 // indirect
-	golang.org/x/text v0.17.0 // indirect
-)
+require github.com/google/btree v1.1.3 // indirect
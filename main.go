@@ -2,6 +2,8 @@ package main
 
 import (
 	"embed"
+	"errors"
+	"flag"
 	"fmt"
 	_ "github.com/mattn/go-sqlite3" // 导入 SQLite 驱动
 	"os"
@@ -12,14 +14,37 @@ import (
 const filePath = "data/czutf8.dat"
 
 func main() {
-	// 加载配置文件
-	common.LoadConfig()
+	// 第一个不属于 --config 与 --db 的参数为子命令，不指定子命令时启动代理服务
+	name, args := core.SplitCommand(os.Args[1:])
+	if name == "help" {
+		core.PrintUsage(os.Stdout)
+		return
+	}
+
+	command, ok := core.FindCommand(name)
+	if !ok {
+		fmt.Fprintf(os.Stderr, "未知的子命令: %s\n\n", name)
+		core.PrintUsage(os.Stderr)
+		os.Exit(2)
+	}
+
+	// 加载配置文件，--db 覆盖配置文件中的数据库
+	configPath, dbPath := core.GlobalFlags(args)
+	common.LoadConfig(configPath)
+	core.OverrideDatabase(dbPath)
 
 	// 加载ip数据库
-	checkData()
+	if command.IPData {
+		checkData()
+	}
 
-	// 启动pipeline
-	core.StartPipeline()
+	if err := command.Run(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return
+		}
+		fmt.Fprintf(os.Stderr, "%s 失败: %v\n", name, err)
+		os.Exit(1)
+	}
 }
 
 func checkData() {
@@ -2,13 +2,14 @@ package proxyPool
 
 import (
 	"bufio"
+	"context"
 	"errors"
 	"fmt"
 	"golang.org/x/net/proxy"
 	"net"
 	"net/http"
 	"net/url"
-	"sync"
+	"proxychain/common"
 	"time"
 )
 
@@ -17,80 +18,86 @@ type ProxyCheckResult struct {
 	ProxyAddr  string
 	Success    bool
 	SuccessURL string
+	Latency    time.Duration // 成功请求的耗时
+	Probed     bool          // 本轮是否进行了吞吐量探测
+	Throughput *float64      // 探测到的带宽，单位字节每秒，探测失败时为 nil 表示未知
+	UDPChecked bool          // 本轮是否进行了 UDP 能力检测
+	UDP        bool          // 是否支持 UDP ASSOCIATE
 	Error      error
 }
 
-// CheckProxy 并发检测代理的可用性，并返回结构化的检测结果
-func CheckProxy(proxies []string, targetURLs []string) []ProxyCheckResult {
-	var wg sync.WaitGroup
-	results := make(chan ProxyCheckResult, len(proxies))
-
-	for _, proxyAddr := range proxies {
-		wg.Add(1)
-		go func(proxyAddr string) {
-			defer wg.Done()
-			result := checkProxy(proxyAddr, targetURLs)
-			results <- result
-		}(proxyAddr)
-	}
-
-	// 等待所有 goroutines 完成
-	wg.Wait()
-	close(results)
-
-	// 收集结果
-	var finalResults []ProxyCheckResult
-	for result := range results {
-		finalResults = append(finalResults, result)
-		fmt.Println(formatResult(result)) // 输出结果
+// CheckProxy 使用有限的工作协程检测代理的可用性，并返回结构化的检测结果
+// ctx 取消后不再开始新的检测，只返回已经完成的结果
+func CheckProxy(ctx context.Context, proxies []string, targetURLs []string) []ProxyCheckResult {
+	cfg := common.Current().Checker
+	results := checkConcurrently(ctx, proxies, targetURLs,
+		positiveOr(cfg.Concurrency, defaultCheckConcurrency), secondsOr(cfg.Timeout, defaultCheckTimeout))
+
+	for _, result := range results {
+		if result.Success {
+			checkerLog.Debug("代理可用", "proxy", result.ProxyAddr, "url", result.SuccessURL, "latency", result.Latency)
+		} else {
+			checkerLog.Debug("代理不可用", "proxy", result.ProxyAddr, "error", result.Error)
+		}
 	}
 
-	return finalResults
+	return results
 }
 
 // checkProxy 根据传入的代理地址检测其可用性，并返回结构化的结果
-func checkProxy(proxyAddr string, targetURLs []string) ProxyCheckResult {
+func checkProxy(ctx context.Context, proxyAddr string, targetURLs []string, timeout time.Duration) ProxyCheckResult {
 	parsedURL, err := url.Parse(proxyAddr)
 	if err != nil {
 		return ProxyCheckResult{ProxyAddr: proxyAddr, Success: false, Error: fmt.Errorf("解析代理URL失败: %w", err)}
 	}
 
-	dialer, err := createDialer(parsedURL)
+	dialer, err := createDialer(parsedURL, timeout)
 	if err != nil {
 		return ProxyCheckResult{ProxyAddr: proxyAddr, Success: false, Error: err}
 	}
 
+	var lastErr error
 	for _, targetURL := range targetURLs {
-		if err := tryRequest(targetURL, dialer); err == nil {
-			return ProxyCheckResult{ProxyAddr: proxyAddr, Success: true, SuccessURL: targetURL}
+		start := time.Now()
+		if lastErr = tryRequest(ctx, targetURL, dialer, timeout); lastErr == nil {
+			return ProxyCheckResult{ProxyAddr: proxyAddr, Success: true, SuccessURL: targetURL, Latency: time.Since(start)}
 		}
 	}
 
-	return ProxyCheckResult{ProxyAddr: proxyAddr, Success: false, Error: errors.New("所有目标请求均失败")}
+	if lastErr == nil {
+		lastErr = errors.New("没有可用的检测目标")
+	}
+	return ProxyCheckResult{ProxyAddr: proxyAddr, Success: false, Error: fmt.Errorf("所有目标请求均失败: %w", lastErr)}
 }
 
-// createDialer 根据代理协议创建对应的拨号器
-func createDialer(parsedURL *url.URL) (proxy.Dialer, error) {
+// createDialer 根据代理协议创建对应的拨号器，连接代理服务器时使用给定的超时时间
+func createDialer(parsedURL *url.URL, timeout time.Duration) (proxy.Dialer, error) {
 	switch parsedURL.Scheme {
 	case "socks5":
-		return proxy.SOCKS5("tcp", parsedURL.Host, nil, proxy.Direct)
+		return proxy.SOCKS5("tcp", parsedURL.Host, nil, &net.Dialer{Timeout: timeout})
 	case "http":
-		return &httpProxyDialer{proxyURL: parsedURL.String()}, nil
+		return &httpProxyDialer{proxyURL: parsedURL.String(), timeout: timeout}, nil
 	default:
 		return nil, errors.New("不支持的代理协议: " + parsedURL.Scheme)
 	}
 }
 
-// tryRequest 尝试通过代理请求目标 URL
-func tryRequest(targetURL string, dialer proxy.Dialer) error {
+// tryRequest 尝试通过代理请求目标 URL，ctx 取消时请求随之中止
+func tryRequest(ctx context.Context, targetURL string, dialer proxy.Dialer, timeout time.Duration) error {
 	client := &http.Client{
 		Transport: &http.Transport{
 			Dial: dialer.Dial,
+			// 每次检测都是一次性连接，关闭长连接避免空闲连接占用文件描述符
+			DisableKeepAlives: true,
 		},
-		Timeout: 10 * time.Second,
+		Timeout: timeout,
 	}
 
-	resp, err := client.Get(targetURL)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, targetURL, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
 	if err != nil {
 		return fmt.Errorf("请求 %s 失败: %w", targetURL, err)
 	}
@@ -106,6 +113,7 @@ func tryRequest(targetURL string, dialer proxy.Dialer) error {
 // httpProxyDialer 自定义的HTTP代理Dialer
 type httpProxyDialer struct {
 	proxyURL string
+	timeout  time.Duration
 }
 
 func (d *httpProxyDialer) Dial(network, addr string) (net.Conn, error) {
@@ -114,11 +122,14 @@ func (d *httpProxyDialer) Dial(network, addr string) (net.Conn, error) {
 		return nil, err
 	}
 
-	conn, err := net.Dial("tcp", proxyURL.Host)
+	conn, err := net.DialTimeout("tcp", proxyURL.Host, d.timeout)
 	if err != nil {
 		return nil, err
 	}
 
+	// 握手阶段同样受超时限制，避免无响应的代理长期占用连接
+	conn.SetDeadline(time.Now().Add(d.timeout))
+
 	req := &http.Request{
 		Method: "CONNECT",
 		URL:    &url.URL{Host: addr},
@@ -140,16 +151,9 @@ func (d *httpProxyDialer) Dial(network, addr string) (net.Conn, error) {
 
 	if resp.StatusCode != http.StatusOK {
 		conn.Close()
-		return nil, errors.New("HTTP 代理连接失败: " + resp.Status)
+		return nil, &common.ConnectError{StatusCode: resp.StatusCode, Status: resp.Status}
 	}
 
+	conn.SetDeadline(time.Time{})
 	return conn, nil
 }
-
-// formatResult 格式化结果输出
-func formatResult(result ProxyCheckResult) string {
-	if result.Success {
-		return fmt.Sprintf("代理 %s 可用，成功访问: %s", result.ProxyAddr, result.SuccessURL)
-	}
-	return fmt.Sprintf("代理 %s 不可用: %v", result.ProxyAddr, result.Error)
-}
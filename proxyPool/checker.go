@@ -0,0 +1,315 @@
+package proxyPool
+
+import (
+	"context"
+	"proxychain/common"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// checkerLog 健康检测、吞吐量探测与 UDP 检测的日志
+var checkerLog = common.Logger(common.LogChecker)
+
+// 健康检测的默认参数，配置缺省时使用
+const (
+	defaultCheckConcurrency = 50
+	defaultCheckTimeout     = 10 * time.Second
+	defaultFreshInterval    = 1 * time.Minute
+	defaultCheckInterval    = 5 * time.Minute
+	defaultMaxBackoff       = 1 * time.Hour
+
+	// freshSuccessCount 连续成功达到该次数之前，代理被视为新代理，使用较短的复检间隔
+	freshSuccessCount = 3
+)
+
+// proxyHealth 记录单个代理的检测状态
+type proxyHealth struct {
+	nextCheck time.Time // 下一次检测的时间
+	nextProbe time.Time // 下一次吞吐量探测的时间
+	nextUDP   time.Time // 下一次 UDP 能力检测的时间
+	failures  int       // 连续失败次数
+	successes int       // 连续成功次数
+}
+
+// Checker 使用有限的工作协程对代理进行增量检测
+// 每个代理根据健康状况拥有各自的下次检测时间，失败的代理指数退避，新代理更快复检
+type Checker struct {
+	concurrency   int
+	timeout       time.Duration
+	freshInterval time.Duration
+	interval      time.Duration
+	maxBackoff    time.Duration
+	probe         *throughputProbe // 未启用吞吐量探测时为 nil
+	udp           *udpCheck        // 未启用 UDP 能力检测时为 nil
+
+	mu      sync.Mutex
+	states  map[string]*proxyHealth
+	running atomic.Bool // 保证同一时间只有一轮检测在执行
+}
+
+var (
+	probeSlots     chan struct{} // 全局的检测并发槽位，定时检测与采集检测共享
+	probeSlotsOnce sync.Once
+)
+
+// acquireProbeSlot 获取一个检测槽位，返回释放函数
+func acquireProbeSlot() func() {
+	probeSlotsOnce.Do(func() {
+		probeSlots = make(chan struct{}, positiveOr(common.Current().Checker.Concurrency, defaultCheckConcurrency))
+	})
+	probeSlots <- struct{}{}
+	return func() { <-probeSlots }
+}
+
+// NewChecker 根据全局配置创建检测器，未配置的项使用默认值
+func NewChecker() *Checker {
+	cfg := common.Current().Checker
+
+	return &Checker{
+		concurrency:   positiveOr(cfg.Concurrency, defaultCheckConcurrency),
+		timeout:       secondsOr(cfg.Timeout, defaultCheckTimeout),
+		freshInterval: secondsOr(cfg.FreshInterval, defaultFreshInterval),
+		interval:      secondsOr(cfg.Interval, defaultCheckInterval),
+		maxBackoff:    secondsOr(cfg.MaxBackoff, defaultMaxBackoff),
+		probe:         newThroughputProbe(),
+		udp:           newUDPCheck(),
+		states:        make(map[string]*proxyHealth),
+	}
+}
+
+// NewQuarantineChecker 创建检测隔离区代理的检测器，所有代理使用相同的复检间隔，不探测吞吐量与 UDP 能力
+func NewQuarantineChecker(interval time.Duration) *Checker {
+	cfg := common.Current().Checker
+
+	return &Checker{
+		concurrency:   positiveOr(cfg.Concurrency, defaultCheckConcurrency),
+		timeout:       secondsOr(cfg.Timeout, defaultCheckTimeout),
+		freshInterval: interval,
+		interval:      interval,
+		maxBackoff:    interval,
+		states:        make(map[string]*proxyHealth),
+	}
+}
+
+// CheckDue 检测已到期的代理并返回结果
+// 如果上一轮检测尚未结束则直接返回 false，不会产生重叠的检测
+// ctx 取消后不再开始新的检测，只返回并调度已经完成的结果，未检测的代理仍然到期
+func (c *Checker) CheckDue(ctx context.Context, proxies []string, targetURLs []string) ([]ProxyCheckResult, bool) {
+	return c.check(ctx, proxies, targetURLs, false)
+}
+
+// CheckAll 与 CheckDue 相同，但不论是否到期都检测全部代理，用于手动触发的检测
+// 与定时检测共享同一个检测器时不会重叠，吞吐量与 UDP 能力仍然按各自的间隔检测
+func (c *Checker) CheckAll(ctx context.Context, proxies []string, targetURLs []string) ([]ProxyCheckResult, bool) {
+	return c.check(ctx, proxies, targetURLs, true)
+}
+
+// Running 返回是否有一轮检测正在进行
+func (c *Checker) Running() bool {
+	return c.running.Load()
+}
+
+// check 检测到期的代理，all 为 true 时检测全部代理
+func (c *Checker) check(ctx context.Context, proxies []string, targetURLs []string, all bool) ([]ProxyCheckResult, bool) {
+	if !c.running.CompareAndSwap(false, true) {
+		return nil, false
+	}
+	defer c.running.Store(false)
+
+	due, probeDue, udpDue := c.dueProxies(proxies, time.Now(), all)
+	if len(due) == 0 {
+		return nil, true
+	}
+
+	checkerLog.Info("开始健康检测", "due", len(due), "total", len(proxies))
+	results := runConcurrently(ctx, due, c.concurrency, func(proxyAddr string) ProxyCheckResult {
+		result := checkProxy(ctx, proxyAddr, targetURLs, c.timeout)
+		if result.Success && probeDue[proxyAddr] && ctx.Err() == nil {
+			c.probeThroughput(&result)
+		}
+		if result.Success && udpDue[proxyAddr] && ctx.Err() == nil {
+			c.checkUDP(&result)
+		}
+		return result
+	})
+	if ctx.Err() != nil {
+		checkerLog.Info("健康检测被中断", "checked", len(results), "due", len(due))
+	}
+
+	now := time.Now()
+	c.mu.Lock()
+	for _, result := range results {
+		c.schedule(result, now)
+	}
+	c.mu.Unlock()
+
+	return results, true
+}
+
+// dueProxies 返回到期需要检测的代理，以及其中需要探测吞吐量和 UDP 能力的代理，并清理已不在列表中的代理状态
+// all 为 true 时全部代理都视为到期
+func (c *Checker) dueProxies(proxies []string, now time.Time, all bool) ([]string, map[string]bool, map[string]bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	present := make(map[string]struct{}, len(proxies))
+	var due []string
+	probeDue := make(map[string]bool)
+	udpDue := make(map[string]bool)
+	for _, proxyAddr := range proxies {
+		present[proxyAddr] = struct{}{}
+		state, ok := c.states[proxyAddr]
+		if all || !ok || !now.Before(state.nextCheck) {
+			due = append(due, proxyAddr)
+			if c.probe != nil && (!ok || !now.Before(state.nextProbe)) {
+				probeDue[proxyAddr] = true
+			}
+			if c.udp != nil && strings.HasPrefix(proxyAddr, "socks5://") && (!ok || !now.Before(state.nextUDP)) {
+				udpDue[proxyAddr] = true
+			}
+		}
+	}
+
+	for proxyAddr := range c.states {
+		if _, ok := present[proxyAddr]; !ok {
+			delete(c.states, proxyAddr)
+		}
+	}
+
+	return due, probeDue, udpDue
+}
+
+// probeThroughput 对检测成功的代理进行吞吐量探测，并将结果写入检测结果
+func (c *Checker) probeThroughput(result *ProxyCheckResult) {
+	throughput, err := ProbeThroughput(result.ProxyAddr, c.probe.url, c.probe.size, c.probe.timeout)
+	result.Probed = true
+	if err != nil && throughput == 0 {
+		// 没有收到任何数据时无法判断带宽，可能只是测速地址不可用，记为未知而不是 0，避免代理被最低带宽永久排除
+		checkerLog.Debug("吞吐量探测失败", "proxy", result.ProxyAddr, "error", err)
+		return
+	}
+
+	// 超时或连接中断前已经收到部分数据时，按已收到部分的速度记录
+	result.Throughput = &throughput
+}
+
+// checkUDP 对检测成功的 SOCKS5 代理检测 UDP 能力，并将结果写入检测结果
+func (c *Checker) checkUDP(result *ProxyCheckResult) {
+	err := CheckUDP(result.ProxyAddr, c.udp.echoAddr, c.udp.timeout)
+	if err != nil {
+		checkerLog.Debug("代理不支持 UDP", "proxy", result.ProxyAddr, "error", err)
+	}
+
+	result.UDPChecked = true
+	result.UDP = err == nil
+}
+
+// schedule 根据检测结果计算代理的下一次检测时间，调用方需持有锁
+func (c *Checker) schedule(result ProxyCheckResult, now time.Time) {
+	state, ok := c.states[result.ProxyAddr]
+	if !ok {
+		state = &proxyHealth{}
+		c.states[result.ProxyAddr] = state
+	}
+
+	if result.Probed {
+		state.nextProbe = now.Add(c.probe.interval)
+	}
+	if result.UDPChecked {
+		state.nextUDP = now.Add(c.udp.interval)
+	}
+
+	var wait time.Duration
+	if result.Success {
+		state.successes++
+		state.failures = 0
+		if state.successes < freshSuccessCount {
+			wait = c.freshInterval
+		} else {
+			wait = c.interval
+		}
+	} else {
+		state.failures++
+		state.successes = 0
+		wait = c.interval
+		for i := 1; i < state.failures && wait < c.maxBackoff; i++ {
+			wait *= 2
+		}
+		if wait > c.maxBackoff {
+			wait = c.maxBackoff
+		}
+	}
+
+	state.nextCheck = now.Add(wait)
+}
+
+// checkConcurrently 使用固定数量的工作协程检测代理，所有调用共享全局并发上限
+func checkConcurrently(ctx context.Context, proxies []string, targetURLs []string, concurrency int, timeout time.Duration) []ProxyCheckResult {
+	return runConcurrently(ctx, proxies, concurrency, func(proxyAddr string) ProxyCheckResult {
+		return checkProxy(ctx, proxyAddr, targetURLs, timeout)
+	})
+}
+
+// runConcurrently 使用固定数量的工作协程对每个代理执行 check
+// ctx 取消后不再分发新的代理，取消之后失败的结果可能只是被中止，不计入返回值
+func runConcurrently(ctx context.Context, proxies []string, concurrency int, check func(string) ProxyCheckResult) []ProxyCheckResult {
+	if concurrency > len(proxies) {
+		concurrency = len(proxies)
+	}
+
+	jobs := make(chan string)
+	results := make(chan ProxyCheckResult, len(proxies))
+
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for proxyAddr := range jobs {
+				release := acquireProbeSlot()
+				if result := check(proxyAddr); result.Success || ctx.Err() == nil {
+					results <- result
+				}
+				release()
+			}
+		}()
+	}
+
+dispatch:
+	for _, proxyAddr := range proxies {
+		select {
+		case jobs <- proxyAddr:
+		case <-ctx.Done():
+			break dispatch
+		}
+	}
+	close(jobs)
+
+	wg.Wait()
+	close(results)
+
+	finalResults := make([]ProxyCheckResult, 0, len(proxies))
+	for result := range results {
+		finalResults = append(finalResults, result)
+	}
+
+	return finalResults
+}
+
+// positiveOr 当 v 不大于 0 时返回默认值
+func positiveOr(v, def int) int {
+	if v <= 0 {
+		return def
+	}
+	return v
+}
+
+// secondsOr 将以秒为单位的配置转换为时间间隔，未配置时返回默认值
+func secondsOr(seconds int, def time.Duration) time.Duration {
+	if seconds <= 0 {
+		return def
+	}
+	return time.Duration(seconds) * time.Second
+}
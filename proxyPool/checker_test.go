@@ -0,0 +1,62 @@
+package proxyPool
+
+import (
+	"context"
+	"net"
+	"testing"
+)
+
+// closedProxy 返回一个已经关闭的本地端口，连接会被立即拒绝
+func closedProxy(t *testing.T) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("监听失败: %v", err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+	return "http://" + addr
+}
+
+func TestCheckDueCanceled(t *testing.T) {
+	checker := NewChecker()
+	proxies := []string{closedProxy(t), closedProxy(t)}
+	targets := []string{"http://example.com"}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	results, ok := checker.CheckDue(ctx, proxies, targets)
+	if !ok {
+		t.Fatal("检测被错误地视为重叠")
+	}
+	if len(results) != 0 {
+		t.Fatalf("取消后返回了 %d 个结果，被中止的检测不应计为失败", len(results))
+	}
+
+	// 被中止的代理没有调度下一次检测，仍然到期
+	results, _ = checker.CheckDue(context.Background(), proxies, targets)
+	if len(results) != len(proxies) {
+		t.Fatalf("检测了 %d 个代理，期望 %d 个", len(results), len(proxies))
+	}
+	for _, result := range results {
+		if result.Success {
+			t.Errorf("%s 不应检测成功", result.ProxyAddr)
+		}
+	}
+}
+
+func TestCheckAllIgnoresSchedule(t *testing.T) {
+	checker := NewChecker()
+	proxies := []string{closedProxy(t), closedProxy(t)}
+	targets := []string{"http://example.com"}
+
+	if results, _ := checker.CheckDue(context.Background(), proxies, targets); len(results) != len(proxies) {
+		t.Fatalf("首次检测了 %d 个代理，期望 %d 个", len(results), len(proxies))
+	}
+	if results, _ := checker.CheckDue(context.Background(), proxies, targets); len(results) != 0 {
+		t.Fatalf("未到期的代理被检测了 %d 个", len(results))
+	}
+	if results, _ := checker.CheckAll(context.Background(), proxies, targets); len(results) != len(proxies) {
+		t.Fatalf("CheckAll 检测了 %d 个代理，期望 %d 个", len(results), len(proxies))
+	}
+}
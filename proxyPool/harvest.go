@@ -0,0 +1,122 @@
+package proxyPool
+
+import (
+	"regexp"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// HarvestResult 一个数据源最近一次获取代理的结果，以及进程启动以来累计的运行次数与额度消耗
+type HarvestResult struct {
+	Source             string    `json:"source"`
+	Runs               int       `json:"runs"`
+	Failures           int       `json:"failures"` // 因错误中断的次数
+	StartedAt          time.Time `json:"started_at"`
+	FinishedAt         time.Time `json:"finished_at"`
+	Addresses          int       `json:"addresses"`  // 数据源返回的代理池地址数量
+	Candidates         int       `json:"candidates"` // 从代理池中获取到的代理数量
+	Stored             int       `json:"stored"`     // 检测可用并保存的代理数量
+	Error              string    `json:"error,omitempty"`
+	ConsumedQuota      int       `json:"consumed_quota"`            // 最近一次消耗的额度，hunter 为积分，fofa 为 F 点
+	TotalConsumedQuota int       `json:"total_consumed_quota"`      // 累计消耗的额度
+	RemainingQuota     string    `json:"remaining_quota,omitempty"` // 数据源返回的剩余额度，目前只有 hunter 返回
+}
+
+var (
+	harvestMu      sync.Mutex
+	harvestResults = make(map[string]*HarvestResult)
+)
+
+// quotaNumber 匹配 hunter 额度说明中的数字，例如 "消耗积分：10"
+var quotaNumber = regexp.MustCompile(`\d+`)
+
+// harvestRun 记录一次获取代理的过程，各协程并发累加
+type harvestRun struct {
+	source     string
+	startedAt  time.Time
+	addresses  atomic.Int64
+	candidates atomic.Int64
+	stored     atomic.Int64
+	consumed   atomic.Int64
+
+	mu        sync.Mutex
+	err       error
+	remaining string
+}
+
+func newHarvestRun(source string) *harvestRun {
+	return &harvestRun{source: source, startedAt: time.Now()}
+}
+
+// fail 记录第一个导致获取中断的错误
+func (run *harvestRun) fail(err error) {
+	run.mu.Lock()
+	defer run.mu.Unlock()
+	if run.err == nil {
+		run.err = err
+	}
+}
+
+// addQuota 累加本次消耗的额度，remaining 非空时更新剩余额度
+func (run *harvestRun) addQuota(consumed int, remaining string) {
+	run.consumed.Add(int64(consumed))
+	if remaining != "" {
+		run.mu.Lock()
+		run.remaining = remaining
+		run.mu.Unlock()
+	}
+}
+
+// finish 将本次结果保存为数据源最近一次的结果
+func (run *harvestRun) finish() {
+	run.mu.Lock()
+	defer run.mu.Unlock()
+
+	harvestMu.Lock()
+	defer harvestMu.Unlock()
+
+	result, ok := harvestResults[run.source]
+	if !ok {
+		result = &HarvestResult{Source: run.source}
+		harvestResults[run.source] = result
+	}
+
+	result.Runs++
+	result.StartedAt = run.startedAt
+	result.FinishedAt = time.Now()
+	result.Addresses = int(run.addresses.Load())
+	result.Candidates = int(run.candidates.Load())
+	result.Stored = int(run.stored.Load())
+	result.ConsumedQuota = int(run.consumed.Load())
+	result.TotalConsumedQuota += result.ConsumedQuota
+	result.Error = ""
+	if run.err != nil {
+		result.Failures++
+		result.Error = run.err.Error()
+	}
+	if run.remaining != "" {
+		result.RemainingQuota = run.remaining
+	}
+}
+
+// HarvestResults 返回各数据源最近一次获取代理的结果，按数据源名称排序
+func HarvestResults() []HarvestResult {
+	harvestMu.Lock()
+	defer harvestMu.Unlock()
+
+	results := make([]HarvestResult, 0, len(harvestResults))
+	for _, result := range harvestResults {
+		results = append(results, *result)
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].Source < results[j].Source })
+	return results
+}
+
+// parseQuota 从 hunter 的额度说明中提取数字，无法解析时返回 0
+func parseQuota(text string) int {
+	n, _ := strconv.Atoi(quotaNumber.FindString(text))
+	return n
+}
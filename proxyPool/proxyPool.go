@@ -1,6 +1,7 @@
 package proxyPool
 
 import (
+	"context"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
@@ -12,6 +13,7 @@ import (
 	"proxychain/database"
 	"strings"
 	"sync"
+	"sync/atomic"
 )
 
 var (
@@ -19,31 +21,34 @@ var (
 	hunterURL    = "https://hunter.qianxin.com/openApi"
 	hunterAPIKey = ""
 	fofaAPIKey   = ""
+
+	// harvesterLog 从数据源获取代理的日志
+	harvesterLog = common.Logger(common.LogHarvester)
 )
 
-// GetProxyBase 初始化API密钥并开始获取代理池
-func GetProxyBase(ps *database.ProxyStorage) {
-	hunterAPIKey = common.GlobalConfig.Hunter.APIKey
-	fofaAPIKey = common.GlobalConfig.Fofa.APIKey
+// GetProxyBase 初始化API密钥并开始获取代理池，ctx 取消后不再发起新的请求与检测，尽快返回
+func GetProxyBase(ctx context.Context, ps database.Storage) {
+	hunterAPIKey = common.Current().Hunter.APIKey
+	fofaAPIKey = common.Current().Fofa.APIKey
 
 	// 使用 WaitGroup 来并发获取代理
 	var wg sync.WaitGroup
 
 	if hunterAPIKey != "" {
-		log.Println("开始获取Hunter代理池")
+		harvesterLog.Info("开始获取代理池", "source", "hunter")
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
-			getProxiesFromSource(ps, "hunter")
+			getProxiesFromSource(ctx, ps, "hunter")
 		}()
 	}
 
 	if fofaAPIKey != "" {
-		log.Println("开始获取Fofa代理池")
+		harvesterLog.Info("开始获取代理池", "source", "fofa")
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
-			getProxiesFromSource(ps, "fofa")
+			getProxiesFromSource(ctx, ps, "fofa")
 		}()
 	}
 
@@ -52,10 +57,14 @@ func GetProxyBase(ps *database.ProxyStorage) {
 }
 
 // getProxiesFromSource 获取代理池数据并保存到数据库
-func getProxiesFromSource(ps *database.ProxyStorage, source string) {
+func getProxiesFromSource(ctx context.Context, ps database.Storage, source string) {
 	var searchStatements []string
 	var buildQueryURL func(string, int, int) string
-	var processProxies func(string, *database.ProxyStorage)
+	var processProxies func(context.Context, string, database.Storage, *harvestRun)
+
+	// 记录本次获取的结果与消耗的额度，供管理控制台展示
+	run := newHarvestRun(source)
+	defer run.finish()
 
 	switch source {
 	case "hunter":
@@ -75,14 +84,16 @@ func getProxiesFromSource(ps *database.ProxyStorage, source string) {
 
 	// 第一次少量请求，获取total num
 	url := buildQueryURL(searchStatements[0], 1, 1)
-	totalNum, err := getTotalNumber(url, source)
+	totalNum, err := getTotalNumber(ctx, url, source, run)
 	if err != nil {
-		log.Fatalf("获取 %s total num 失败：%v", source, err)
+		harvesterLog.Error("获取 total num 失败", "source", source, "error", err)
+		run.fail(err)
 		return
 	}
 
 	if totalNum <= 0 {
-		log.Printf("%s 返回的 total num 小于等于 0", source)
+		harvesterLog.Warn("返回的 total num 小于等于 0", "source", source)
+		run.fail(fmt.Errorf("%s 返回的 total num 小于等于 0", source))
 		return
 	}
 
@@ -101,7 +112,7 @@ func getProxiesFromSource(ps *database.ProxyStorage, source string) {
 		wg.Add(1)
 		go func(url string) {
 			defer wg.Done()
-			processProxies(url, ps)
+			processProxies(ctx, url, ps, run)
 		}(queryURL)
 	}
 
@@ -110,19 +121,21 @@ func getProxiesFromSource(ps *database.ProxyStorage, source string) {
 }
 
 // getTotalNumber 获取 Hunter 或 Fofa 数据的总数
-func getTotalNumber(requestURL, source string) (int, error) {
+func getTotalNumber(ctx context.Context, requestURL, source string, run *harvestRun) (int, error) {
 	switch source {
 	case "hunter":
-		hunterResponse, err := fetchHunterResponse(requestURL)
+		hunterResponse, err := fetchHunterResponse(ctx, requestURL)
 		if err != nil {
 			return 0, err
 		}
+		run.addQuota(parseQuota(hunterResponse.Data.ConsumeQuota), hunterResponse.Data.RestQuota)
 		return hunterResponse.Data.Total, nil
 	case "fofa":
-		fofaResponse, err := fetchFofaResponse(requestURL)
+		fofaResponse, err := fetchFofaResponse(ctx, requestURL)
 		if err != nil {
 			return 0, err
 		}
+		run.addQuota(fofaResponse.ConsumedFPoint, "")
 		return fofaResponse.Size, nil
 	default:
 		return 0, fmt.Errorf("未知的数据源: %s", source)
@@ -144,18 +157,21 @@ func buildFofaQueryURL(searchStatement string, page, pageSize int) string {
 }
 
 // processHunterProxies 获取代理列表，检查可用性，并保存到数据库
-func processHunterProxies(requestURL string, ps *database.ProxyStorage) {
-	proxyBases, err := fetchHunterData(requestURL)
+func processHunterProxies(ctx context.Context, requestURL string, ps database.Storage, run *harvestRun) {
+	proxyBases, err := fetchHunterData(ctx, requestURL, run)
 	if err != nil {
-		log.Fatalf("获取 Hunter 代理数据失败: %v", err)
+		harvesterLog.Error("获取代理数据失败", "source", "hunter", "error", err)
+		run.fail(err)
+		return
 	}
+	run.addresses.Add(int64(len(proxyBases)))
 
 	var wg sync.WaitGroup
 	for _, proxyBase := range proxyBases {
 		wg.Add(1)
 		go func(pb common.ProxyBase) {
 			defer wg.Done()
-			storeProxiesByBase(pb, ps)
+			storeProxiesByBase(ctx, pb, ps, run)
 		}(proxyBase)
 	}
 
@@ -164,18 +180,21 @@ func processHunterProxies(requestURL string, ps *database.ProxyStorage) {
 }
 
 // processFofaProxies 获取代理列表，检查可用性，并保存到数据库
-func processFofaProxies(requestURL string, ps *database.ProxyStorage) {
-	fofaData, err := fetchFofaData(requestURL)
+func processFofaProxies(ctx context.Context, requestURL string, ps database.Storage, run *harvestRun) {
+	fofaData, err := fetchFofaData(ctx, requestURL, run)
 	if err != nil {
-		log.Fatalf("获取 Fofa 代理数据失败: %v", err)
+		harvesterLog.Error("获取代理数据失败", "source", "fofa", "error", err)
+		run.fail(err)
+		return
 	}
+	run.addresses.Add(int64(len(fofaData)))
 
 	var wg sync.WaitGroup
 	for _, data := range fofaData {
 		wg.Add(1)
 		go func(addr string) {
 			defer wg.Done()
-			storeProxiesByFofa(addr, ps)
+			storeProxiesByFofa(ctx, addr, ps, run)
 		}(data.FullAddress)
 	}
 
@@ -184,33 +203,36 @@ func processFofaProxies(requestURL string, ps *database.ProxyStorage) {
 }
 
 // storeProxiesByBase 根据 Hunter 返回的数据存储代理
-func storeProxiesByBase(proxyBase common.ProxyBase, ps *database.ProxyStorage) {
-	proxyList, err := GetProxyList(proxyBase.URL + "/all")
+func storeProxiesByBase(ctx context.Context, proxyBase common.ProxyBase, ps database.Storage, run *harvestRun) {
+	proxyList, err := GetProxyList(ctx, proxyBase.URL+"/all")
 	if err != nil {
-		log.Printf("获取代理列表失败: %v", err)
+		harvesterLog.Warn("获取代理列表失败", "pool", proxyBase.URL, "error", err)
 		return
 	}
-	checkAndStoreProxies(proxyList, proxyBase, ps)
+	run.candidates.Add(int64(len(proxyList)))
+	run.stored.Add(int64(checkAndStoreProxies(ctx, proxyList, proxyBase, ps)))
 }
 
 // storeProxiesByFofa 根据 Fofa 返回的数据存储代理
-func storeProxiesByFofa(proxyAddr string, ps *database.ProxyStorage) {
+func storeProxiesByFofa(ctx context.Context, proxyAddr string, ps database.Storage, run *harvestRun) {
 	if !strings.Contains(proxyAddr, "http") {
 		proxyAddr = "http://" + proxyAddr
 	}
-	proxyList, err := GetProxyList(proxyAddr + "/all")
+	proxyList, err := GetProxyList(ctx, proxyAddr+"/all")
 	if err != nil {
-		log.Printf("获取代理列表失败: %v", err)
+		harvesterLog.Warn("获取代理列表失败", "pool", proxyAddr, "error", err)
 		return
 	}
-	checkAndStoreProxies(proxyList, common.ProxyBase{}, ps)
+	run.candidates.Add(int64(len(proxyList)))
+	run.stored.Add(int64(checkAndStoreProxies(ctx, proxyList, common.ProxyBase{}, ps)))
 }
 
-// checkAndStoreProxies 并发检测代理可用性并保存可用代理
-func checkAndStoreProxies(proxyList []string, proxyBase common.ProxyBase, ps *database.ProxyStorage) {
+// checkAndStoreProxies 并发检测代理可用性并保存可用代理，返回保存的代理数量
+func checkAndStoreProxies(ctx context.Context, proxyList []string, proxyBase common.ProxyBase, ps database.Storage) int {
 	targetURLs := []string{"https://www.google.com", "https://www.baidu.com", "http://www.baidu.com", "https://www.yulate.com", "https://www.ip138.com"}
-	results := CheckProxy(proxyList, targetURLs)
+	results := CheckProxy(ctx, proxyList, targetURLs)
 
+	var stored atomic.Int64
 	var wg sync.WaitGroup
 	for _, result := range results {
 		wg.Add(1)
@@ -219,102 +241,69 @@ func checkAndStoreProxies(proxyList []string, proxyBase common.ProxyBase, ps *da
 			if res.Success {
 				ip, port, err := common.ExtractIPAndPort(res.ProxyAddr)
 				if err != nil {
-					log.Printf("解析代理地址失败: %v\n", err)
-					return
-				}
-
-				// 检查代理是否已存在
-				exists, err := ps.ProxyExists(ip, port)
-				if err != nil {
-					log.Printf("检查代理存在性失败: %v\n", err)
+					harvesterLog.Error("解析代理地址失败", "proxy", res.ProxyAddr, "error", err)
 					return
 				}
 
 				// 检查代理位置信息是否为空，如果为空使用纯真ip数据库进行补全
-				if proxyBase.Country == "" || proxyBase.Province == "" || proxyBase.City == "" {
+				// 每个协程使用自己的副本，避免并发修改共享的 proxyBase
+				location := proxyBase
+				if location.Country == "" || location.Province == "" || location.City == "" {
 					locator, err := iploc.Open("data/czutf8.dat")
 					if err != nil {
 						log.Fatal("出现异常，czutf8.dat 不存在")
 						panic(err)
 					}
 					detail := locator.Find(ip)
-					proxyBase.Country = detail.Country
-					proxyBase.Province = detail.Province
-					proxyBase.City = detail.City
+					location.Country = detail.Country
+					location.Province = detail.Province
+					location.City = detail.City
 				}
 
-				if exists {
-					// 更新代理信息
-					err = ps.UpdateProxy(ip, port, proxyBase.Country, proxyBase.Province, proxyBase.City)
-					if err != nil {
-						log.Printf("更新代理 %s 失败: %v\n", res.ProxyAddr, err)
-					} else {
-						log.Printf("更新代理: %s\n", res.ProxyAddr)
-					}
+				// 插入新代理，已存在时更新位置信息
+				err = ps.UpsertProxy(ip, port, "http", location.Country, location.Province, location.City)
+				if err != nil {
+					harvesterLog.Error("存储代理失败", "proxy", res.ProxyAddr, "error", err)
 				} else {
-					// 插入新代理
-					err = ps.InsertProxy(ip, port, "http", proxyBase.Country, proxyBase.Province, proxyBase.City)
-					if err != nil {
-						log.Printf("存储代理 %s 失败: %v\n", res.ProxyAddr, err)
-					} else {
-						log.Printf("存储可用代理: %s\n", res.ProxyAddr)
-					}
+					stored.Add(1)
+					harvesterLog.Info("存储可用代理", "proxy", res.ProxyAddr)
 				}
 			} else {
-				log.Printf("代理 %s 不可用: %v\n", res.ProxyAddr, res.Error)
+				harvesterLog.Debug("代理不可用", "proxy", res.ProxyAddr, "error", res.Error)
 			}
 		}(common.ProxyCheckResult(result))
 	}
 
 	// 等待所有并发操作完成
 	wg.Wait()
+	return int(stored.Load())
 }
 
-// fetchHunterData 发起 HTTP 请求并解析 Hunter API 返回的数据
-func fetchHunterData(requestURL string) ([]common.ProxyBase, error) {
-	resp, err := http.Get(requestURL)
+// fetchHunterData 发起 HTTP 请求并解析 Hunter API 返回的数据，记录本次消耗的积分
+func fetchHunterData(ctx context.Context, requestURL string, run *harvestRun) ([]common.ProxyBase, error) {
+	hunterResponse, err := fetchHunterResponse(ctx, requestURL)
 	if err != nil {
-		return nil, fmt.Errorf("请求失败: %w", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("请求失败，状态码: %d", resp.StatusCode)
-	}
-
-	var hunterResponse common.HunterResponse
-	err = json.NewDecoder(resp.Body).Decode(&hunterResponse)
-	if err != nil {
-		return nil, fmt.Errorf("解析 JSON 数据失败: %w", err)
+		return nil, err
 	}
 
+	run.addQuota(parseQuota(hunterResponse.Data.ConsumeQuota), hunterResponse.Data.RestQuota)
 	return hunterResponse.Data.Arr, nil
 }
 
-// fetchFofaData 发起 HTTP 请求并解析 Fofa API 返回的数据
-func fetchFofaData(requestURL string) ([]common.FofaProxy, error) {
-	resp, err := http.Get(requestURL)
-	if err != nil {
-		return nil, fmt.Errorf("请求失败: %w", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("请求失败，状态码: %d", resp.StatusCode)
-	}
-
-	var fofaResponse common.FofaResponse
-	err = json.NewDecoder(resp.Body).Decode(&fofaResponse)
+// fetchFofaData 发起 HTTP 请求并解析 Fofa API 返回的数据，记录本次消耗的 F 点
+func fetchFofaData(ctx context.Context, requestURL string, run *harvestRun) ([]common.FofaProxy, error) {
+	fofaResponse, err := fetchFofaResponse(ctx, requestURL)
 	if err != nil {
-		return nil, fmt.Errorf("解析 JSON 数据失败: %w", err)
+		return nil, err
 	}
 
-	return common.ExtractProxiesFromFofa(fofaResponse), nil
+	run.addQuota(fofaResponse.ConsumedFPoint, "")
+	return common.ExtractProxiesFromFofa(*fofaResponse), nil
 }
 
 // fetchHunterResponse 发起 HTTP 请求并解析 Hunter API 返回的数据
-func fetchHunterResponse(requestURL string) (*common.HunterResponse, error) {
-	resp, err := http.Get(requestURL)
+func fetchHunterResponse(ctx context.Context, requestURL string) (*common.HunterResponse, error) {
+	resp, err := httpGet(ctx, requestURL)
 	if err != nil {
 		return nil, fmt.Errorf("请求失败: %w", err)
 	}
@@ -334,8 +323,8 @@ func fetchHunterResponse(requestURL string) (*common.HunterResponse, error) {
 }
 
 // fetchFofaResponse 发起 HTTP 请求并解析 Fofa API 返回的数据
-func fetchFofaResponse(requestURL string) (*common.FofaResponse, error) {
-	resp, err := http.Get(requestURL)
+func fetchFofaResponse(ctx context.Context, requestURL string) (*common.FofaResponse, error) {
+	resp, err := httpGet(ctx, requestURL)
 	if err != nil {
 		return nil, fmt.Errorf("请求失败: %w", err)
 	}
@@ -355,8 +344,8 @@ func fetchFofaResponse(requestURL string) (*common.FofaResponse, error) {
 }
 
 // GetProxyList 从指定的 API 获取代理列表并返回格式化的代理 URL 列表
-func GetProxyList(apiURL string) ([]string, error) {
-	resp, err := http.Get(apiURL)
+func GetProxyList(ctx context.Context, apiURL string) ([]string, error) {
+	resp, err := httpGet(ctx, apiURL)
 	if err != nil {
 		return nil, fmt.Errorf("请求失败: %v", err)
 	}
@@ -379,3 +368,12 @@ func GetProxyList(apiURL string) ([]string, error) {
 
 	return proxyList, nil
 }
+
+// httpGet 发起 GET 请求，ctx 取消时请求随之中止
+func httpGet(ctx context.Context, requestURL string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, requestURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	return http.DefaultClient.Do(req)
+}
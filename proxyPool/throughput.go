@@ -0,0 +1,94 @@
+package proxyPool
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"proxychain/common"
+	"time"
+)
+
+// 吞吐量探测的默认参数
+const (
+	defaultProbeSize     = 1 << 20 // 默认下载 1MB
+	defaultProbeTimeout  = 30 * time.Second
+	defaultProbeInterval = 1 * time.Hour
+)
+
+// throughputProbe 通过代理下载指定大小的内容来测量带宽
+type throughputProbe struct {
+	url      string
+	size     int64
+	timeout  time.Duration
+	interval time.Duration
+}
+
+// newThroughputProbe 根据全局配置创建吞吐量探测，未启用时返回 nil
+func newThroughputProbe() *throughputProbe {
+	cfg := common.Current().Throughput
+	if !cfg.Enabled || cfg.URL == "" {
+		return nil
+	}
+
+	return &throughputProbe{
+		url:      cfg.URL,
+		size:     int64(positiveOr(cfg.Size, defaultProbeSize)),
+		timeout:  secondsOr(cfg.Timeout, defaultProbeTimeout),
+		interval: secondsOr(cfg.Interval, defaultProbeInterval),
+	}
+}
+
+// ProbeThroughput 通过代理下载最多 size 字节的内容，返回测得的带宽，单位字节每秒
+// 超时或连接中断时，只要已经收到数据就按已收到的部分计算带宽，同时返回错误
+func ProbeThroughput(proxyAddr string, probeURL string, size int64, timeout time.Duration) (float64, error) {
+	parsedURL, err := url.Parse(proxyAddr)
+	if err != nil {
+		return 0, fmt.Errorf("解析代理URL失败: %w", err)
+	}
+
+	dialer, err := createDialer(parsedURL, timeout)
+	if err != nil {
+		return 0, err
+	}
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			Dial:               dialer.Dial,
+			DisableKeepAlives:  true,
+			DisableCompression: true,
+		},
+		Timeout: timeout,
+	}
+
+	resp, err := client.Get(probeURL)
+	if err != nil {
+		return 0, fmt.Errorf("请求 %s 失败: %w", probeURL, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("测速地址 %s 响应异常: 状态码 %d", probeURL, resp.StatusCode)
+	}
+
+	// 从收到响应头开始计时，排除建立连接的耗时
+	start := time.Now()
+	n, err := io.CopyN(io.Discard, resp.Body, size)
+	elapsed := time.Since(start)
+	if errors.Is(err, io.EOF) {
+		err = nil
+	}
+
+	if n == 0 {
+		if err == nil {
+			err = errors.New("测速地址没有返回任何数据")
+		}
+		return 0, err
+	}
+
+	if elapsed <= 0 {
+		elapsed = time.Millisecond
+	}
+	return float64(n) / elapsed.Seconds(), err
+}
@@ -0,0 +1,139 @@
+package proxyPool
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newConnectProxy 启动只支持 CONNECT 的 HTTP 代理，返回 http://host:port 形式的代理地址
+func newConnectProxy(t *testing.T) string {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodConnect {
+			http.Error(w, "只支持 CONNECT", http.StatusMethodNotAllowed)
+			return
+		}
+		target, err := net.Dial("tcp", r.Host)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		client, _, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			target.Close()
+			return
+		}
+		client.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
+
+		// 任意一端关闭后关闭另一端，测速地址的处理函数随之结束
+		go func() {
+			io.Copy(target, client)
+			target.Close()
+		}()
+		io.Copy(client, target)
+		client.Close()
+	}))
+	t.Cleanup(server.Close)
+	return "http://" + server.Listener.Addr().String()
+}
+
+// newProbeTarget 启动测速地址：/full 返回 size 字节，/error 返回 500，
+// /stall 不返回数据直到连接断开，/partial 返回一半的数据后停止发送
+func newProbeTarget(t *testing.T, size int) string {
+	t.Helper()
+
+	data := strings.Repeat("x", size)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/full", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, data)
+	})
+	mux.HandleFunc("/error", func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "测速地址不可用", http.StatusInternalServerError)
+	})
+	mux.HandleFunc("/stall", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	})
+	mux.HandleFunc("/partial", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "999999")
+		io.WriteString(w, data[:size/2])
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	})
+
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server.URL
+}
+
+func TestProbeThroughput(t *testing.T) {
+	const size = 64 << 10
+	proxyAddr := newConnectProxy(t)
+	target := newProbeTarget(t, size)
+
+	tests := []struct {
+		name      string
+		proxy     string
+		path      string
+		wantErr   bool
+		wantSpeed bool
+	}{
+		{name: "下载完成", proxy: proxyAddr, path: "/full", wantSpeed: true},
+		{name: "测速地址返回错误", proxy: proxyAddr, path: "/error", wantErr: true},
+		{name: "没有收到数据就超时", proxy: proxyAddr, path: "/stall", wantErr: true},
+		{name: "收到部分数据后超时", proxy: proxyAddr, path: "/partial", wantErr: true, wantSpeed: true},
+		{name: "代理无法连接", proxy: closedProxy(t), path: "/full", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			throughput, err := ProbeThroughput(tt.proxy, target+tt.path, size, 500*time.Millisecond)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("错误为 %v，期望出错 %v", err, tt.wantErr)
+			}
+			if (throughput > 0) != tt.wantSpeed {
+				t.Errorf("带宽为 %.0f，期望测得带宽 %v", throughput, tt.wantSpeed)
+			}
+		})
+	}
+}
+
+// TestCheckerProbeFailureUnknown 没有收到数据的探测记为带宽未知，不能记为 0 而被最低带宽永久排除
+func TestCheckerProbeFailureUnknown(t *testing.T) {
+	const size = 64 << 10
+	proxyAddr := newConnectProxy(t)
+	target := newProbeTarget(t, size)
+
+	tests := []struct {
+		path      string
+		wantKnown bool
+	}{
+		{"/full", true},
+		{"/partial", true},
+		{"/error", false},
+		{"/stall", false},
+	}
+
+	for _, tt := range tests {
+		checker := &Checker{probe: &throughputProbe{url: target + tt.path, size: size, timeout: 500 * time.Millisecond}}
+		result := ProxyCheckResult{ProxyAddr: proxyAddr, Success: true}
+		checker.probeThroughput(&result)
+
+		if !result.Probed {
+			t.Errorf("%s: 没有标记为已探测，不会按探测间隔调度", tt.path)
+		}
+		if known := result.Throughput != nil; known != tt.wantKnown {
+			t.Errorf("%s: 带宽为 %v，期望已知 %v", tt.path, result.Throughput, tt.wantKnown)
+		}
+		if result.Throughput != nil && *result.Throughput <= 0 {
+			t.Errorf("%s: 已知的带宽为 %.0f", tt.path, *result.Throughput)
+		}
+	}
+}
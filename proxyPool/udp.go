@@ -0,0 +1,96 @@
+package proxyPool
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"proxychain/common"
+	"proxychain/socks5"
+	"time"
+)
+
+// UDP 能力检测的默认参数
+const (
+	defaultUDPTimeout  = 5 * time.Second
+	defaultUDPInterval = 1 * time.Hour
+)
+
+// udpProbePayload 发送给回显服务器的检测数据
+var udpProbePayload = []byte("proxychain-udp-probe")
+
+// udpCheck 检测 SOCKS5 代理是否支持 UDP ASSOCIATE
+type udpCheck struct {
+	echoAddr string
+	timeout  time.Duration
+	interval time.Duration
+}
+
+// newUDPCheck 根据全局配置创建 UDP 能力检测，未启用时返回 nil
+func newUDPCheck() *udpCheck {
+	cfg := common.Current().UDP
+	if !cfg.Enabled {
+		return nil
+	}
+
+	return &udpCheck{
+		echoAddr: cfg.EchoAddr,
+		timeout:  secondsOr(cfg.Timeout, defaultUDPTimeout),
+		interval: secondsOr(cfg.Interval, defaultUDPInterval),
+	}
+}
+
+// CheckUDP 检测 SOCKS5 代理的 UDP 能力
+// 配置了回显服务器时要求数据报能够经代理往返，否则只要求 UDP ASSOCIATE 协商成功
+func CheckUDP(proxyAddr string, echoAddr string, timeout time.Duration) error {
+	parsedURL, err := url.Parse(proxyAddr)
+	if err != nil {
+		return fmt.Errorf("解析代理URL失败: %w", err)
+	}
+	if parsedURL.Scheme != "socks5" {
+		return errors.New("只有 SOCKS5 代理支持 UDP: " + parsedURL.Scheme)
+	}
+
+	assoc, err := socks5.UDPAssociate(parsedURL.Host, timeout)
+	if err != nil {
+		return fmt.Errorf("UDP ASSOCIATE 失败: %w", err)
+	}
+	defer assoc.Close()
+
+	if echoAddr == "" {
+		return nil
+	}
+
+	conn, err := net.DialUDP("udp", nil, assoc.RelayAddr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	packet, err := socks5.PackUDP(echoAddr, udpProbePayload)
+	if err != nil {
+		return err
+	}
+
+	conn.SetDeadline(time.Now().Add(timeout))
+	if _, err := conn.Write(packet); err != nil {
+		return err
+	}
+
+	buf := make([]byte, 2048)
+	n, err := conn.Read(buf)
+	if err != nil {
+		return fmt.Errorf("未收到回显数据: %w", err)
+	}
+
+	_, _, payload, err := socks5.UnpackUDP(buf[:n])
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(payload, udpProbePayload) {
+		return errors.New("回显数据不一致")
+	}
+
+	return nil
+}
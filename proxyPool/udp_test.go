@@ -0,0 +1,48 @@
+package proxyPool
+
+import (
+	"net"
+	"proxychain/socks5/socks5test"
+	"testing"
+	"time"
+)
+
+func TestCheckUDP(t *testing.T) {
+	const timeout = 2 * time.Second
+	echo := socks5test.NewEchoServer(t)
+	supported := socks5test.NewServer(t, true)
+	unsupported := socks5test.NewServer(t, false)
+
+	// 没有监听的 UDP 端口，数据报得不到回显
+	silent, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	silentAddr := silent.LocalAddr().String()
+	silent.Close()
+
+	tests := []struct {
+		name     string
+		proxy    string
+		echoAddr string
+		wantErr  bool
+	}{
+		{"回显往返", supported.URL(), echo.String(), false},
+		{"只协商", supported.URL(), "", false},
+		{"不支持 UDP ASSOCIATE", unsupported.URL(), echo.String(), true},
+		{"没有回显", supported.URL(), silentAddr, true},
+		{"不是 SOCKS5 代理", "http://" + supported.Addr, echo.String(), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			check := timeout
+			if tt.wantErr {
+				check = 300 * time.Millisecond
+			}
+			err := CheckUDP(tt.proxy, tt.echoAddr, check)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("CheckUDP 返回 %v，期望出错: %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,90 @@
+package socks5
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+// UDPAssociation 表示与上游 SOCKS5 代理建立的 UDP 关联
+// 控制连接关闭后上游会释放对应的 UDP 中继
+type UDPAssociation struct {
+	Control   net.Conn
+	RelayAddr *net.UDPAddr
+}
+
+// Close 关闭控制连接，释放上游的 UDP 中继
+func (a *UDPAssociation) Close() error {
+	return a.Control.Close()
+}
+
+// UDPAssociate 与上游 SOCKS5 代理协商 UDP ASSOCIATE，握手阶段受 timeout 限制
+func UDPAssociate(proxyHost string, timeout time.Duration) (*UDPAssociation, error) {
+	conn, err := net.DialTimeout("tcp", proxyHost, timeout)
+	if err != nil {
+		return nil, err
+	}
+
+	relayAddr, err := associate(conn, timeout)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	// 部分代理返回 0.0.0.0 作为中继地址，表示与控制连接相同的地址
+	if relayAddr.IP.IsUnspecified() {
+		relayAddr.IP = conn.RemoteAddr().(*net.TCPAddr).IP
+	}
+
+	return &UDPAssociation{Control: conn, RelayAddr: relayAddr}, nil
+}
+
+// associate 在控制连接上完成认证协商与 UDP ASSOCIATE 请求，返回上游的中继地址
+func associate(conn net.Conn, timeout time.Duration) (*net.UDPAddr, error) {
+	conn.SetDeadline(time.Now().Add(timeout))
+	defer conn.SetDeadline(time.Time{})
+
+	if _, err := conn.Write([]byte{Version5, 1, MethodNoAuth}); err != nil {
+		return nil, err
+	}
+
+	var method [2]byte
+	if _, err := io.ReadFull(conn, method[:]); err != nil {
+		return nil, err
+	}
+	if method[0] != Version5 {
+		return nil, fmt.Errorf("不支持的 SOCKS 版本: %d", method[0])
+	}
+	if method[1] != MethodNoAuth {
+		return nil, errors.New("no acceptable authentication methods")
+	}
+
+	// 客户端的 UDP 地址事先未知，按协议填写 0.0.0.0:0
+	request, err := AppendAddr([]byte{Version5, CmdUDPAssociate, 0x00}, "0.0.0.0:0")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := conn.Write(request); err != nil {
+		return nil, err
+	}
+
+	var header [3]byte
+	if _, err := io.ReadFull(conn, header[:]); err != nil {
+		return nil, err
+	}
+	if header[0] != Version5 {
+		return nil, fmt.Errorf("不支持的 SOCKS 版本: %d", header[0])
+	}
+	if header[1] != ReplySucceeded {
+		return nil, &ReplyError{Code: header[1]}
+	}
+
+	bindAddr, err := ReadAddr(conn)
+	if err != nil {
+		return nil, err
+	}
+
+	return net.ResolveUDPAddr("udp", bindAddr)
+}
@@ -0,0 +1,79 @@
+package socks5_test
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"proxychain/socks5"
+	"proxychain/socks5/socks5test"
+	"testing"
+	"time"
+)
+
+const testTimeout = 2 * time.Second
+
+func TestUDPAssociateRoundTrip(t *testing.T) {
+	server := socks5test.NewServer(t, true)
+	echo := socks5test.NewEchoServer(t)
+
+	assoc, err := socks5.UDPAssociate(server.Addr, testTimeout)
+	if err != nil {
+		t.Fatalf("UDP ASSOCIATE 失败: %v", err)
+	}
+	defer assoc.Close()
+
+	conn, err := net.DialUDP("udp", nil, assoc.RelayAddr)
+	if err != nil {
+		t.Fatalf("连接中继失败: %v", err)
+	}
+	defer conn.Close()
+
+	payload := []byte("hello over udp")
+	packet, err := socks5.PackUDP(echo.String(), payload)
+	if err != nil {
+		t.Fatalf("封装数据报失败: %v", err)
+	}
+	conn.SetDeadline(time.Now().Add(testTimeout))
+	if _, err := conn.Write(packet); err != nil {
+		t.Fatalf("发送数据报失败: %v", err)
+	}
+
+	buf := make([]byte, 2048)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatalf("未收到回显: %v", err)
+	}
+	addr, frag, got, err := socks5.UnpackUDP(buf[:n])
+	if err != nil {
+		t.Fatalf("解析回显失败: %v", err)
+	}
+	if addr != echo.String() || frag != 0 || !bytes.Equal(got, payload) {
+		t.Errorf("回显为 %s 分片 %d %q，期望 %s 分片 0 %q", addr, frag, got, echo, payload)
+	}
+}
+
+func TestUDPAssociateNotSupported(t *testing.T) {
+	server := socks5test.NewServer(t, false)
+
+	_, err := socks5.UDPAssociate(server.Addr, testTimeout)
+	var replyErr *socks5.ReplyError
+	if !errors.As(err, &replyErr) || replyErr.Code != socks5.ReplyCommandNotSupported {
+		t.Fatalf("错误为 %v，期望 command not supported 应答", err)
+	}
+}
+
+func TestPackUnpackUDP(t *testing.T) {
+	for _, addr := range []string{"1.2.3.4:53", "[2001:db8::1]:443", "example.com:8080"} {
+		packet, err := socks5.PackUDP(addr, []byte("data"))
+		if err != nil {
+			t.Fatalf("%s: 封装失败: %v", addr, err)
+		}
+		got, frag, payload, err := socks5.UnpackUDP(packet)
+		if err != nil {
+			t.Fatalf("%s: 解析失败: %v", addr, err)
+		}
+		if got != addr || frag != 0 || string(payload) != "data" {
+			t.Errorf("%s: 解析为 %s 分片 %d %q", addr, got, frag, payload)
+		}
+	}
+}
@@ -0,0 +1,265 @@
+package socks5
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+)
+
+// SOCKS5 协议常量，参考 RFC 1928
+const (
+	Version5 = 0x05
+
+	MethodNoAuth       = 0x00
+	MethodUserPass     = 0x02
+	MethodNoAcceptable = 0xff
+
+	// 用户名密码认证的子协商版本与状态，参考 RFC 1929
+	UserPassVersion = 0x01
+	UserPassSuccess = 0x00
+	UserPassFailure = 0x01
+
+	CmdConnect      = 0x01
+	CmdBind         = 0x02
+	CmdUDPAssociate = 0x03
+
+	AtypIPv4   = 0x01
+	AtypDomain = 0x03
+	AtypIPv6   = 0x04
+
+	ReplySucceeded           = 0x00
+	ReplyGeneralFailure      = 0x01
+	ReplyNotAllowed          = 0x02
+	ReplyNetworkUnreachable  = 0x03
+	ReplyHostUnreachable     = 0x04
+	ReplyConnectionRefused   = 0x05
+	ReplyTTLExpired          = 0x06
+	ReplyCommandNotSupported = 0x07
+	ReplyAddressNotSupported = 0x08
+)
+
+// replyMessages 应答码对应的描述，与 golang.org/x/net 中的描述保持一致，便于统一分类错误
+var replyMessages = map[byte]string{
+	ReplySucceeded:           "succeeded",
+	ReplyGeneralFailure:      "general SOCKS server failure",
+	ReplyNotAllowed:          "connection not allowed by ruleset",
+	ReplyNetworkUnreachable:  "network unreachable",
+	ReplyHostUnreachable:     "host unreachable",
+	ReplyConnectionRefused:   "connection refused",
+	ReplyTTLExpired:          "TTL expired",
+	ReplyCommandNotSupported: "command not supported",
+	ReplyAddressNotSupported: "address type not supported",
+}
+
+// ReplyError 表示 SOCKS5 服务器返回了失败的应答
+type ReplyError struct {
+	Code byte
+}
+
+func (e *ReplyError) Error() string {
+	if msg, ok := replyMessages[e.Code]; ok {
+		return "socks5 应答错误: " + msg
+	}
+	return "socks5 应答错误: unknown code " + strconv.Itoa(int(e.Code))
+}
+
+// ReadAddr 读取 ATYP、地址与端口，返回 host:port 形式的地址
+func ReadAddr(r io.Reader) (string, error) {
+	var atyp [1]byte
+	if _, err := io.ReadFull(r, atyp[:]); err != nil {
+		return "", err
+	}
+
+	var host string
+	switch atyp[0] {
+	case AtypIPv4:
+		ip := make([]byte, net.IPv4len)
+		if _, err := io.ReadFull(r, ip); err != nil {
+			return "", err
+		}
+		host = net.IP(ip).String()
+	case AtypIPv6:
+		ip := make([]byte, net.IPv6len)
+		if _, err := io.ReadFull(r, ip); err != nil {
+			return "", err
+		}
+		host = net.IP(ip).String()
+	case AtypDomain:
+		var length [1]byte
+		if _, err := io.ReadFull(r, length[:]); err != nil {
+			return "", err
+		}
+		domain := make([]byte, length[0])
+		if _, err := io.ReadFull(r, domain); err != nil {
+			return "", err
+		}
+		host = string(domain)
+	default:
+		return "", fmt.Errorf("不支持的地址类型: %d", atyp[0])
+	}
+
+	var port [2]byte
+	if _, err := io.ReadFull(r, port[:]); err != nil {
+		return "", err
+	}
+
+	return net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port[:])))), nil
+}
+
+// AppendAddr 将 host:port 形式的地址编码为 ATYP、地址与端口并追加到 b
+func AppendAddr(b []byte, addr string) ([]byte, error) {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil || port < 0 || port > 0xffff {
+		return nil, fmt.Errorf("端口超出范围: %s", portStr)
+	}
+
+	if ip := net.ParseIP(host); ip != nil {
+		if ip4 := ip.To4(); ip4 != nil {
+			b = append(b, AtypIPv4)
+			b = append(b, ip4...)
+		} else {
+			b = append(b, AtypIPv6)
+			b = append(b, ip.To16()...)
+		}
+	} else {
+		if len(host) > 255 {
+			return nil, errors.New("域名过长")
+		}
+		b = append(b, AtypDomain, byte(len(host)))
+		b = append(b, host...)
+	}
+
+	return binary.BigEndian.AppendUint16(b, uint16(port)), nil
+}
+
+// ReadMethods 读取客户端的问候报文，返回客户端支持的认证方式，调用前版本号已被读取
+func ReadMethods(r io.Reader) ([]byte, error) {
+	var count [1]byte
+	if _, err := io.ReadFull(r, count[:]); err != nil {
+		return nil, err
+	}
+	methods := make([]byte, count[0])
+	if _, err := io.ReadFull(r, methods); err != nil {
+		return nil, err
+	}
+	return methods, nil
+}
+
+// ReadUserPass 读取客户端的用户名密码认证报文
+func ReadUserPass(r io.Reader) (string, string, error) {
+	var version [1]byte
+	if _, err := io.ReadFull(r, version[:]); err != nil {
+		return "", "", err
+	}
+	if version[0] != UserPassVersion {
+		return "", "", fmt.Errorf("不支持的用户名密码认证版本: %d", version[0])
+	}
+
+	username, err := readField(r)
+	if err != nil {
+		return "", "", err
+	}
+	password, err := readField(r)
+	if err != nil {
+		return "", "", err
+	}
+
+	return username, password, nil
+}
+
+// WriteUserPassStatus 向客户端写入用户名密码认证的结果
+func WriteUserPassStatus(w io.Writer, status byte) error {
+	_, err := w.Write([]byte{UserPassVersion, status})
+	return err
+}
+
+// readField 读取以一个字节长度开头的字段
+func readField(r io.Reader) (string, error) {
+	var length [1]byte
+	if _, err := io.ReadFull(r, length[:]); err != nil {
+		return "", err
+	}
+	field := make([]byte, length[0])
+	if _, err := io.ReadFull(r, field); err != nil {
+		return "", err
+	}
+	return string(field), nil
+}
+
+// ReadRequest 读取客户端的请求报文，返回命令与目标地址
+func ReadRequest(r io.Reader) (byte, string, error) {
+	var header [3]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return 0, "", err
+	}
+	if header[0] != Version5 {
+		return 0, "", fmt.Errorf("不支持的 SOCKS 版本: %d", header[0])
+	}
+
+	addr, err := ReadAddr(r)
+	if err != nil {
+		return 0, "", err
+	}
+
+	return header[1], addr, nil
+}
+
+// WriteReply 向客户端写入应答报文，bindAddr 为空时使用 0.0.0.0:0
+func WriteReply(w io.Writer, reply byte, bindAddr string) error {
+	if bindAddr == "" {
+		bindAddr = "0.0.0.0:0"
+	}
+
+	b, err := AppendAddr([]byte{Version5, reply, 0x00}, bindAddr)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(b)
+	return err
+}
+
+// PackUDP 按 RFC 1928 第 7 节为数据报添加 UDP 请求头
+func PackUDP(addr string, payload []byte) ([]byte, error) {
+	b, err := AppendAddr([]byte{0x00, 0x00, 0x00}, addr)
+	if err != nil {
+		return nil, err
+	}
+	return append(b, payload...), nil
+}
+
+// UnpackUDP 解析带 UDP 请求头的数据报，返回目标地址、分片号与数据
+func UnpackUDP(packet []byte) (string, byte, []byte, error) {
+	if len(packet) < 4 {
+		return "", 0, nil, errors.New("UDP 数据报过短")
+	}
+
+	r := &sliceReader{b: packet[3:]}
+	addr, err := ReadAddr(r)
+	if err != nil {
+		return "", 0, nil, err
+	}
+
+	return addr, packet[2], r.b, nil
+}
+
+// sliceReader 在字节切片上顺序读取，读取后剩余部分即为数据
+type sliceReader struct {
+	b []byte
+}
+
+func (r *sliceReader) Read(p []byte) (int, error) {
+	if len(r.b) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(p, r.b)
+	r.b = r.b[n:]
+	return n, nil
+}
@@ -0,0 +1,150 @@
+// Package socks5test 提供进程内的 SOCKS5 服务器与 UDP 回显服务器，用于测试 UDP 中继
+package socks5test
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"proxychain/socks5"
+	"testing"
+)
+
+// Server 只支持无认证与 UDP ASSOCIATE 的 SOCKS5 服务器，监听在 127.0.0.1 的随机端口
+type Server struct {
+	Addr string // host:port 形式的监听地址
+
+	listener net.Listener
+	udp      bool
+}
+
+// NewServer 启动 SOCKS5 服务器，udp 为 false 时对 UDP ASSOCIATE 返回 command not supported，
+// 测试结束时自动关闭
+func NewServer(tb testing.TB, udp bool) *Server {
+	tb.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		tb.Fatalf("监听 SOCKS5 端口失败: %v", err)
+	}
+
+	s := &Server{Addr: listener.Addr().String(), listener: listener, udp: udp}
+	go s.serve()
+	tb.Cleanup(s.Close)
+	return s
+}
+
+// URL 返回 socks5://host:port 形式的代理地址
+func (s *Server) URL() string {
+	return "socks5://" + s.Addr
+}
+
+// Close 停止接受新的连接，已有的关联在控制连接断开后结束
+func (s *Server) Close() {
+	s.listener.Close()
+}
+
+func (s *Server) serve() {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+// handle 完成无认证的协商，处理 UDP ASSOCIATE，控制连接断开后释放中继
+func (s *Server) handle(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+
+	version, err := reader.ReadByte()
+	if err != nil || version != socks5.Version5 {
+		return
+	}
+	if _, err := socks5.ReadMethods(reader); err != nil {
+		return
+	}
+	if _, err := conn.Write([]byte{socks5.Version5, socks5.MethodNoAuth}); err != nil {
+		return
+	}
+
+	cmd, _, err := socks5.ReadRequest(reader)
+	if err != nil {
+		return
+	}
+	if cmd != socks5.CmdUDPAssociate || !s.udp {
+		socks5.WriteReply(conn, socks5.ReplyCommandNotSupported, "")
+		return
+	}
+
+	relay, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		socks5.WriteReply(conn, socks5.ReplyGeneralFailure, "")
+		return
+	}
+	defer relay.Close()
+
+	if err := socks5.WriteReply(conn, socks5.ReplySucceeded, relay.LocalAddr().String()); err != nil {
+		return
+	}
+
+	go relayPackets(relay)
+	// 控制连接在关联期间不再有数据，读到 EOF 表示关联结束
+	io.Copy(io.Discard, reader)
+}
+
+// relayPackets 按 UDP 请求头转发数据报：来自客户端的数据报发往目标，其余的数据报加上来源地址后返回给客户端
+func relayPackets(relay *net.UDPConn) {
+	var client *net.UDPAddr
+	buf := make([]byte, 64*1024)
+	for {
+		n, addr, err := relay.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+
+		if client == nil || addr.String() == client.String() {
+			client = addr
+			target, _, payload, err := socks5.UnpackUDP(buf[:n])
+			if err != nil {
+				continue
+			}
+			targetAddr, err := net.ResolveUDPAddr("udp", target)
+			if err != nil {
+				continue
+			}
+			relay.WriteToUDP(payload, targetAddr)
+			continue
+		}
+
+		packet, err := socks5.PackUDP(addr.String(), buf[:n])
+		if err != nil {
+			continue
+		}
+		relay.WriteToUDP(packet, client)
+	}
+}
+
+// NewEchoServer 启动 UDP 回显服务器，原样返回收到的数据报，测试结束时自动关闭
+func NewEchoServer(tb testing.TB) *net.UDPAddr {
+	tb.Helper()
+
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		tb.Fatalf("监听 UDP 回显端口失败: %v", err)
+	}
+	tb.Cleanup(func() { conn.Close() })
+
+	go func() {
+		buf := make([]byte, 64*1024)
+		for {
+			n, addr, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			conn.WriteToUDP(buf[:n], addr)
+		}
+	}()
+	return conn.LocalAddr().(*net.UDPAddr)
+}